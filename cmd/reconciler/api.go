@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/api"
+)
+
+// `reconciler api` matches and adds single torrents on request, for a
+// browser extension or another service, over a small JSON API on --listen;
+// pkg/api is a client of it for Go programs:
+//
+//	POST /torrents             match a torrent: a multipart upload in the
+//	                           "torrent" part, or JSON {"torrent": path, URL
+//	                           or magnet}; either may name the "file" to
+//	                           match by, else the largest is used
+//	GET  /torrents/{id}        the run: its status and candidate dirs
+//	POST /torrents/{id}/add    add it, at the JSON {"dir"} among the
+//	                           candidates, or the one --resolve picked
+//	GET  /unmatched            the torrents of the daemon's last pass, and
+//	                           of the runs, without a match
+//	GET  /status               the server's runs by status
+//	GET  /metrics              as for daemon
+//	GET  /                     the dashboard; see dashboard.go
+//
+// Adds are made in the background; the run's status is "adding" until it
+// becomes the add's outcome. One match or add runs at a time. With
+// --api-token, requests must carry it as a bearer token, or as the
+// password of basic auth, as a browser sends it; without one, --listen
+// must be a loopback address. A JSON request may only name .torrent files
+// under --api-torrent-dir, and of the .torrent files the API matches, only
+// its uploads are moved to --processed-dir or --failed-dir. With
+// --grpc-listen, the API is also served over gRPC; see grpc.go.
+var apiToken string
+var apiTorrentDir string
+
+// largest .torrent upload taken
+const maxUpload = 16 << 20
+
+// runs kept for GET /torrents/{id}; the oldest are forgotten
+const maxAPIRuns = 1000
+
+// run statuses before an add; after one, the status is its outcome
+const (
+	runMatched   = api.StatusMatched
+	runUnmatched = api.StatusUnmatched
+	runPresent   = api.StatusPresent
+	runExcluded  = api.StatusExcluded
+	runFailed    = api.StatusFailed
+	runAdding    = api.StatusAdding
+	// by an approval link; see approval.go
+	runRejected = api.StatusRejected
+)
+
+// apiRun is a torrent submitted to the API, and what became of it. It's
+// served as the api.Run of pkg/api, so that the client decodes what the
+// server encodes.
+type apiRun struct {
+	api.Run
+
+	matches []*matchedFile
+	// closed when the add under way is done
+	added chan struct{}
+}
+
+// apiFailures returns errs as the API serves them.
+func apiFailures(errs []*pipelineError) []api.Failure {
+	var l []api.Failure
+	for _, e := range errs {
+		l = append(l, api.Failure{Kind: e.Kind, Torrent: e.Torrent, Error: e.Error})
+	}
+	return l
+}
+
+type apiServer struct {
+	db    *sql.DB
+	start time.Time
+	// held by each match, add and daemon pass, since the pipeline's stages
+	// share package state
+	pass sync.Mutex
+	// adds under way
+	adds sync.WaitGroup
+
+	mu    sync.Mutex
+	runs  map[string]*apiRun
+	order []string
+	// the run of each torrent held for approval
+	byTorrent map[string]*apiRun
+	// matches held back for approval by the current match or pass
+	holding []*matchedFile
+	// for the dashboard
+	last     *report
+	recent   []*recentMatch
+	trackers map[string]*trackerCounts
+}
+
+// approvals, if set, is sent the matches held back for approval.
+var approvals *apiServer
+
+func newAPIServer(db *sql.DB) *apiServer {
+	return &apiServer{
+		db:        db,
+		start:     time.Now(),
+		runs:      make(map[string]*apiRun),
+		byTorrent: make(map[string]*apiRun),
+		trackers:  make(map[string]*trackerCounts),
+	}
+}
+
+// serveAPI is `reconciler api`.
+func serveAPI(args []string) int {
+	if len(args) > 0 {
+		log.Fatal("api takes no arguments")
+	}
+	if review || resolve == "interactive" {
+		log.Fatal("--review and --resolve=interactive need a terminal and can't be used with api")
+	}
+	if emitScript != "" || verifyThreshold > 0 {
+		log.Fatal("--emit-script and --verify-threshold can't be used with api")
+	}
+	for _, check := range []func() error{checkSource, checkResume, checkPartial, checkPriority, checkProps, checkResolve, checkNormalize, checkNotify, checkEvents, checkMail, checkStatPaths, checkLinks, checkMark, checkFetchHeaders, checkDBFlags, checkAPI, checkApproval} {
+		if err := check(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	stats = newRunMetrics()
+	notify = newNotifier()
+	if notify != nil {
+		notify.perAdd = true
+	}
+	var err error
+	if events, err = openEvents(); err != nil {
+		log.Fatal(err)
+	}
+	defer events.Close()
+	ctx, stop := signalContext()
+	defer stop()
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return lockFailed(err)
+	}
+	defer release()
+	if err := runAPI(ctx); err != nil {
+		log.Fatal(err)
+	}
+	return exitOK
+}
+
+// runAPI serves the API until ctx is done.
+func runAPI(ctx context.Context) error {
+	if err := listRoots(ctx); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", catalogDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := checkCatalog(db); err != nil {
+		return err
+	}
+	cleanup, err := openFetchDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	s := newAPIServer(db)
+	approvals = s
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	s.routes(mux)
+	mux.Handle("GET /metrics", stats)
+	srv := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go srv.Serve(ln)
+	slog.Info("serving API", "addr", ln.Addr().String())
+	stopGRPC, err := s.serveGRPC()
+	if err != nil {
+		srv.Close()
+		return err
+	}
+	sdNotify("READY=1")
+
+	<-ctx.Done()
+	sdNotify("STOPPING=1")
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdown)
+	stopGRPC()
+	s.drain()
+	return nil
+}
+
+// routes registers the API and the dashboard on mux.
+func (s *apiServer) routes(mux *http.ServeMux) {
+	mux.Handle("POST /torrents", authorize(s.submit))
+	mux.Handle("GET /torrents/{id}", authorize(s.get))
+	mux.Handle("POST /torrents/{id}/add", authorize(s.confirm))
+	mux.Handle("GET /unmatched", authorize(s.unmatched))
+	mux.Handle("GET /status", authorize(s.status))
+	mux.Handle("GET /{$}", authorize(s.dashboard))
+	// the dashboard's buttons are forms, which another site could post
+	mux.Handle("POST /approve", http.NewCrossOriginProtection().Handler(authorize(s.approve)))
+	if approvalURL != "" {
+		// signed links carry their own authorization
+		mux.HandleFunc("GET /link", s.linkForm)
+		mux.Handle("POST /link", http.NewCrossOriginProtection().Handler(http.HandlerFunc(s.linkAction)))
+	}
+}
+
+// checkAPI takes --api-token from the environment if it isn't given, and
+// checks that the API is only served to other hosts with one.
+func checkAPI() error {
+	if apiToken == "" {
+		apiToken = os.Getenv("RECONCILER_API_TOKEN")
+	}
+	if apiToken == "" && !loopback(listenAddr) {
+		return fmt.Errorf("--listen %s serves the API to other hosts; set --api-token or $RECONCILER_API_TOKEN, or listen on localhost", listenAddr)
+	}
+	if apiToken == "" && grpcListen != "" && !loopback(grpcListen) {
+		return fmt.Errorf("--grpc-listen %s serves the API to other hosts; set --api-token or $RECONCILER_API_TOKEN, or listen on localhost", grpcListen)
+	}
+	if apiTorrentDir != "" {
+		dir, err := filepath.Abs(apiTorrentDir)
+		if err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		if err != nil {
+			return fmt.Errorf("--api-torrent-dir: %v", err)
+		}
+		apiTorrentDir = dir
+	}
+	return nil
+}
+
+// loopback reports whether addr, host:port, only listens on this host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize lets through requests with --api-token, if it's set.
+func authorize(h http.HandlerFunc) http.Handler {
+	if apiToken == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, token, ok = r.BasicAuth()
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+			w.Header().Add("WWW-Authenticate", "Bearer")
+			w.Header().Add("WWW-Authenticate", `Basic realm="reconciler"`)
+			apiError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// begin opens the state DB and the rest of what a match or add needs, as a
+// pass does. The returned function closes them.
+func (s *apiServer) begin() (*stateDB, func(), error) {
+	state, err := openState(statePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if infoCache, err = openCache(cachePath); err != nil {
+		state.Close()
+		return nil, nil, err
+	}
+	if failedAdds, err = openRetryQueue(); err != nil {
+		state.Close()
+		infoCache.Close()
+		return nil, nil, err
+	}
+	if results, err = openResults(s.db, time.Now()); err != nil {
+		state.Close()
+		infoCache.Close()
+		failedAdds.Close()
+		return nil, nil, err
+	}
+	return state, func() {
+		state.Close()
+		infoCache.Close()
+		failedAdds.Close()
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// submit is POST /torrents: it matches the torrent against the DB.
+func (s *apiServer) submit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+	tor, file, err := s.submitted(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	run, code, err := s.matchTorrent(r.Context(), tor, file)
+	if err != nil {
+		apiError(w, code, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, run)
+}
+
+// matchTorrent matches tor by its contained file, or its largest, and
+// keeps the run. On failure it returns the HTTP status to answer with.
+func (s *apiServer) matchTorrent(ctx context.Context, tor, file string) (*apiRun, int, error) {
+	tf := &torFile{tor: tor, file: file}
+	if file == "" {
+		var err error
+		if tf, err = wholeTorFile(ctx, tor); err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+	}
+	run := &apiRun{Run: api.Run{Torrent: tf.tor, File: tf.file, Created: time.Now()}}
+	s.match(ctx, run, tf)
+	s.keep(run)
+	return run, http.StatusCreated, nil
+}
+
+// submitted returns the torrent and contained file of a POST /torrents, an
+// upload being saved under fetchDir.
+func (s *apiServer) submitted(r *http.Request) (tor, file string, err error) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "multipart/form-data":
+		f, _, err := r.FormFile("torrent")
+		if err != nil {
+			return "", "", fmt.Errorf("torrent upload: %w", err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return "", "", err
+		}
+		path, err := saveUpload(data)
+		if err != nil {
+			return "", "", err
+		}
+		return path, r.FormValue("file"), nil
+	case "application/json":
+		var req struct {
+			Torrent string `json:"torrent"`
+			File    string `json:"file"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return "", "", err
+		}
+		tor, err := namedTorrent(req.Torrent)
+		if err != nil {
+			return "", "", err
+		}
+		return tor, req.File, nil
+	}
+	return "", "", errors.New("send multipart/form-data or application/json")
+}
+
+// saveUpload saves the uploaded .torrent data under fetchDir, returning its
+// path.
+func saveUpload(data []byte) (string, error) {
+	sum := sha1.Sum(data)
+	path := filepath.Join(fetchDir, "upload-"+hex.EncodeToString(sum[:])+".torrent")
+	if err := writeFetched(path, data); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// namedTorrent returns the torrent a request names: a URL, a magnet link
+// or a path under --api-torrent-dir.
+func namedTorrent(tor string) (string, error) {
+	if tor == "" {
+		return "", errors.New("no torrent given")
+	}
+	if isMagnet(tor) || isURL(tor) {
+		return tor, nil
+	}
+	return inTorrentDir(tor)
+}
+
+// inTorrentDir returns the .torrent file at path, which must be under
+// --api-torrent-dir once symlinks are followed.
+func inTorrentDir(path string) (string, error) {
+	if apiTorrentDir == "" {
+		return "", errors.New("torrent paths need --api-torrent-dir; upload the file, or send a URL or magnet")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(apiTorrentDir, path)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(apiTorrentDir, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under --api-torrent-dir", path)
+	}
+	return real, nil
+}
+
+// uploaded reports whether tor is an upload saved by submitted, the only
+// .torrent files the API moves.
+func uploaded(tor string) bool {
+	return filepath.Dir(tor) == filepath.Clean(fetchDir) && strings.HasPrefix(filepath.Base(tor), "upload-")
+}
+
+// match runs the matcher over tf alone, recording the result in run.
+func (s *apiServer) match(ctx context.Context, run *apiRun, tf *torFile) {
+	s.pass.Lock()
+	defer s.pass.Unlock()
+	moveOnly = uploaded
+	defer func() { moveOnly = nil }()
+	rep := newReport()
+	errc := make(chan *pipelineError)
+	eg := &sync.WaitGroup{}
+	eg.Add(1)
+	go rep.collect(errc, eg)
+	state, end, err := s.begin()
+	if err != nil {
+		errc <- failure(errState, tf.tor, err)
+		close(errc)
+		eg.Wait()
+		s.matched(run, nil, rep)
+		return
+	}
+	defer end()
+	c := make(chan *torFile, 1)
+	c <- tf
+	close(c)
+	// a torrent is matched at most once
+	o := make(chan *matchedFile, 1)
+	scanQueue = &queueStats{Name: "scan"}
+	matchQueue = &queueStats{Name: "match", Capacity: 1}
+	pg := &sync.WaitGroup{}
+	pg.Add(1)
+	matchDBFiles(ctx, s.db, state, c, o, rep, errc, pg)
+	close(o)
+	var matches []*matchedFile
+	if match, ok := <-o; ok {
+		matches = append(matches, match)
+		matches = append(matches, s.alternatives(ctx, tf, match, errc)...)
+	}
+	close(errc)
+	eg.Wait()
+	stats.record(rep, time.Now())
+	s.mu.Lock()
+	// a fuzzy match, held back for approval
+	matches = append(matches, s.holding...)
+	s.holding = nil
+	s.mu.Unlock()
+	s.matched(run, matches, rep)
+}
+
+// matched records in run the matches of its torrent.
+func (s *apiServer) matched(run *apiRun, matches []*matchedFile, rep *report) {
+	s.kept(rep)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.matches = matches
+	run.Failures = apiFailures(rep.Failures)
+	run.Updated = time.Now()
+	switch {
+	case len(matches) > 0:
+		run.Status = runMatched
+		run.InfoHash = matches[0].infoHash
+	case rep.Present > 0:
+		run.Status = runPresent
+	case rep.Excluded > 0:
+		run.Status = runExcluded
+	case len(rep.Failures) > 0:
+		run.Status = runFailed
+	default:
+		run.Status = runUnmatched
+	}
+	run.Candidates = nil
+	for _, m := range matches {
+		run.Candidates = append(run.Candidates, api.Candidate{
+			Dir:         m.dataDir,
+			DownloadDir: clientPath(m.path),
+			Client:      m.client,
+			Confidence:  m.confidence,
+			Download:    m.download,
+		})
+	}
+}
+
+// alternatives returns the matches of tf at the candidate dirs other than
+// match's.
+func (s *apiServer) alternatives(ctx context.Context, tf *torFile, match *matchedFile, errc chan<- *pipelineError) []*matchedFile {
+	if len(match.candidates) < 2 {
+		return nil
+	}
+	stmt, err := s.db.Prepare(lookupQuery())
+	if err != nil {
+		errc <- failure(errQuery, tf.tor, err)
+		return nil
+	}
+	defer stmt.Close()
+	existsQuery := ExistsQuery
+	if names().Canonical() {
+		existsQuery = ExistsLikeQuery
+	}
+	existsStmt, err := s.db.Prepare(catalogSQL(existsQuery))
+	if err != nil {
+		errc <- failure(errQuery, tf.tor, err)
+		return nil
+	}
+	defer existsStmt.Close()
+	var alts []*matchedFile
+	for _, dir := range match.candidates {
+		if dir == match.dataDir {
+			continue
+		}
+		m, err := newMatch(ctx, tf, dir, nil, stmt, existsStmt)
+		if err != nil {
+			errc <- matchFailure(tf.tor, err)
+			continue
+		}
+		m.confidence = match.confidence
+		alts = append(alts, m)
+	}
+	return alts
+}
+
+// keep gives run an ID and remembers it, forgetting the oldest run if
+// there are too many.
+func (s *apiServer) keep(run *apiRun) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.ID = hex.EncodeToString(b)
+	s.runs[run.ID] = run
+	s.order = append(s.order, run.ID)
+	if len(s.order) > maxAPIRuns {
+		old := s.runs[s.order[0]]
+		if s.byTorrent[old.Torrent] == old {
+			delete(s.byTorrent, old.Torrent)
+		}
+		delete(s.runs, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get is GET /torrents/{id}.
+func (s *apiServer) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[r.PathValue("id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, errors.New("no such run"))
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// confirm is POST /torrents/{id}/add: it starts adding the run's match at
+// the dir asked for.
+func (s *apiServer) confirm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Dir string `json:"dir"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	run, code, err := s.startAdd(r.PathValue("id"), req.Dir)
+	if err != nil {
+		apiError(w, code, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// startAdd starts adding the match of the run with id at dir, or at the
+// dir tried before or the resolved one. On failure it returns the HTTP
+// status to answer with.
+func (s *apiServer) startAdd(id, dir string) (*apiRun, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return nil, http.StatusNotFound, errors.New("no such run")
+	}
+	// a failed add may be tried again
+	if run.Status != runMatched && (run.Status != outcomeFailed || run.Dir == "") {
+		return nil, http.StatusConflict, fmt.Errorf("run is %s, not matched", run.Status)
+	}
+	if dir == "" {
+		dir = run.Dir
+	}
+	var match *matchedFile
+	for _, m := range run.matches {
+		if dir == "" || sameDir(m.dataDir, dir) {
+			match = m
+			break
+		}
+	}
+	if match == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("%s is not a candidate", dir)
+	}
+	run.Status = runAdding
+	run.Dir = match.dataDir
+	run.Updated = time.Now()
+	run.added = make(chan struct{})
+	s.adds.Add(1)
+	// an add outlives the request that asked for it
+	go s.add(context.Background(), run, match)
+	return run, http.StatusAccepted, nil
+}
+
+// add adds match through an adder of a normal run, and records the outcome
+// in run.
+func (s *apiServer) add(ctx context.Context, run *apiRun, match *matchedFile) {
+	defer s.adds.Done()
+	s.pass.Lock()
+	defer s.pass.Unlock()
+	// adds made here aren't waited for
+	verifies = nil
+	moveOnly = uploaded
+	defer func() { moveOnly = nil }()
+	rep := newReport()
+	errc := make(chan *pipelineError)
+	eg := &sync.WaitGroup{}
+	eg.Add(1)
+	go rep.collect(errc, eg)
+	state, end, err := s.begin()
+	if err == nil {
+		defer end()
+	}
+	// the clients are listed afresh, for torrents added since the match
+	var clients *clientPool
+	if err == nil {
+		clients, err = newClients()
+	}
+	if err == nil && resumeDir == "" && !offline {
+		err = clients.loadHashes(ctx)
+	}
+	if err == nil {
+		m := make(chan *matchedFile, 1)
+		m <- match
+		close(m)
+		cg := &sync.WaitGroup{}
+		cg.Add(1)
+		addTorrents(ctx, clients, state, m, rep, nil, nil, nil, &addQuota{}, errc, cg)
+	} else {
+		errc <- failure(errRPC, match.tor, err)
+		rep.outcome(match, outcomeFailed)
+	}
+	close(errc)
+	eg.Wait()
+	stats.record(rep, time.Now())
+	s.kept(rep)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Status = outcomeFailed
+	if len(rep.Matches) > 0 {
+		run.Status = rep.Matches[0].Outcome
+	}
+	run.Failures = append(run.Failures, apiFailures(rep.Failures)...)
+	run.Updated = time.Now()
+	close(run.added)
+}
+
+// unmatched is GET /unmatched.
+func (s *apiServer) unmatched(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.unmatchedTorrents())
+}
+
+// unmatchedTorrents returns the torrents of the daemon's last pass, and of
+// the runs, without a match.
+func (s *apiServer) unmatchedTorrents() []api.Unmatched {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	l := []api.Unmatched{}
+	if last != nil {
+		last.mu.Lock()
+		for _, tor := range last.UnmatchedTorrents {
+			l = append(l, api.Unmatched{Torrent: tor})
+		}
+		last.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.order {
+		if run := s.runs[id]; run.Status == runUnmatched {
+			l = append(l, api.Unmatched{Torrent: run.Torrent, Run: run.ID})
+		}
+	}
+	return l
+}
+
+// status is GET /status.
+func (s *apiServer) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make(map[string]int)
+	for _, run := range s.runs {
+		runs[run.Status]++
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"start": s.start,
+		"runs":  runs,
+	})
+}
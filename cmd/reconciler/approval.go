@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --approval-url, daemon --dashboard sends each match a pass holds
+// back for approval through --notify and --smtp, with links to approve it
+// at each candidate dir or to reject it, so it can be reviewed from a
+// phone. A link needs no --api-token: it names its run, dir and action,
+// expires after --approval-ttl, and is signed with HMAC-SHA256 under
+// --approval-secret, or $RECONCILER_APPROVAL_SECRET. --approval-url is the
+// dashboard's address as the phone reaches it. Opening a link shows a page
+// with a button to confirm, so link previews and mail scanners, which only
+// fetch it, can't act on it. A rejected run isn't sent again while the
+// daemon remembers it.
+var approvalURL, approvalSecret string
+var approvalTTL time.Duration
+
+const (
+	actionApprove = "approve"
+	actionReject  = "reject"
+)
+
+func checkApproval() error {
+	if approvalURL == "" {
+		return nil
+	}
+	if !dashboard {
+		return fmt.Errorf("--approval-url needs daemon --dashboard")
+	}
+	if u, err := url.Parse(approvalURL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("--approval-url must be an http(s) URL")
+	}
+	if len(notifyURLs) == 0 && smtpURL == "" {
+		return fmt.Errorf("--approval-url needs --notify or --smtp to send the links")
+	}
+	if approvalSecret == "" {
+		approvalSecret = os.Getenv("RECONCILER_APPROVAL_SECRET")
+	}
+	if len(approvalSecret) < 16 {
+		return fmt.Errorf("--approval-url needs an --approval-secret of at least 16 bytes")
+	}
+	if approvalTTL <= 0 {
+		return fmt.Errorf("--approval-ttl must be positive")
+	}
+	return nil
+}
+
+// linkSignature signs a link's parameters.
+func linkSignature(id, dir, action string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(approvalSecret))
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%d", id, dir, action, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// approvalLink returns the signed link to take action on the run id, at dir
+// for an approval.
+func approvalLink(id, dir, action string) string {
+	expires := time.Now().Add(approvalTTL).Unix()
+	q := url.Values{
+		"id":      {id},
+		"action":  {action},
+		"expires": {strconv.FormatInt(expires, 10)},
+		"sig":     {linkSignature(id, dir, action, expires)},
+	}
+	if dir != "" {
+		q.Set("dir", dir)
+	}
+	return strings.TrimSuffix(approvalURL, "/") + "/link?" + q.Encode()
+}
+
+// signedLink is the action a link's parameters, in form, ask for.
+type signedLink struct {
+	ID, Dir, Action, Expires, Sig string
+}
+
+// checkLink returns the link of the request's parameters, if it's signed
+// and unexpired.
+func checkLink(form url.Values) (*signedLink, error) {
+	l := &signedLink{form.Get("id"), form.Get("dir"), form.Get("action"), form.Get("expires"), form.Get("sig")}
+	if l.Action != actionApprove && l.Action != actionReject {
+		return nil, errors.New("invalid action")
+	}
+	expires, err := strconv.ParseInt(l.Expires, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid expiry")
+	}
+	if !hmac.Equal([]byte(l.Sig), []byte(linkSignature(l.ID, l.Dir, l.Action, expires))) {
+		return nil, errors.New("invalid signature")
+	}
+	if time.Now().Unix() > expires {
+		return nil, errors.New("link has expired")
+	}
+	return l, nil
+}
+
+// announce sends the links to approve or reject run.
+func (s *apiServer) announce(run *apiRun) {
+	if approvalURL == "" {
+		return
+	}
+	s.mu.Lock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nfile %s\n", run.Torrent, run.File)
+	for _, c := range run.Candidates {
+		fmt.Fprintf(&b, "\napprove at %s (%s):\n%s\n", c.Dir, c.Confidence, approvalLink(run.ID, c.Dir, actionApprove))
+	}
+	fmt.Fprintf(&b, "\nreject:\n%s\n", approvalLink(run.ID, "", actionReject))
+	s.mu.Unlock()
+	title := "reconciler: match awaiting approval"
+	notify.send(title, b.String(), false)
+	mail.send(title, b.String())
+}
+
+// reject rejects the run with id, held for approval. On failure it returns
+// the HTTP status to answer with.
+func (s *apiServer) reject(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return http.StatusNotFound, errors.New("no such run")
+	}
+	if run.Status != runMatched {
+		return http.StatusConflict, fmt.Errorf("run is %s, not matched", run.Status)
+	}
+	run.Status = runRejected
+	run.Updated = time.Now()
+	slog.Info("rejected by link", "torrent", run.Torrent, "run", run.ID)
+	return http.StatusOK, nil
+}
+
+// linkForm is GET /link, from a signed link: a page confirming its action.
+func (s *apiServer) linkForm(w http.ResponseWriter, r *http.Request) {
+	l, err := checkLink(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	run, ok := s.runs[l.ID]
+	var torrent, status string
+	if ok {
+		torrent, status = run.Torrent, run.Status
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such run", http.StatusNotFound)
+		return
+	}
+	linkPage(w, map[string]any{"Link": l, "Torrent": torrent, "Status": status})
+}
+
+// linkAction is POST /link, from linkForm's page: it takes the link's action.
+func (s *apiServer) linkAction(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l, err := checkLink(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var code int
+	if l.Action == actionApprove {
+		_, code, err = s.startAdd(l.ID, l.Dir)
+	} else {
+		code, err = s.reject(l.ID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	done := "Rejected."
+	if l.Action == actionApprove {
+		done = "Adding at " + l.Dir + "."
+	}
+	linkPage(w, map[string]any{"Done": done})
+}
+
+func linkPage(w http.ResponseWriter, data map[string]any) {
+	var b bytes.Buffer
+	if err := linkTemplate.Execute(&b, data); err != nil {
+		slog.Error("rendering link page", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+var linkTemplate = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>reconciler</title>
+<style>
+body { font-family: sans-serif; margin: 1.5em; color: #222; }
+.path { font-family: monospace; word-break: break-all; }
+button { font-size: 1.1em; padding: .4em 1.2em; }
+</style>
+</head>
+<body>
+{{- with .Done}}
+<p>{{.}}</p>
+{{- else}}
+{{- with .Link}}
+<p class="path">{{$.Torrent}}</p>
+{{- if eq .Action "approve"}}
+<p>Add it at <span class="path">{{.Dir}}</span>?</p>
+{{- else}}
+<p>Reject this match?</p>
+{{- end}}
+{{- if ne $.Status "matched"}}
+<p>It is {{$.Status}}.</p>
+{{- end}}
+<form method="post" action="link">
+<input type="hidden" name="id" value="{{.ID}}">
+<input type="hidden" name="dir" value="{{.Dir}}">
+<input type="hidden" name="action" value="{{.Action}}">
+<input type="hidden" name="expires" value="{{.Expires}}">
+<input type="hidden" name="sig" value="{{.Sig}}">
+<button>{{.Action}}</button>
+</form>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// With --source=arr, files come from the Sonarr and Radarr instances at
+// arrURLs, each with its API key as the apikey query parameter. Each file's
+// original release name is kept too, since torrents are named after the
+// release rather than the renamed file: a contained file with no match by
+// name is looked up by release name, and the torrent is renamed to the file
+// on disk as for a fuzzy match.
+var arrURLs stringList
+
+// timeout of each request to Sonarr, Radarr or Jellyfin
+const apiTimeout = time.Minute
+
+// ReleaseQuery finds the file imported from a release file.
+const ReleaseQuery = "select path, file from releases where name = ? limit 1"
+
+// arrFile is a file as Sonarr's episodefile and Radarr's movieFile show it.
+type arrFile struct {
+	Path      string `json:"path"`
+	SceneName string `json:"sceneName"`
+	// the file as it was in the download, relative to the download dir
+	OriginalFilePath string `json:"originalFilePath"`
+}
+
+// releaseNames returns the names f may have in a torrent.
+func (f *arrFile) releaseNames() []string {
+	var names []string
+	if f.OriginalFilePath != "" {
+		names = append(names, path.Base(f.OriginalFilePath))
+	}
+	if f.SceneName != "" {
+		if n := f.SceneName + path.Ext(f.Path); !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+// apiName is u for messages, without the query holding its API key.
+func apiName(u *url.URL) string {
+	v := *u
+	v.RawQuery = ""
+	return v.Redacted()
+}
+
+// getJSON decodes the reply to a GET of the API path at base with query,
+// in place of base's own, sending header, which holds the API key.
+func getJSON(ctx context.Context, base *url.URL, apiPath string, query url.Values, header map[string]string, out interface{}) error {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + apiPath
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", apiName(&u), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// arrGet is getJSON for Sonarr and Radarr, whose base URL has the API key
+// as its apikey parameter.
+func arrGet(ctx context.Context, base *url.URL, apiPath string, query url.Values, out interface{}) error {
+	return getJSON(ctx, base, apiPath, query, map[string]string{"X-Api-Key": base.Query().Get("apikey")}, out)
+}
+
+// listArr calls add with the dir and name of each file the instances know
+// of, and release with each of its release names.
+func listArr(ctx context.Context, add func(dir, file string) error, release func(name, dir, file string) error) error {
+	for _, raw := range arrURLs {
+		base, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		var status struct {
+			AppName string `json:"appName"`
+		}
+		if err := arrGet(ctx, base, "/api/v3/system/status", nil, &status); err != nil {
+			return err
+		}
+		var files []*arrFile
+		switch status.AppName {
+		case "Sonarr":
+			files, err = sonarrFiles(ctx, base)
+		case "Radarr":
+			files, err = radarrFiles(ctx, base)
+		default:
+			err = fmt.Errorf("%s: not Sonarr or Radarr but %q", apiName(base), status.AppName)
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("listed files", "app", status.AppName, "url", apiName(base), "files", len(files))
+		for _, f := range files {
+			dir, file := path.Split(slashed(f.Path))
+			dir = strings.TrimSuffix(dir, "/")
+			if err := add(dir, file); err != nil {
+				return err
+			}
+			for _, name := range f.releaseNames() {
+				if err := release(name, dir, file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sonarrFiles(ctx context.Context, base *url.URL) ([]*arrFile, error) {
+	var series []struct {
+		ID int `json:"id"`
+	}
+	if err := arrGet(ctx, base, "/api/v3/series", nil, &series); err != nil {
+		return nil, err
+	}
+	var files []*arrFile
+	for _, s := range series {
+		var eps []*arrFile
+		if err := arrGet(ctx, base, "/api/v3/episodefile", url.Values{"seriesId": {fmt.Sprint(s.ID)}}, &eps); err != nil {
+			return nil, err
+		}
+		files = append(files, eps...)
+	}
+	return files, nil
+}
+
+func radarrFiles(ctx context.Context, base *url.URL) ([]*arrFile, error) {
+	var movies []struct {
+		MovieFile *arrFile `json:"movieFile"`
+	}
+	if err := arrGet(ctx, base, "/api/v3/movie", nil, &movies); err != nil {
+		return nil, err
+	}
+	var files []*arrFile
+	for _, m := range movies {
+		if m.MovieFile != nil {
+			files = append(files, m.MovieFile)
+		}
+	}
+	return files, nil
+}
+
+// releaseMatch looks up tf's contained file by release name, returning the
+// download dir and renames that line the torrent up with the file it was
+// imported as, or false if it wasn't.
+func releaseMatch(ctx context.Context, stmt *sql.Stmt, tf *torFile) (string, []rename, bool, error) {
+	ti, err := tf.meta(ctx)
+	if err != nil {
+		return "", nil, false, err
+	}
+	p, ok := names().TorrentPath(ti, tf.file, tf.size)
+	if !ok {
+		p = tf.file
+	}
+	var dir, file string
+	err = withRetry(ctx, "query", transientDB, func() error {
+		ctx, cancel := dbContext()
+		defer cancel()
+		return stmt.QueryRowContext(ctx, path.Base(p)).Scan(&dir, &file)
+	})
+	if err == sql.ErrNoRows {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	if excluded(dir + "/" + file) {
+		slog.Debug("excluded", "path", dir+"/"+file)
+		return "", nil, false, nil
+	}
+	dir, renames, ok := fuzzyPlace(p, &fuzzyResult{dir: dir, file: file})
+	return dir, renames, ok, nil
+}
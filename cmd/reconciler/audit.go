@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// With --prune, audit removes the torrents, but not their data, that have
+// none of their wanted files anywhere in the DB, or with --prune-after,
+// that have had none in that many audits in a row, as counted in the
+// state DB. --prune-dry-run only says what would be removed.
+var prune, pruneDryRun bool
+var pruneAfter int
+
+func checkPruneFlags() error {
+	if pruneAfter < 1 {
+		return fmt.Errorf("--prune-after must be at least 1")
+	}
+	if pruneAfter > 1 && statePath == "" {
+		return fmt.Errorf("--prune-after needs --state to count audits")
+	}
+	return nil
+}
+
+// auditResult is what the DB says about a torrent a client has.
+type auditResult struct {
+	client *endpoint
+	t      client.Torrent
+	// wanted files, and those missing from the download dir
+	paths   []string
+	missing []int
+	// a download dir at which the DB has every file, if the torrent's dir
+	// is missing some
+	movedTo string
+	// with --prune, whether the DB has none of the files anywhere
+	nowhere bool
+}
+
+// audit checks the torrents every client has against the DB and prints
+// those whose wanted files aren't all in the DB under their download dir:
+// moved, when the DB has them all under another dir, and otherwise
+// missing. The client must see the data at the paths the DB has. With
+// --prune, it then removes torrents whose data is gone.
+func audit(args []string) int {
+	if err := checkSource(); err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 {
+		log.Fatal("audit takes no arguments")
+	}
+	if err := checkPruneFlags(); err != nil {
+		log.Fatal(err)
+	}
+	state, err := openState(statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer state.Close()
+	ctx, stop := signalContext()
+	defer stop()
+	if prune && !pruneDryRun {
+		release, err := acquireLock(ctx)
+		if err != nil {
+			return lockFailed(err)
+		}
+		defer release()
+	}
+	if err := listRoots(ctx); err != nil {
+		log.Fatal(err)
+	}
+	results, err := auditClients(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var moved, missing, pruned int
+	failed := false
+	for _, r := range results {
+		switch {
+		case r.movedTo != "":
+			moved++
+			fmt.Printf("moved\t%s\t%s\t%s -> %s\n", r.client.name, r.t.Name, r.t.DownloadDir, r.movedTo)
+		case len(r.missing) > 0:
+			missing++
+			fmt.Printf("missing\t%s\t%s\t%s\t%d/%d files\n", r.client.name, r.t.Name, r.t.DownloadDir, len(r.missing), len(r.paths))
+		}
+		if !prune || ctx.Err() != nil {
+			continue
+		}
+		ok, err := pruneTorrent(ctx, state, r)
+		if err != nil {
+			slog.Error("pruning", "client", r.client.name, "torrent", r.t.Name, "err", err)
+			failed = true
+		}
+		if ok {
+			pruned++
+		}
+	}
+	slog.Info("audit", "torrents", len(results), "moved", moved, "missing", missing, "pruned", pruned)
+	if failed {
+		return exitRPC
+	}
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	if moved+missing > 0 {
+		return exitUnmatched
+	}
+	return exitOK
+}
+
+// auditClients audits every torrent of every client.
+func auditClients(ctx context.Context) ([]*auditResult, error) {
+	db, err := sql.Open("sqlite3", catalogDSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := checkCatalog(db); err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare(lookupQuery())
+	if err != nil {
+		return nil, err
+	}
+	existsQuery := ExistsQuery
+	if names().Canonical() {
+		existsQuery = ExistsLikeQuery
+	}
+	existsStmt, err := db.Prepare(catalogSQL(existsQuery))
+	if err != nil {
+		return nil, err
+	}
+	clients, err := newClients()
+	if err != nil {
+		return nil, err
+	}
+	var results []*auditResult
+	for _, cl := range clients.clients {
+		if cl.dedupeOnly {
+			// not ours to check or prune
+			continue
+		}
+		var torrents []client.Torrent
+		err := withRetry(ctx, "list torrents", client.Transient, func() (err error) {
+			torrents, err = cl.rpc.TorrentFiles(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("client %q: %v", cl.name, err)
+		}
+		for _, t := range torrents {
+			if ctx.Err() != nil {
+				return results, nil
+			}
+			r, err := auditTorrent(ctx, stmt, existsStmt, t)
+			if err != nil {
+				return nil, err
+			}
+			r.client = cl
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func auditTorrent(ctx context.Context, stmt, existsStmt *sql.Stmt, t client.Torrent) (*auditResult, error) {
+	r := &auditResult{t: t}
+	var paths []string
+	for i, f := range t.Files {
+		if i < len(t.Wanted) && !t.Wanted[i] {
+			continue
+		}
+		paths = append(paths, f.Name)
+	}
+	r.paths = paths
+	missing, err := missingFiles(ctx, existsStmt, t.DownloadDir, paths)
+	if err != nil {
+		return nil, err
+	}
+	r.missing = missing
+	if len(missing) == 0 {
+		return r, nil
+	}
+	// look for the first missing file elsewhere, then for the rest beside it
+	p := paths[missing[0]]
+	var found []string
+	err = withRetry(ctx, "query", transientDB, func() (err error) {
+		found, err = lookup(stmt, "/"+p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, fullpath := range found {
+		dir, ok := names().CutSuffix(fullpath, p)
+		if !ok || names().Same(strings.TrimSuffix(dir, "/"), strings.TrimSuffix(t.DownloadDir, "/")) {
+			continue
+		}
+		elsewhere, err := missingFiles(ctx, existsStmt, dir, paths)
+		if err != nil {
+			return nil, err
+		}
+		if len(elsewhere) == 0 {
+			r.movedTo = dir
+			break
+		}
+	}
+	if prune && r.movedTo == "" && len(missing) == len(paths) {
+		r.nowhere = len(found) == 0
+		for _, i := range missing[1:] {
+			if !r.nowhere {
+				break
+			}
+			err = withRetry(ctx, "query", transientDB, func() (err error) {
+				found, err = lookup(stmt, "/"+paths[i])
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			r.nowhere = len(found) == 0
+		}
+	}
+	slog.Debug("audited", "torrent", t.Name, "missing", len(r.missing), "moved", r.movedTo, "nowhere", r.nowhere)
+	return r, nil
+}
+
+// pruneTorrent removes r's torrent from its client if its data has been
+// nowhere in the DB for pruneAfter audits, and reports whether it did or,
+// with --prune-dry-run, would have.
+func pruneTorrent(ctx context.Context, state *stateDB, r *auditResult) (bool, error) {
+	if !r.nowhere {
+		return false, state.foundAudit(r.t.HashString)
+	}
+	audits, err := state.missingAudit(r.t.HashString)
+	if err != nil {
+		return false, err
+	}
+	if audits < pruneAfter {
+		slog.Info("data missing; not pruning yet", "client", r.client.name, "torrent", r.t.Name, "audits", audits, "prune_after", pruneAfter)
+		return false, nil
+	}
+	if pruneDryRun {
+		fmt.Printf("would prune\t%s\t%s\t%s\n", r.client.name, r.t.Name, r.t.HashString)
+		return true, nil
+	}
+	err = withRetry(ctx, "remove", client.Transient, func() error {
+		return r.client.rpc.Remove(ctx, r.t.ID)
+	})
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("pruned\t%s\t%s\t%s\n", r.client.name, r.t.Name, r.t.HashString)
+	return true, state.foundAudit(r.t.HashString)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+// With lookupBatch above 1, the contained files of up to that many
+// torrents are looked up in one query, by exact basename, before they're
+// matched. A batch is whatever the scanner has ready, so --scan-buffer lets
+// batches fill. Canonical matching still queries file by file.
+var lookupBatch int
+
+// SQLite's default limit on query parameters
+const maxLookupBatch = 999
+
+const BatchLookupQuery = "select {path} || '/' || {file}, {file} from {files} where {file} in (%s)"
+
+func checkLookupBatch() error {
+	if lookupBatch < 0 || lookupBatch > maxLookupBatch {
+		return fmt.Errorf("--lookup-batch must be between 0 and %d", maxLookupBatch)
+	}
+	return nil
+}
+
+// prefetchLookups passes on the torrents from i, with the DB files for each
+// batch of them looked up first.
+func prefetchLookups(ctx context.Context, db *sql.DB, i <-chan *torFile) <-chan *torFile {
+	if lookupBatch <= 1 || names().Canonical() {
+		return i
+	}
+	o := make(chan *torFile)
+	go func() {
+		defer close(o)
+		for tf := range i {
+			batch := []*torFile{tf}
+		fill:
+			for len(batch) < lookupBatch {
+				select {
+				case tf, ok := <-i:
+					if !ok {
+						break fill
+					}
+					batch = append(batch, tf)
+				default:
+					break fill
+				}
+			}
+			if ctx.Err() == nil {
+				err := withRetry(ctx, "query", transientDB, func() error {
+					return lookupBatchFiles(db, batch)
+				})
+				if err != nil {
+					// each is queried on its own instead
+					slog.Warn("batch lookup failed", "torrents", len(batch), "err", err)
+				}
+			}
+			for _, tf := range batch {
+				o <- tf
+			}
+		}
+	}()
+	return o
+}
+
+// lookupBatchFiles sets found for each torrent in batch that's matched by
+// a contained file: the DB files ending in it, as lookup would return.
+func lookupBatchFiles(db *sql.DB, batch []*torFile) error {
+	defer stats.observeQuery(time.Now())
+	var args []interface{}
+	seen := make(map[string]bool)
+	for _, tf := range batch {
+		if tf.byName {
+			continue
+		}
+		if base := path.Base(tf.file); !seen[base] {
+			seen[base] = true
+			args = append(args, base)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	slog.Debug("batch lookup", "torrents", len(batch), "files", len(args))
+	query := fmt.Sprintf(catalogSQL(BatchLookupQuery), strings.TrimSuffix(strings.Repeat("?,", len(args)), ","))
+	ctx, cancel := dbContext()
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byBase := make(map[string][]string)
+	for rows.Next() {
+		var fullpath, file string
+		if err := rows.Scan(&fullpath, &file); err != nil {
+			return err
+		}
+		byBase[file] = append(byBase[file], fullpath)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, tf := range batch {
+		if tf.byName {
+			continue
+		}
+		tf.found = []string{}
+		for _, fullpath := range byBase[path.Base(tf.file)] {
+			if strings.HasSuffix(fullpath, tf.file) {
+				tf.found = append(tf.found, fullpath)
+			}
+		}
+	}
+	return nil
+}
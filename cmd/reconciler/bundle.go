@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// how much of the end of --log-file to include
+const bundleLogBytes = 1 << 20
+
+// secret flags are redacted from the bundled configuration
+var secretFlags = map[string]bool{
+	"p": true,
+	// webhook URLs usually embed a token
+	"notify": true,
+	// likely session cookies or tracker credentials
+	"fetch-header": true,
+}
+
+// debugBundle writes an archive of the information needed to reproduce a
+// problem: configuration, recent logs, catalog schema and stats, client
+// version, and the last run report. It takes the same flags as a normal run,
+// and the archive name as its only argument.
+func debugBundle(args []string) int {
+	out := fmt.Sprintf("reconciler-debug-%s.tar.gz", time.Now().Format("20060102-150405"))
+	if len(args) > 1 {
+		log.Fatalf("usage: debug-bundle [flags] [archive.tar.gz]")
+	}
+	if len(args) == 1 {
+		out = args[0]
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	add("config.txt", bundleConfig())
+	if logFile != "" {
+		add("log.txt", bundleTail(logFile, bundleLogBytes))
+	}
+	if reportPath != "" {
+		data, err := os.ReadFile(reportPath)
+		if err != nil {
+			data = []byte(err.Error() + "\n")
+		}
+		add("report.json", data)
+	}
+	if dbFile != "" {
+		add("catalog.txt", bundleCatalog(dbFile))
+	}
+	add("client.json", bundleClient())
+
+	if err := tw.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("wrote debug bundle", "path", out)
+	return exitOK
+}
+
+func bundleConfig() []byte {
+	var b bytes.Buffer
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch {
+		case secretFlags[f.Name] && v != "":
+			v = "REDACTED"
+		case f.Name == "server":
+			if u, err := url.Parse(v); err == nil && u.User != nil {
+				v = u.Redacted()
+			}
+		}
+		fmt.Fprintf(&b, "%s=%s\n", f.Name, v)
+	})
+	return b.Bytes()
+}
+
+// bundleTail returns up to n bytes from the end of the file at path.
+func bundleTail(path string, n int64) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return []byte(err.Error() + "\n")
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > n {
+		f.Seek(-n, io.SeekEnd)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		data = append(data, []byte("\n"+err.Error()+"\n")...)
+	}
+	return data
+}
+
+// bundleCatalog describes the schema and table sizes of the files DB.
+func bundleCatalog(path string) []byte {
+	var b bytes.Buffer
+	db, err := sql.Open("sqlite3", sqliteDSN(path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		fmt.Fprintln(&b, err)
+		return b.Bytes()
+	}
+	defer db.Close()
+	rows, err := db.Query("select type, name, sql from sqlite_master where sql is not null")
+	if err != nil {
+		fmt.Fprintln(&b, err)
+		return b.Bytes()
+	}
+	var tables []string
+	for rows.Next() {
+		var typ, name, schema string
+		if err := rows.Scan(&typ, &name, &schema); err != nil {
+			fmt.Fprintln(&b, err)
+			break
+		}
+		fmt.Fprintf(&b, "%s;\n", schema)
+		if typ == "table" {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	fmt.Fprintln(&b)
+	for _, table := range tables {
+		var n int64
+		// table names come from sqlite_master; quote them anyway
+		err := db.QueryRow(fmt.Sprintf("select count(*) from %q", table)).Scan(&n)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %v\n", table, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %d rows\n", table, n)
+	}
+	return b.Bytes()
+}
+
+func bundleClient() []byte {
+	info := make(map[string]interface{})
+	clients, err := newClients()
+	if err != nil {
+		info["error"] = err.Error()
+		clients = &clientPool{}
+	}
+	ctx := context.Background()
+	for _, e := range clients.clients {
+		ci := make(map[string]interface{})
+		session, err := e.rpc.SessionInfo(ctx)
+		ci["session"] = session
+		if err == nil {
+			var torrents []client.Torrent
+			torrents, err = e.rpc.Torrents(ctx)
+			ci["torrents"] = len(torrents)
+		}
+		if err != nil {
+			ci["error"] = err.Error()
+		}
+		info[e.name] = ci
+	}
+	data, _ := json.MarshalIndent(info, "", "  ")
+	return append(data, '\n')
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// Parsed torrents are cached in a SQLite table keyed by path, and reused
+// while the file's mtime and size are unchanged.
+var cachePath string
+var infoCache *metaCache
+
+// cacheFormat is bumped when metainfo.Info gains fields, or parsing gets
+// stricter, so that entries without them are parsed again.
+const cacheFormat = 3
+
+type cacheEntry struct {
+	Format int `json:"format"`
+	*metainfo.Info
+}
+
+const cacheSchema = `
+create table if not exists metainfo_cache (
+	path text primary key,
+	mtime integer not null,
+	size integer not null,
+	info text not null
+)`
+
+type metaCache struct {
+	db *sql.DB
+}
+
+// openCache opens or creates the cache at path. An empty path yields a nil
+// *metaCache, which caches nothing.
+func openCache(path string) (*metaCache, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("sqlite3", sqliteDSN(path, nil))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(cacheSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &metaCache{db}, nil
+}
+
+func (c *metaCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// loadTorrent returns the parsed torrent at filename, from the cache if
+// possible.
+func loadTorrent(filename string) (*metainfo.Info, error) {
+	c := infoCache
+	if c == nil {
+		return metainfo.ParseFile(filename)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, &metainfo.ParseError{File: filename, Err: err}
+	}
+	mtime, size := fi.ModTime().UnixNano(), fi.Size()
+	ctx, cancel := dbContext()
+	defer cancel()
+	var data string
+	err = c.db.QueryRowContext(ctx, "select info from metainfo_cache where path = ? and mtime = ? and size = ?",
+		filename, mtime, size).Scan(&data)
+	if err == nil {
+		e := cacheEntry{Info: &metainfo.Info{}}
+		if err := json.Unmarshal([]byte(data), &e); err == nil && e.Format == cacheFormat {
+			return e.Info, nil
+		}
+	}
+	ti, err := metainfo.ParseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// the torrent parsed; failing to cache it only means parsing it again
+	// next time
+	b, err := json.Marshal(cacheEntry{cacheFormat, ti})
+	if err == nil {
+		_, err = c.db.ExecContext(ctx, `insert into metainfo_cache (path, mtime, size, info) values (?, ?, ?, ?)
+		on conflict (path) do update set mtime = excluded.mtime, size = excluded.size, info = excluded.info`,
+			filename, mtime, size, string(b))
+	}
+	if err != nil {
+		slog.Warn("caching parsed torrent", "torrent", filename, "err", err)
+	}
+	return ti, nil
+}
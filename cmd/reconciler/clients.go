@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// clientConfig describes one Transmission instance in the config file.
+// Server, RPCPath and Proxy are interpreted as for --server, --rpc-path and
+// --proxy; Proxy defaults to --proxy. A DedupeOnly client is only listed:
+// its torrents count as present, so another daemon on the same box doesn't
+// seed them a second time, but nothing is ever added to it.
+type clientConfig struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	RPCPath  string `json:"rpc_path,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	SSL      bool   `json:"ssl,omitempty"`
+	Proxy    string `json:"proxy,omitempty"`
+
+	DedupeOnly bool `json:"dedupe_only,omitempty"`
+}
+
+// With --offline, the clients are never contacted: the whole pipeline runs,
+// but each add is only recorded in the report, as an offline match, so
+// filters and path maps can be tried out safely. No passwords are needed.
+var offline bool
+
+type endpoint struct {
+	name       string
+	rpc        client.TorrentClient
+	dedupeOnly bool
+	// for the commands of --emit-script
+	url, username string
+}
+
+// clientPool is the set of clients a run adds to. Every client's torrents
+// count as already present, whichever client a torrent would be routed to,
+// including those of clients that are only listed.
+type clientPool struct {
+	clients []*endpoint
+	// the clients torrents are routed to: all but the dedupe-only ones
+	targets []*endpoint
+	byName  map[string]*endpoint
+
+	mu sync.Mutex
+	// info hash to the name of the client that has it
+	hashes map[string]string
+	// info hash to the torrent as listed at the start of the run
+	listed map[string]*listedTorrent
+	next   int
+}
+
+type listedTorrent struct {
+	e *endpoint
+	t client.Torrent
+}
+
+// newClients connects to the clients in the config file or, if there are
+// none, the one given by flags.
+func newClients() (*clientPool, error) {
+	transport, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	hc := &http.Client{Transport: transport}
+	confs := cfg.Clients
+	if len(confs) == 0 {
+		confs = []*clientConfig{{
+			Name:     "default",
+			Server:   server,
+			RPCPath:  rpcPath,
+			Username: username,
+			Password: password,
+			SSL:      ssl,
+		}}
+	}
+	p := &clientPool{
+		byName: make(map[string]*endpoint),
+		hashes: make(map[string]string),
+		listed: make(map[string]*listedTorrent),
+	}
+	for _, c := range confs {
+		url, err := client.URL(c.Server, c.RPCPath, c.SSL)
+		if err != nil {
+			return nil, fmt.Errorf("client %q: %v", c.Name, err)
+		}
+		hc := hc
+		if c.Proxy != "" {
+			t, err := withProxy(transport, c.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("client %q: %v", c.Name, err)
+			}
+			hc = &http.Client{Transport: t}
+		}
+		pw := c.Password
+		if !offline {
+			if pw, err = clientPassword(url, c.Username, c.Password); err != nil {
+				return nil, fmt.Errorf("client %q: %v", c.Name, err)
+			}
+		}
+		rpc := client.NewTransmission(url, c.Username, pw, hc)
+		rpc.Observe = stats.observeRPC
+		rpc.Timeout = rpcTimeout
+		e := &endpoint{c.Name, rpc, c.DedupeOnly, url, c.Username}
+		p.clients = append(p.clients, e)
+		if !e.dedupeOnly {
+			p.targets = append(p.targets, e)
+		}
+		p.byName[c.Name] = e
+	}
+	return p, nil
+}
+
+// loadHashes records the torrents every client already has.
+func (p *clientPool) loadHashes(ctx context.Context) error {
+	for _, e := range p.clients {
+		var torrents []client.Torrent
+		err := withRetry(ctx, "list torrents", client.Transient, func() (err error) {
+			torrents, err = e.rpc.Torrents(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("client %q: %v", e.name, err)
+		}
+		for _, t := range torrents {
+			p.hashes[strings.ToLower(t.HashString)] = e.name
+			p.listed[strings.ToLower(t.HashString)] = &listedTorrent{e, t}
+		}
+		slog.Info("listed client torrents", "client", e.name, "torrents", len(torrents))
+	}
+	return nil
+}
+
+// has returns the name of a client that already has any of hashes.
+func (p *clientPool) has(hashes ...string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		if name, ok := p.hashes[strings.ToLower(h)]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// listing returns the torrent with any of hashes as a client listed it at
+// the start of the run, if one did.
+func (p *clientPool) listing(hashes ...string) (*listedTorrent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		if l, ok := p.listed[strings.ToLower(h)]; ok {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+func (p *clientPool) added(e *endpoint, hashes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		p.hashes[strings.ToLower(h)] = e.name
+	}
+}
+
+// claim reserves hashes for an add, so that no other add worker adds the
+// same torrent meanwhile. It returns the client that has or is getting the
+// torrent and false if one already is. The caller calls added or release.
+func (p *clientPool) claim(hashes ...string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		if name, ok := p.hashes[strings.ToLower(h)]; ok {
+			return name, false
+		}
+	}
+	for _, h := range hashes {
+		p.hashes[strings.ToLower(h)] = ""
+	}
+	return "", true
+}
+
+// release drops claimed hashes whose torrent wasn't added.
+func (p *clientPool) release(hashes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range hashes {
+		if p.hashes[strings.ToLower(h)] == "" {
+			delete(p.hashes, strings.ToLower(h))
+		}
+	}
+}
+
+// route picks the client for match: the one its rule names, if any, and
+// otherwise the first client or the next in turn, depending on
+// cfg.Routing.
+func (p *clientPool) route(match *matchedFile) *endpoint {
+	if match.client != "" {
+		return p.byName[match.client]
+	}
+	if cfg.Routing != "round-robin" {
+		return p.targets[0]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.targets[p.next%len(p.targets)]
+	p.next++
+	return e
+}
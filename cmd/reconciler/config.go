@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var configPath string
+
+// cfg holds settings that don't fit on the command line. It is empty unless
+// --config is given.
+var cfg = &config{}
+
+// config is the JSON configuration file.
+type config struct {
+	// Clients replace the client given by --server and friends.
+	Clients []*clientConfig `json:"clients,omitempty"`
+	// Routing picks the client for torrents no rule routes: "first" (the
+	// default) or "round-robin".
+	Routing string `json:"routing,omitempty"`
+	// Rules are evaluated in order against each matched torrent; the first
+	// rule whose conditions all hold applies.
+	Rules []*rule `json:"rules"`
+	// Feeds are polled on every run, in addition to the input files.
+	Feeds []*feed `json:"feeds,omitempty"`
+	// Catalog describes a --db whose schema isn't the default one.
+	Catalog *catalogConfig `json:"catalog,omitempty"`
+	// Hooks run on this host as torrents are matched, added, or fail.
+	Hooks *eventHooks `json:"hooks,omitempty"`
+	// TrackerNames names trackers by hostname, for {tracker} in rules; a
+	// name applies to the host and its subdomains.
+	TrackerNames map[string]string `json:"tracker_names,omitempty"`
+}
+
+// catalogConfig names the table and columns the catalog queries use.
+type catalogConfig struct {
+	// defaults: files, path and file
+	Table      string `json:"table,omitempty"`
+	PathColumn string `json:"path_column,omitempty"`
+	FileColumn string `json:"file_column,omitempty"`
+	// optional columns, used if the table has them: each file's size in
+	// bytes, and the hex root of its BEP 52 merkle tree. defaults: size
+	// and hash
+	SizeColumn string `json:"size_column,omitempty"`
+	HashColumn string `json:"hash_column,omitempty"`
+	// optional columns of each file's device and inode numbers, for
+	// --seed-root. defaults: device and inode
+	DeviceColumn string `json:"device_column,omitempty"`
+	InodeColumn  string `json:"inode_column,omitempty"`
+	// LookupQuery replaces the query finding the files a torrent's file
+	// could be. It takes one LIKE pattern, '%' followed by the torrent's
+	// path, and returns a single column: each file's full path.
+	LookupQuery string `json:"lookup_query,omitempty"`
+	// BuiltQuery replaces the query returning when the catalog was built,
+	// for --max-db-age: one row of one column, RFC 3339 or Unix seconds.
+	BuiltQuery string `json:"built_query,omitempty"`
+}
+
+// rule overrides how torrents from matching trackers are added.
+type rule struct {
+	// regex matched against each of the torrent's announce URLs
+	Announce string `json:"announce,omitempty"`
+	// bounds on the torrent's total size in bytes; zero means no bound
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// DownloadDir replaces the matched path. The client must see the data
+	// there, e.g. through a different mount of the same disk.
+	DownloadDir string `json:"download_dir,omitempty"`
+	// {tracker} in DownloadDir and Labels is replaced by the torrent's
+	// tracker: the name TrackerNames gives the host of one of its announce
+	// URLs, else the first URL's host, or "untracked"
+	Labels []string `json:"labels,omitempty"`
+	// name of the client to add to
+	Client string `json:"client,omitempty"`
+	seedPolicy
+
+	announce *regexp.Regexp
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// clients that may be added to
+	clients := make(map[string]bool)
+	seen := make(map[string]bool)
+	targets := 0
+	for i, cc := range c.Clients {
+		if cc.Name == "" || cc.Server == "" {
+			return nil, fmt.Errorf("%s: client %d: name and server are required", path, i+1)
+		}
+		if seen[cc.Name] {
+			return nil, fmt.Errorf("%s: duplicate client %q", path, cc.Name)
+		}
+		seen[cc.Name] = true
+		clients[cc.Name] = !cc.DedupeOnly
+		if !cc.DedupeOnly {
+			targets++
+		}
+	}
+	if len(c.Clients) > 0 && targets == 0 {
+		return nil, fmt.Errorf("%s: every client is dedupe_only", path)
+	}
+	switch c.Routing {
+	case "", "first", "round-robin":
+	default:
+		return nil, fmt.Errorf("%s: invalid routing %q", path, c.Routing)
+	}
+	for i, r := range c.Rules {
+		if r.Announce == "" && r.MinSize == 0 && r.MaxSize == 0 {
+			return nil, fmt.Errorf("%s: rule %d: no conditions", path, i+1)
+		}
+		if r.Announce != "" {
+			r.announce, err = regexp.Compile(r.Announce)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %d: %v", path, i+1, err)
+			}
+		}
+		if r.Client != "" && !seen[r.Client] {
+			return nil, fmt.Errorf("%s: rule %d: unknown client %q", path, i+1, r.Client)
+		}
+		if r.Client != "" && !clients[r.Client] {
+			return nil, fmt.Errorf("%s: rule %d: client %q is dedupe_only", path, i+1, r.Client)
+		}
+	}
+	for host, name := range c.TrackerNames {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("%s: tracker %q: name %q can't be used in a path", path, host, name)
+		}
+	}
+	feeds := make(map[string]bool)
+	for i, f := range c.Feeds {
+		if f.Name == "" || f.URL == "" {
+			return nil, fmt.Errorf("%s: feed %d: name and url are required", path, i+1)
+		}
+		if feeds[f.Name] {
+			return nil, fmt.Errorf("%s: duplicate feed %q", path, f.Name)
+		}
+		feeds[f.Name] = true
+		if err := f.compile(); err != nil {
+			return nil, fmt.Errorf("%s: feed %q: %v", path, f.Name, err)
+		}
+	}
+	return c, nil
+}
+
+func (r *rule) matches(announce []string, size int64) bool {
+	if r.MinSize > 0 && size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+	if r.announce == nil {
+		return true
+	}
+	for _, a := range announce {
+		if r.announce.MatchString(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleFor returns the first rule matching a torrent with the given trackers
+// and total size, or nil.
+func (c *config) ruleFor(announce []string, size int64) *rule {
+	for _, r := range c.Rules {
+		if r.matches(announce, size) {
+			return r
+		}
+	}
+	return nil
+}
+
+// trackerName returns the name of a torrent's tracker for {tracker}.
+func (c *config) trackerName(announce []string) string {
+	first := ""
+	for _, a := range announce {
+		u, err := url.Parse(a)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if first == "" {
+			first = host
+		}
+		// the most specific name
+		name, longest := "", 0
+		for h, n := range c.TrackerNames {
+			h = strings.ToLower(h)
+			if (host == h || strings.HasSuffix(host, "."+h)) && len(h) > longest {
+				name, longest = n, len(h)
+			}
+		}
+		if name != "" {
+			return name
+		}
+	}
+	if first == "" {
+		return "untracked"
+	}
+	return first
+}
+
+// expand replaces {tracker} in s for a torrent with the given trackers.
+func (c *config) expand(s string, announce []string) string {
+	if !strings.Contains(s, "{tracker}") {
+		return s
+	}
+	return strings.ReplaceAll(s, "{tracker}", c.trackerName(announce))
+}
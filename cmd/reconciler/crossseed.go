@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// Different torrents of the same content, from different trackers, match
+// the same data on disk. Once one of them is added or found present, each
+// other one matching the data is a cross-seed of it: it's listed with it
+// as a cross-seed set in the report and, unless --cross-seed, isn't added.
+// With --cross-seed, it's added at the same data.
+var crossSeed bool
+
+// crossSeedSet is the torrents matching the same data, the first being the
+// one added or found present.
+type crossSeedSet struct {
+	Data     string   `json:"data"`
+	Torrents []string `json:"torrents"`
+	hashes   []string
+}
+
+// holdsData records that match's data is seeded by its torrent, unless it's
+// seeded by another already. The caller holds r.mu.
+func (r *report) holdsData(match *matchedFile) {
+	key := filepath.Clean(match.dataFile)
+	if r.crossData == nil {
+		r.crossData = make(map[string]*crossSeedSet)
+	}
+	if r.crossData[key] == nil {
+		r.crossData[key] = &crossSeedSet{Data: key, Torrents: []string{match.tor}, hashes: []string{match.infoHash}}
+	}
+}
+
+// crossSeedOf returns the torrent seeding match's data, if it's another
+// one, adding match to its set.
+func (r *report) crossSeedOf(match *matchedFile) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.crossData[filepath.Clean(match.dataFile)]
+	if s == nil || slices.Contains(s.hashes, match.infoHash) {
+		return "", false
+	}
+	s.Torrents = append(s.Torrents, match.tor)
+	s.hashes = append(s.hashes, match.infoHash)
+	if len(s.Torrents) == 2 {
+		r.CrossSeeds = append(r.CrossSeeds, s)
+	}
+	return s.Torrents[0], true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var daemonInterval time.Duration
+
+// address for the daemon's HTTP listener
+var listenAddr string
+
+// run under the Windows service manager; see service_windows.go
+var windowsService bool
+
+// daemon reconciles the input files every daemonInterval until SIGINT or
+// SIGTERM, serving metrics for all passes at /metrics, and with --dashboard
+// the API and dashboard. It takes the same flags and arguments as a normal
+// run, except --review. On SIGHUP, and before a pass if the --config file
+// has changed, the config and filter files are read again; a pass under way
+// finishes with the old ones.
+func daemon(args []string) int {
+	if err := checkRunFlags(args); err != nil {
+		log.Fatal(err)
+	}
+	if review {
+		log.Fatalf("--review needs a terminal and can't be used with daemon")
+	}
+	if err := checkSchedule(); err != nil {
+		log.Fatal(err)
+	}
+	if dashboard {
+		if err := checkAPI(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	stats = newRunMetrics()
+	notify = newNotifier()
+	if notify != nil {
+		notify.perAdd = true
+	}
+	mail = newMailer()
+	if mail != nil {
+		mail.daemon = true
+	}
+	var err error
+	if events, err = openEvents(); err != nil {
+		log.Fatal(err)
+	}
+	defer events.Close()
+	if windowsService {
+		return runService(func(ctx context.Context) int { return serve(ctx, args) })
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return serve(ctx, args)
+}
+
+// serve runs the daemon's passes until ctx is done.
+func serve(ctx context.Context, args []string) int {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	configMod := modTime(configPath)
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return lockFailed(err)
+	}
+	defer release()
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", stats)
+	var api *apiServer
+	if dashboard {
+		db, err := sql.Open("sqlite3", catalogDSN())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		// uploads to the API are kept across passes
+		cleanup, err := openFetchDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cleanup()
+		api = newAPIServer(db)
+		api.routes(mux)
+		approvals = api
+	}
+	srv := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go srv.Serve(ln)
+	slog.Info("serving metrics", "addr", ln.Addr().String(), "dashboard", dashboard)
+	stopGRPC := func() {}
+	if dashboard {
+		if stopGRPC, err = api.serveGRPC(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sdNotify("READY=1")
+	go watchdog(ctx)
+
+	for {
+		if mod := modTime(configPath); !mod.Equal(configMod) {
+			configMod = mod
+			reload(api, "config file changed")
+		}
+		started := time.Now()
+		pass, cancel := withDeadline(ctx)
+		markProgress()
+		inPass.Store(true)
+		done := api.passing()
+		rep, err := reconcilePass(pass, args)
+		done(rep)
+		inPass.Store(false)
+		cancel()
+		if err != nil {
+			slog.Error("pass failed", "err", err)
+			notify.send("reconciler: pass failed", err.Error(), true)
+			mail.send("reconciler: pass failed", err.Error())
+			sdNotify("STATUS=last pass failed: " + err.Error())
+		} else {
+			finishReport(rep)
+			notify.finished(rep)
+			mail.finished(rep)
+			sdNotify("STATUS=last pass: " + strings.ReplaceAll(rep.summary(), "\n", "; "))
+		}
+		stats.record(rep, time.Now())
+		due := nextPass(started, time.Now())
+		slog.Debug("next pass", "at", due)
+		next := time.After(time.Until(due))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				sdNotify("STOPPING=1")
+				shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				srv.Shutdown(shutdown)
+				stopGRPC()
+				api.drain()
+				return exitOK
+			case <-hup:
+				configMod = modTime(configPath)
+				reload(api, "SIGHUP")
+			case <-next:
+				break wait
+			}
+		}
+	}
+}
+
+// reload reads the config and filter files again, keeping the old ones if
+// either has become invalid. Clients are connected to afresh by each pass,
+// so ones added to or changed in the config are used from the next. The
+// API's matches and adds read them too, so reloading waits for those.
+func reload(api *apiServer, why string) {
+	done := api.passing()
+	defer done(nil)
+	rules, err := reloadFilters()
+	if err != nil {
+		slog.Error("reloading filters; keeping the old ones", "err", err)
+		return
+	}
+	c := cfg
+	if configPath != "" {
+		if c, err = loadConfig(configPath); err != nil {
+			slog.Error("reloading config; keeping the old one", "err", err)
+			return
+		}
+	}
+	filters, cfg = rules, c
+	slog.Info("reloaded config", "why", why, "filters", len(filters), "rules", len(cfg.Rules), "clients", len(cfg.Clients))
+}
+
+// modTime returns when the file at path was last modified, or the zero time
+// if there's no such file.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/api"
+)
+
+// With --dashboard, daemon also serves the API of `reconciler api` on
+// --listen, and at / a page for managing it from a browser: the last pass,
+// the runs of the API, the recent matches of both, the unmatched torrents
+// of the last pass, counts by tracker since the daemon started, and the
+// fuzzy matches held back for lack of --accept-fuzzy, each with buttons to
+// approve its add. `reconciler api` serves the same page.
+var dashboard bool
+
+// matches kept for the dashboard
+const maxRecent = 200
+
+// recentMatch is a match of a pass or an API add, and when it was made.
+type recentMatch struct {
+	*matchOutcome
+	When time.Time
+}
+
+// hold keeps match, held back for approval, for the current match or pass.
+func (s *apiServer) hold(match *matchedFile) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holding = append(s.holding, match)
+}
+
+// passing holds s's pipeline for a daemon pass, or a reload. The returned
+// function releases it, keeping the pass's report, nil if it failed to
+// start or there was none, for the dashboard.
+func (s *apiServer) passing() func(rep *report) {
+	if s == nil {
+		return func(*report) {}
+	}
+	s.pass.Lock()
+	return func(rep *report) {
+		defer s.pass.Unlock()
+		if rep == nil {
+			return
+		}
+		s.kept(rep)
+		s.mu.Lock()
+		held := s.holding
+		s.holding = nil
+		s.last = rep
+		s.mu.Unlock()
+		for _, match := range held {
+			s.holdRun(match)
+		}
+	}
+}
+
+// holdRun makes a run of match for approval, announcing it, or updates the
+// one its torrent has unless it's being or been added, or was rejected.
+func (s *apiServer) holdRun(match *matchedFile) {
+	s.mu.Lock()
+	run := s.byTorrent[match.tor]
+	s.mu.Unlock()
+	fresh := run == nil
+	if fresh {
+		run = &apiRun{Run: api.Run{Torrent: match.tor, File: match.file, Created: time.Now()}}
+		s.keep(run)
+		s.mu.Lock()
+		s.byTorrent[match.tor] = run
+		s.mu.Unlock()
+	} else {
+		s.mu.Lock()
+		status := run.Status
+		s.mu.Unlock()
+		if status != runMatched && status != outcomeFailed {
+			return
+		}
+	}
+	s.matched(run, []*matchedFile{match}, newReport())
+	if fresh {
+		s.announce(run)
+	}
+}
+
+// kept adds what rep matched, and its counts by tracker, to the
+// dashboard's.
+func (s *apiServer) kept(rep *report) {
+	rep.mu.Lock()
+	var recent []*recentMatch
+	for _, m := range rep.Matches {
+		recent = append(recent, &recentMatch{m, rep.Start})
+	}
+	trackers := make(map[string]trackerCounts)
+	for host, c := range rep.Trackers {
+		trackers[host] = *c
+	}
+	rep.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append(s.recent, recent...)
+	if n := len(s.recent) - maxRecent; n > 0 {
+		s.recent = slices.Delete(s.recent, 0, n)
+	}
+	for host, c := range trackers {
+		t := s.trackers[host]
+		if t == nil {
+			t = &trackerCounts{}
+			s.trackers[host] = t
+		}
+		t.Scanned += c.Scanned
+		t.Matched += c.Matched
+		t.Added += c.Added
+		t.Unmatched += c.Unmatched
+	}
+}
+
+// drain waits for the adds under way.
+func (s *apiServer) drain() {
+	if s == nil {
+		return
+	}
+	s.adds.Wait()
+}
+
+// dashboard is GET /.
+func (s *apiServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	var summary string
+	var unmatched []string
+	if last != nil {
+		summary = last.summary()
+		last.mu.Lock()
+		unmatched = last.UnmatchedTorrents[:min(len(last.UnmatchedTorrents), maxMailedLines)]
+		last.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	var awaiting, runs []*apiRun
+	for _, id := range slices.Backward(s.order) {
+		run := s.runs[id]
+		if run.Status == runMatched {
+			awaiting = append(awaiting, run)
+		} else if len(runs) < maxMailedLines {
+			runs = append(runs, run)
+		}
+	}
+	recent := slices.Clone(s.recent)
+	slices.Reverse(recent)
+	var b bytes.Buffer
+	err := dashboardPage.Execute(&b, map[string]any{
+		"Start":     s.start,
+		"Last":      last,
+		"Summary":   summary,
+		"Awaiting":  awaiting,
+		"Runs":      runs,
+		"Recent":    recent,
+		"Unmatched": unmatched,
+		"Trackers":  s.trackers,
+	})
+	s.mu.Unlock()
+	if err != nil {
+		slog.Error("rendering dashboard", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// approve is POST /approve, from the dashboard's buttons: it starts adding
+// the run with the form's id at its dir.
+func (s *apiServer) approve(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, code, err := s.startAdd(r.PostFormValue("id"), r.PostFormValue("dir")); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>reconciler</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.15em; margin-top: 2em; }
+table { border-collapse: collapse; margin-top: .5em; }
+th, td { border: 1px solid #ccc; padding: .25em .6em; text-align: left; vertical-align: top; }
+th { background: #f2f2f2; }
+td.n { text-align: right; }
+td.path { font-family: monospace; word-break: break-all; }
+form { margin: 0; }
+pre { background: #f7f7f7; padding: .5em; }
+.failed, .error { color: #b00; }
+.added { color: #070; }
+.adding { color: #a60; }
+</style>
+</head>
+<body>
+<h1>reconciler</h1>
+<p>up since {{.Start.Format "2006-01-02 15:04:05 MST"}}</p>
+
+{{- with .Last}}
+<h2>Last pass, {{.Start.Format "2006-01-02 15:04:05"}}</h2>
+<pre>{{$.Summary}}</pre>
+{{- end}}
+
+<h2>Awaiting approval ({{len .Awaiting}})</h2>
+{{- if .Awaiting}}
+<table>
+<thead><tr><th>torrent</th><th>file</th><th>dir</th><th>confidence</th><th>client</th><th></th></tr></thead>
+<tbody>
+{{- range $run := .Awaiting}}
+{{- range .Candidates}}
+<tr><td class="path">{{$run.Torrent}}</td><td class="path">{{$run.File}}</td><td class="path">{{.Dir}}</td><td>{{.Confidence}}</td><td>{{.Client}}</td>
+<td><form method="post" action="/approve"><input type="hidden" name="id" value="{{$run.ID}}"><input type="hidden" name="dir" value="{{.Dir}}"><button>approve</button></form></td></tr>
+{{- end}}
+{{- end}}
+</tbody>
+</table>
+{{- else}}
+<p>nothing</p>
+{{- end}}
+
+{{- if .Runs}}
+<h2>Requests ({{len .Runs}})</h2>
+<table>
+<thead><tr><th>torrent</th><th>status</th><th>dir</th><th>updated</th><th>error</th></tr></thead>
+<tbody>
+{{- range .Runs}}
+<tr><td class="path">{{.Torrent}}</td><td class="{{.Status}}">{{.Status}}</td><td class="path">{{.Dir}}</td><td>{{.Updated.Format "2006-01-02 15:04:05"}}</td><td class="error">{{range .Failures}}{{.Error}} {{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Recent}}
+<h2>Recent matches ({{len .Recent}})</h2>
+<table>
+<thead><tr><th>when</th><th>torrent</th><th>download dir</th><th>outcome</th></tr></thead>
+<tbody>
+{{- range .Recent}}
+<tr><td>{{.When.Format "2006-01-02 15:04"}}</td><td class="path">{{.Torrent}}</td><td class="path">{{.DownloadDir}}</td><td class="{{.Outcome}}">{{.Outcome}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Unmatched}}
+<h2>Unmatched in the last pass ({{len .Unmatched}})</h2>
+<table>
+<tbody>
+{{- range .Unmatched}}
+<tr><td class="path">{{.}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Trackers}}
+<h2>Trackers</h2>
+<table>
+<thead><tr><th>tracker</th><th>scanned</th><th>matched</th><th>added</th><th>unmatched</th></tr></thead>
+<tbody>
+{{- range $host, $c := .Trackers}}
+<tr><td>{{$host}}</td><td class="n">{{$c.Scanned}}</td><td class="n">{{$c.Matched}}</td><td class="n">{{$c.Added}}</td><td class="n">{{$c.Unmatched}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+</body>
+</html>
+`))
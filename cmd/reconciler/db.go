@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Catalog DB open modes
+var dbWAL bool
+var dbReadOnly bool
+var dbImmutable bool
+
+// With --max-db-age, a --db catalog built longer ago than that is refused,
+// or with --stale-db=warn only warned about: matching against an index
+// lagging behind the disks adds torrents at data that's gone or moved. It
+// was built at the "built" value of its metadata table, if it has one, and
+// otherwise when the DB file was last modified.
+var maxDBAge time.Duration
+var staleDB string
+
+// builtQuery is the default catalog build time query.
+const builtQuery = "select value from metadata where key = 'built'"
+
+func checkDBFlags() error {
+	if dbWAL && (dbReadOnly || dbImmutable) {
+		return fmt.Errorf("--db-wal needs write access; it can't be used with --db-ro or --db-immutable")
+	}
+	if recordMatches && (dbReadOnly || dbImmutable) {
+		return fmt.Errorf("--record-matches needs write access; it can't be used with --db-ro or --db-immutable")
+	}
+	if maxDBAge < 0 {
+		return fmt.Errorf("--max-db-age can't be negative")
+	}
+	if staleDB != "refuse" && staleDB != "warn" {
+		return fmt.Errorf("--stale-db must be refuse or warn")
+	}
+	return nil
+}
+
+// sqliteDSN builds a go-sqlite3 data source name for path. Every DB waits up
+// to dbTimeout for locks held by other processes.
+func sqliteDSN(path string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("_busy_timeout", fmt.Sprint(dbTimeout.Milliseconds()))
+	u := &url.URL{Path: path}
+	return "file:" + u.EscapedPath() + "?" + params.Encode()
+}
+
+// catalogDSN is the DSN of the --db catalog, or of the in-memory one
+// listing the --root files.
+func catalogDSN() string {
+	if source != "db" {
+		return memCatalogDSN()
+	}
+	params := url.Values{}
+	if dbWAL {
+		params.Set("_journal_mode", "WAL")
+	}
+	if dbReadOnly {
+		params.Set("mode", "ro")
+	}
+	if dbImmutable {
+		// promises SQLite that nothing changes the file, so it takes no locks
+		params.Set("immutable", "1")
+	}
+	return sqliteDSN(dbFile, params)
+}
+
+// dbContext bounds a single DB operation by dbTimeout.
+func dbContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), dbTimeout)
+}
+
+// Whether the catalog table has the optional size and hash columns, as
+// found by checkCatalog.
+var catalogSizes, catalogHashes bool
+
+// catalogNames returns the catalog's table and its path, file, size and
+// hash columns: the --config catalog's for a --db, and otherwise the
+// in-memory catalog's.
+func catalogNames() (table, pathCol, fileCol, sizeCol, hashCol string) {
+	table, pathCol, fileCol, sizeCol, hashCol = "files", "path", "file", "size", "hash"
+	c := cfg.Catalog
+	if c == nil || source != "db" {
+		return
+	}
+	for _, o := range []struct{ name, value *string }{
+		{&table, &c.Table},
+		{&pathCol, &c.PathColumn},
+		{&fileCol, &c.FileColumn},
+		{&sizeCol, &c.SizeColumn},
+		{&hashCol, &c.HashColumn},
+	} {
+		if *o.value != "" {
+			*o.name = *o.value
+		}
+	}
+	return
+}
+
+// catalogSQL fills in the table and column names of a catalog query,
+// with null for optional columns the table hasn't got, and with
+// --windows-paths, the path column with slashes.
+func catalogSQL(q string) string {
+	table, pathCol, fileCol, sizeCol, hashCol := catalogNames()
+	size, hash := "null", "null"
+	if catalogSizes {
+		size = quoteIdent(sizeCol)
+	}
+	if catalogHashes {
+		hash = quoteIdent(hashCol)
+	}
+	dev, ino := "null", "null"
+	if catalogInodes {
+		devCol, inoCol := inodeColumns()
+		dev, ino = quoteIdent(devCol), quoteIdent(inoCol)
+	}
+	path := quoteIdent(pathCol)
+	if windowsPaths {
+		path = `replace(` + path + `, '\', '/')`
+	}
+	return strings.NewReplacer(
+		"{files}", quoteIdent(table),
+		"{path}", path,
+		"{file}", quoteIdent(fileCol),
+		"{size}", size,
+		"{hash}", hash,
+		"{device}", dev,
+		"{inode}", ino,
+	).Replace(q)
+}
+
+// quoteIdent quotes each part of a possibly schema-qualified SQL name, in
+// backquotes: SQLite takes a double-quoted name it can't find for a string.
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.ReplaceAll(p, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// lookupQuery is the query finding a torrent file's candidates.
+func lookupQuery() string {
+	if c := cfg.Catalog; c != nil && c.LookupQuery != "" && source == "db" {
+		return c.LookupQuery
+	}
+	return catalogSQL(LookupQuery)
+}
+
+// checkCatalog checks that the catalog queries fit db's schema, running
+// the lookup query with a pattern nothing matches, and looks for the
+// optional columns.
+func checkCatalog(db *sql.DB) error {
+	ctx, cancel := dbContext()
+	defer cancel()
+	rows, err := db.QueryContext(ctx, lookupQuery(), "\x00")
+	if err != nil {
+		return fmt.Errorf("catalog lookup query: %v", err)
+	}
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("catalog lookup query: %v", err)
+	}
+	if len(cols) != 1 {
+		return fmt.Errorf("catalog lookup query returns %d columns; it must return only each file's full path", len(cols))
+	}
+	for _, q := range []string{ExistsQuery, FuzzyQuery} {
+		stmt, err := db.PrepareContext(ctx, catalogSQL(q))
+		if err != nil {
+			return fmt.Errorf("catalog table or columns: %v", err)
+		}
+		stmt.Close()
+	}
+	table, _, _, sizeCol, hashCol := catalogNames()
+	has := func(col string) bool {
+		stmt, err := db.PrepareContext(ctx, "select "+quoteIdent(col)+" from "+quoteIdent(table)+" limit 0")
+		if err != nil {
+			return false
+		}
+		stmt.Close()
+		return true
+	}
+	catalogSizes, catalogHashes = has(sizeCol), has(hashCol)
+	devCol, inoCol := inodeColumns()
+	catalogInodes = has(devCol) && has(inoCol)
+	slog.Debug("catalog columns", "size", catalogSizes, "hash", catalogHashes, "inode", catalogInodes)
+	if source != "db" || maxDBAge == 0 {
+		return nil
+	}
+	built, from, err := catalogBuilt(ctx, db)
+	if err != nil {
+		return err
+	}
+	age := time.Since(built).Round(time.Second)
+	slog.Debug("catalog age", "built", built, "from", from, "age", age)
+	if age <= maxDBAge {
+		return nil
+	}
+	if staleDB == "warn" {
+		slog.Warn("catalog is older than --max-db-age", "built", built, "from", from, "age", age)
+		return nil
+	}
+	return fmt.Errorf("catalog was built %s ago, by its %s, over --max-db-age %s", age, from, maxDBAge)
+}
+
+// catalogBuilt returns when db was built, and what says so.
+func catalogBuilt(ctx context.Context, db *sql.DB) (time.Time, string, error) {
+	q := builtQuery
+	if c := cfg.Catalog; c != nil && c.BuiltQuery != "" {
+		q = c.BuiltQuery
+	}
+	var v string
+	err := db.QueryRowContext(ctx, q).Scan(&v)
+	switch {
+	case err == nil:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, "build time", nil
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0), "build time", nil
+		}
+		return time.Time{}, "", fmt.Errorf("catalog build time %q is neither RFC 3339 nor Unix seconds", v)
+	case q != builtQuery:
+		return time.Time{}, "", fmt.Errorf("catalog built_query: %v", err)
+	}
+	// without a metadata table; writes may still be in the WAL
+	var mod time.Time
+	for _, p := range []string{dbFile, dbFile + "-wal"} {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	if mod.IsZero() {
+		return time.Time{}, "", fmt.Errorf("catalog build time: can't stat %s", dbFile)
+	}
+	return mod, "modification time", nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exit status of diff when the runs differ, as with diff(1)
+const exitDiffers = 1
+
+// diffRuns compares two runs: two JSON reports, two runs recorded with
+// --record-matches given by their start times, or by default the last two
+// recorded runs. It prints the torrents only the second run matched (+),
+// those only the first did (-), and those whose data dir changed (~).
+func diffRuns(args []string) int {
+	var a, b map[string]*matchOutcome
+	var err error
+	switch {
+	case len(args) == 2 && strings.HasSuffix(args[0], ".json") && strings.HasSuffix(args[1], ".json"):
+		a, err = reportMatches(args[0])
+		if err == nil {
+			b, err = reportMatches(args[1])
+		}
+	case len(args) == 0 || len(args) == 2:
+		a, b, err = recordedMatches(args)
+	default:
+		log.Fatal("diff takes two JSON reports, two run times, or nothing for the last two runs")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var lines []string
+	for h, m := range b {
+		old, ok := a[h]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("+ %s\t%s", m.Torrent, m.DataDir))
+		case old.DataDir != m.DataDir:
+			lines = append(lines, fmt.Sprintf("~ %s\t%s -> %s", m.Torrent, old.DataDir, m.DataDir))
+		}
+	}
+	for h, m := range a {
+		if _, ok := b[h]; !ok {
+			lines = append(lines, fmt.Sprintf("- %s\t%s", m.Torrent, m.DataDir))
+		}
+	}
+	// by torrent, then change
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i][2:] != lines[j][2:] {
+			return lines[i][2:] < lines[j][2:]
+		}
+		return lines[i] < lines[j]
+	})
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	if len(lines) > 0 {
+		return exitDiffers
+	}
+	return exitOK
+}
+
+// reportMatches returns the matches in a JSON report by info hash.
+func reportMatches(path string) (map[string]*matchOutcome, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Matches []*matchOutcome `json:"matches"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	byHash := make(map[string]*matchOutcome)
+	for _, m := range r.Matches {
+		byHash[m.InfoHash] = m
+	}
+	return byHash, nil
+}
+
+// recordedMatches returns the matches of the runs recorded in the catalog
+// DB at the two start times in args, or of the last two runs.
+func recordedMatches(args []string) (map[string]*matchOutcome, map[string]*matchOutcome, error) {
+	if dbFile == "" {
+		return nil, nil, fmt.Errorf("must set --db")
+	}
+	db, err := sql.Open("sqlite3", catalogDSN())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	var runs []int64
+	for _, arg := range args {
+		run, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid run %q", arg)
+		}
+		runs = append(runs, run)
+	}
+	if len(runs) == 0 {
+		ctx, cancel := dbContext()
+		defer cancel()
+		rows, err := db.QueryContext(ctx, "select distinct run from reconciler_matches order by run desc limit 2")
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var run int64
+			if err := rows.Scan(&run); err != nil {
+				return nil, nil, err
+			}
+			runs = append([]int64{run}, runs...)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+		if len(runs) < 2 {
+			return nil, nil, fmt.Errorf("fewer than two runs recorded with --record-matches")
+		}
+	}
+	a, err := runMatches(db, runs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := runMatches(db, runs[1])
+	return a, b, err
+}
+
+func runMatches(db *sql.DB, run int64) (map[string]*matchOutcome, error) {
+	ctx, cancel := dbContext()
+	defer cancel()
+	rows, err := db.QueryContext(ctx, "select torrent, info_hash, download_dir, data_dir, outcome from reconciler_matches where run = ?", run)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byHash := make(map[string]*matchOutcome)
+	for rows.Next() {
+		m := &matchOutcome{}
+		if err := rows.Scan(&m.Torrent, &m.InfoHash, &m.DownloadDir, &m.DataDir, &m.Outcome); err != nil {
+			return nil, err
+		}
+		byHash[m.InfoHash] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(byHash) == 0 {
+		return nil, fmt.Errorf("no matches recorded for run %d", run)
+	}
+	return byHash, nil
+}
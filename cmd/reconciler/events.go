@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// With --events=ndjson, each pipeline event is written as it happens, one
+// JSON object per line, to --events-file or stdout: a torrent scanned,
+// matched, excluded (with the reason), left unmatched or added, and each
+// failure. The file is appended to.
+var eventsFormat string
+var eventsPath string
+
+func checkEvents() error {
+	switch eventsFormat {
+	case "", "ndjson":
+	default:
+		return fmt.Errorf("--events must be ndjson")
+	}
+	if eventsFormat != "" && eventsPath == "-" && emitScript != "" && scriptPath == "-" {
+		return fmt.Errorf("--events and --emit-script can't both write to stdout")
+	}
+	return nil
+}
+
+// event is one line of the stream. Event is one of scanned, matched,
+// excluded, unmatched, added or error.
+type event struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Torrent     string    `json:"torrent,omitempty"`
+	InfoHash    string    `json:"info_hash,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	DownloadDir string    `json:"download_dir,omitempty"`
+	Confidence  string    `json:"confidence,omitempty"`
+	Client      string    `json:"client,omitempty"`
+	// why a torrent was excluded: tracker, properties, filters, packed or
+	// bad, for a match rolled back
+	Reason string `json:"reason,omitempty"`
+	// a failure's category and error
+	Kind  string `json:"kind,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// eventStream writes events. A nil *eventStream, without --events, writes
+// nothing.
+type eventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	f   *os.File
+}
+
+var events *eventStream
+
+func openEvents() (*eventStream, error) {
+	if eventsFormat == "" {
+		return nil, nil
+	}
+	s := &eventStream{f: os.Stdout}
+	if eventsPath != "-" {
+		f, err := os.OpenFile(eventsPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.f = f
+	}
+	s.enc = json.NewEncoder(s.f)
+	// magnets are full of &
+	s.enc.SetEscapeHTML(false)
+	return s, nil
+}
+
+func (s *eventStream) Close() error {
+	if s == nil || s.f == os.Stdout {
+		return nil
+	}
+	return s.f.Close()
+}
+
+func (s *eventStream) emit(e *event) {
+	if s == nil {
+		return
+	}
+	e.Time = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(e); err != nil {
+		slog.Error("writing event", "err", err)
+	}
+}
+
+func (s *eventStream) scanned(tor, infoHash, name string) {
+	s.emit(&event{Event: "scanned", Torrent: tor, InfoHash: infoHash, Name: name})
+}
+
+func (s *eventStream) excluded(tor, reason string) {
+	s.emit(&event{Event: "excluded", Torrent: tor, Reason: reason})
+}
+
+func (s *eventStream) unmatched(tor string) {
+	s.emit(&event{Event: "unmatched", Torrent: tor})
+}
+
+func (s *eventStream) matched(match *matchedFile) {
+	s.emit(&event{Event: "matched", Torrent: match.tor, InfoHash: match.infoHash, DownloadDir: match.path, Confidence: match.confidence})
+}
+
+func (s *eventStream) added(match *matchedFile, name, client string) {
+	s.emit(&event{Event: "added", Torrent: match.tor, InfoHash: match.infoHash, Name: name, DownloadDir: match.path, Client: client})
+}
+
+func (s *eventStream) failed(e *pipelineError) {
+	s.emit(&event{Event: "error", Torrent: e.Torrent, Kind: e.Kind, Error: e.Error})
+}
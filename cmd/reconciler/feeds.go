@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// feed is an RSS or Atom feed of torrents in the config file. Each pass
+// fetches the feed and runs new entries through the pipeline like input
+// lines, matched by the torrent's largest file.
+type feed struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// regexes matched against entry titles; an entry is taken if it
+	// matches Include, when set, and doesn't match Exclude
+	Include string `json:"include,omitempty"`
+	Exclude string `json:"exclude,omitempty"`
+
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func (f *feed) compile() error {
+	var err error
+	if f.Include != "" {
+		if f.include, err = regexp.Compile(f.Include); err != nil {
+			return err
+		}
+	}
+	if f.Exclude != "" {
+		if f.exclude, err = regexp.Compile(f.Exclude); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *feed) wants(title string) bool {
+	if f.include != nil && !f.include.MatchString(title) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(title)
+}
+
+// feedItem is an entry of either kind of feed.
+type feedItem struct {
+	Title string `xml:"title"`
+	// RSS
+	GUID      string `xml:"guid"`
+	Enclosure struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+	// Atom
+	ID string `xml:"id"`
+	// RSS links are text, Atom links attributes
+	Links []struct {
+		Text string `xml:",chardata"`
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+// torrent returns the URL of the entry's .torrent or magnet link.
+func (it *feedItem) torrent() string {
+	if it.Enclosure.URL != "" {
+		return it.Enclosure.URL
+	}
+	for _, l := range it.Links {
+		if l.Rel == "enclosure" {
+			return l.Href
+		}
+	}
+	for _, l := range it.Links {
+		if link := strings.TrimSpace(l.Text); link != "" {
+			return link
+		}
+		if l.Href != "" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// key identifies the entry across fetches.
+func (it *feedItem) key() string {
+	switch {
+	case it.GUID != "":
+		return it.GUID
+	case it.ID != "":
+		return it.ID
+	}
+	return it.torrent()
+}
+
+type feedDoc struct {
+	Items   []*feedItem `xml:"channel>item"`
+	Entries []*feedItem `xml:"entry"`
+}
+
+// feed entries already sent through the pipeline by this process, by feed
+// name and entry key
+var feedSeen = make(map[string]bool)
+
+func fetchFeed(ctx context.Context, f *feed) ([]*feedItem, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range fetchHeaders {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	var doc feedDoc
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxFetchBytes)).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return append(doc.Items, doc.Entries...), nil
+}
+
+// wholeTorFile makes the input line for a torrent given without a
+// contained file, be it a URL, a magnet link or a file: a magnet is matched
+// by its display name and a .torrent by its largest file.
+func wholeTorFile(ctx context.Context, tor string) (*torFile, error) {
+	if isMagnet(tor) {
+		ti, err := parseMagnet(tor)
+		if err != nil {
+			return nil, err
+		}
+		if ti.Name == "" {
+			return nil, &metainfo.ParseError{File: tor, Err: errors.New("magnet without a display name")}
+		}
+		return &torFile{tor: tor, file: ti.Name, byName: true}, nil
+	}
+	filename, err := localTorrent(ctx, tor)
+	if err != nil {
+		return nil, err
+	}
+	ti, err := loadTorrent(filename)
+	if err != nil {
+		return nil, err
+	}
+	var largest metainfo.File
+	for _, f := range ti.Files {
+		if !f.Pad && f.Length > largest.Length {
+			largest = f
+		}
+	}
+	if largest.Path == "" {
+		return nil, &metainfo.ParseError{File: tor, Err: errors.New("no files")}
+	}
+	return &torFile{tor: tor, file: largest.Path, size: largest.Length}, nil
+}
+
+// scanFeeds sends the new entries of every feed in the config to c.
+func scanFeeds(ctx context.Context, c chan *torFile, errc chan<- *pipelineError) {
+	for _, f := range cfg.Feeds {
+		items, err := fetchFeed(ctx, f)
+		if err != nil {
+			errc <- failure(errFeed, "", fmt.Errorf("feed %q: %v", f.Name, err))
+			continue
+		}
+		for _, it := range items {
+			if ctx.Err() != nil {
+				return
+			}
+			key := f.Name + "\x00" + it.key()
+			if feedSeen[key] || !f.wants(it.Title) {
+				continue
+			}
+			tor := it.torrent()
+			if tor == "" {
+				continue
+			}
+			tf, err := wholeTorFile(ctx, tor)
+			if err != nil {
+				// tried again on the next pass
+				errc <- matchFailure(tor, err)
+				continue
+			}
+			feedSeen[key] = true
+			scanQueue.sendTor(c, tf)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Torrents given as http(s) URLs are downloaded into fetchDir, or a
+// temporary directory for the run, named by a hash of the URL. Files
+// already in fetchDir aren't downloaded again.
+var fetchDir string
+
+// "Name: value" headers sent with each download, e.g. Cookie or
+// Authorization for private trackers
+var fetchHeaders stringList
+
+const fetchTimeout = time.Minute
+
+// largest .torrent we'll download
+const maxFetchBytes = 10 << 20
+
+var fetchClient = &http.Client{Timeout: fetchTimeout}
+
+// fetchError means a torrent URL couldn't be downloaded.
+type fetchError struct {
+	url string
+	err error
+	// HTTP status, if the server answered
+	status int
+}
+
+func (e *fetchError) Error() string { return e.url + ": " + e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func checkFetchHeaders() error {
+	for _, h := range fetchHeaders {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid --fetch-header %q", h)
+		}
+	}
+	return nil
+}
+
+// openFetchDir makes a temporary fetchDir if none was given. The returned
+// function removes it.
+func openFetchDir() (func(), error) {
+	if fetchDir != "" {
+		return func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "reconciler-fetch-")
+	if err != nil {
+		return nil, err
+	}
+	fetchDir = dir
+	return func() {
+		os.RemoveAll(dir)
+		fetchDir = ""
+	}, nil
+}
+
+// transientFetch reports whether a download might succeed if retried.
+func transientFetch(err error) bool {
+	var fe *fetchError
+	if !errors.As(err, &fe) {
+		return false
+	}
+	return fe.status == 0 || fe.status >= 500
+}
+
+// localTorrent returns the file to read for tor, downloading it first if
+// it's a URL.
+func localTorrent(ctx context.Context, tor string) (string, error) {
+	if !isURL(tor) {
+		return tor, nil
+	}
+	sum := sha1.Sum([]byte(tor))
+	path := filepath.Join(fetchDir, hex.EncodeToString(sum[:])+".torrent")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	err := withRetry(ctx, "fetch", transientFetch, func() error {
+		return fetch(ctx, tor, path)
+	})
+	return path, err
+}
+
+// fetch downloads the torrent at url to path.
+func fetch(ctx context.Context, url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return &fetchError{url: url, err: err}
+	}
+	for _, h := range fetchHeaders {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return &fetchError{url: url, err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &fetchError{url, errors.New(resp.Status), resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes+1))
+	if err != nil {
+		return &fetchError{url: url, err: err}
+	}
+	if len(data) > maxFetchBytes {
+		return &fetchError{url: url, err: errors.New("too large for a .torrent")}
+	}
+	// a tracker that wants a login usually answers with an HTML page
+	if len(data) == 0 || data[0] != 'd' {
+		return &fetchError{url, fmt.Errorf("not a .torrent (%s)", resp.Header.Get("Content-Type")), resp.StatusCode}
+	}
+	if err := writeFetched(path, data); err != nil {
+		return &fetchError{url: url, err: err}
+	}
+	return nil
+}
+
+// writeFetched writes data to path atomically, so an interrupted run never
+// leaves a truncated file to be reused.
+func writeFetched(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fetch-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
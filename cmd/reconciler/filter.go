@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matched DB paths are filtered by --include and --exclude regexes and the
+// glob rules of --filter-file, in command-line order. The last rule that
+// matches a path decides; a path no rule matches is kept unless the first
+// rule is an include, which makes the rules a whitelist.
+var filters []*filterRule
+
+// filterArgs are the --include, --exclude and --filter-file flags in
+// order, for reloadFilters to read the filter files again.
+var filterArgs []filterArg
+
+type filterArg struct {
+	include bool
+	// a regex, or with file, a filter file's path
+	value string
+	file  bool
+}
+
+// Paths in snapshots and recycle bins are always excluded, unless
+// --match-snapshots: a client pointed at a read-only snapshot can't seed
+// from it for long, and one pointed at a recycle bin loses its data.
+var matchSnapshots bool
+
+// snapshotPath matches the snapshot dirs of btrfs (snapper and timeshift),
+// ZFS, NetApp and Synology, and the recycle bins and trash dirs of QNAP,
+// Synology, Samba, Windows and desktops.
+var snapshotPath = regexp.MustCompile(`(?i)/(\.snapshots?|\.zfs/snapshot|@snapshots?|timeshift(-btrfs)?/snapshots|@recycle|#recycle|\.recycle|\$recycle\.bin|\.trash(es|-\d+)?|\.local/share/trash)/`)
+
+type filterRule struct {
+	include bool
+	re      *regexp.Regexp
+	// as given, for messages
+	source string
+}
+
+// filterFlag appends a rule for each occurrence of --include or --exclude.
+type filterFlag struct {
+	include bool
+}
+
+func (f filterFlag) String() string {
+	var l []string
+	for _, r := range filters {
+		if r.include == f.include {
+			l = append(l, r.source)
+		}
+	}
+	return strings.Join(l, ", ")
+}
+
+func (f filterFlag) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	filters = append(filters, &filterRule{f.include, re, s})
+	filterArgs = append(filterArgs, filterArg{include: f.include, value: s})
+	return nil
+}
+
+// filterFile appends the rules in a file for each --filter-file. Lines are
+// gitignore-style globs: blank lines and lines starting with # are
+// ignored, ! makes a rule an include, a leading / anchors a glob at the
+// start of the path, and * and ? don't match /, while ** does.
+type filterFile struct {
+	paths []string
+}
+
+func (f *filterFile) String() string { return strings.Join(f.paths, ", ") }
+
+func (f *filterFile) Set(path string) error {
+	rules, err := readFilterFile(path)
+	if err != nil {
+		return err
+	}
+	filters = append(filters, rules...)
+	filterArgs = append(filterArgs, filterArg{value: path, file: true})
+	f.paths = append(f.paths, path)
+	return nil
+}
+
+func readFilterFile(path string) ([]*filterRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []*filterRule
+	s := bufio.NewScanner(file)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		include := false
+		if glob, ok := strings.CutPrefix(line, "!"); ok {
+			include, line = true, glob
+		}
+		re, err := regexp.Compile(globRegexp(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		rules = append(rules, &filterRule{include, re, fmt.Sprintf("%s:%d", path, n)})
+	}
+	return rules, s.Err()
+}
+
+// reloadFilters returns the filters with the filter files read again.
+func reloadFilters() ([]*filterRule, error) {
+	var rules []*filterRule
+	for _, a := range filterArgs {
+		if !a.file {
+			rules = append(rules, &filterRule{a.include, regexp.MustCompile(a.value), a.value})
+			continue
+		}
+		r, err := readFilterFile(a.value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r...)
+	}
+	return rules, nil
+}
+
+// globRegexp translates a filter file glob. An unanchored glob matches at
+// any path component, and any glob also matches everything below a
+// directory it matches.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	if anchored, ok := strings.CutPrefix(glob, "/"); ok {
+		b.WriteString("^/")
+		glob = anchored
+	} else {
+		b.WriteString("(^|/)")
+	}
+	glob = strings.TrimSuffix(glob, "/")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(/|$)")
+	return b.String()
+}
+
+// excluded reports whether the filters reject path, or it's outside the
+// roots matches are limited to.
+func excluded(path string) bool {
+	if rootsLimitMatches() && !under(path, roots) {
+		return true
+	}
+	if !matchSnapshots && snapshotPath.MatchString(path) {
+		return true
+	}
+	if len(filters) == 0 {
+		return false
+	}
+	keep := !filters[0].include
+	for _, r := range filters {
+		if r.re.MatchString(path) {
+			keep = r.include
+		}
+	}
+	return !keep
+}
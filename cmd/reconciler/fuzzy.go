@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// With --fuzzy, a contained file that has no exact match is compared
+// against DB files of the same extension by a loose form of the name, as
+// left by renaming tools: separators normalized, bracketed tags stripped,
+// and a few edits tolerated. Fuzzy matches are low confidence and are only
+// added with --accept-fuzzy or approval in --review.
+var fuzzy bool
+var fuzzyDistance int
+var acceptFuzzy bool
+
+// FuzzyQuery finds candidate files by one word of the name and the
+// extension; the rest of the comparison is in fuzzyLookup.
+const FuzzyQuery = "select {path}, {file} from {files} where {file} like ? and {file} like ? limit 1000"
+
+var bracketed = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)|\{[^}]*\}`)
+
+// fuzzyKey returns the loose form of a file's base name without extension.
+func fuzzyKey(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = bracketed.ReplaceAllString(name, " ")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// fuzzyResult is the closest DB file to a contained file.
+type fuzzyResult struct {
+	// the DB file's directory and name
+	dir, file string
+	distance  int
+}
+
+// fuzzyLookup returns the DB file closest to file within fuzzyDistance, or
+// nil.
+func fuzzyLookup(stmt *sql.Stmt, file string) (*fuzzyResult, error) {
+	base := path.Base(file)
+	key := fuzzyKey(base)
+	// the longest word is the most selective
+	var word string
+	for _, w := range strings.Fields(key) {
+		if len(w) > len(word) {
+			word = w
+		}
+	}
+	if len(word) < 3 {
+		return nil, nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	rows, err := stmt.QueryContext(ctx, "%"+names().LikePattern(word)+"%", "%"+path.Ext(base))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var best *fuzzyResult
+	for rows.Next() {
+		var dir, name string
+		if err := rows.Scan(&dir, &name); err != nil {
+			return nil, err
+		}
+		d := editDistance(key, fuzzyKey(name))
+		if d <= fuzzyDistance && (best == nil || d < best.distance) {
+			best = &fuzzyResult{dir, name, d}
+		}
+	}
+	return best, rows.Err()
+}
+
+// rename is a torrent-rename-path: the item at path, relative to the
+// download dir, is renamed to name.
+type rename struct {
+	path string
+	name string
+}
+
+// renamed returns p as it will be named after renames.
+func renamed(p string, renames []rename) string {
+	for _, r := range renames {
+		if p == r.path || strings.HasPrefix(p, r.path+"/") {
+			parent := path.Dir(r.path)
+			if parent == "." {
+				parent = ""
+			} else {
+				parent += "/"
+			}
+			p = parent + r.name + p[len(r.path):]
+		}
+	}
+	return p
+}
+
+// fuzzyPlace returns the download dir at which file, a contained file's
+// path in the torrent, lines up with the DB file r, and the renames that
+// make the torrent's names match the DB's, deepest first.
+func fuzzyPlace(file string, r *fuzzyResult) (string, []rename, bool) {
+	want := strings.Split(file, "/")
+	dirs := strings.Split(strings.TrimSuffix(r.dir, "/"), "/")
+	// the DB file's directories, as many as the torrent path has
+	levels := len(want) - 1
+	if levels >= len(dirs) {
+		return "", nil, false
+	}
+	have := append(dirs[len(dirs)-levels:len(dirs):len(dirs)], r.file)
+	var renames []rename
+	for i := len(want) - 1; i >= 0; i-- {
+		if want[i] != have[i] {
+			renames = append(renames, rename{strings.Join(want[:i+1], "/"), have[i]})
+		}
+	}
+	return strings.Join(dirs[:len(dirs)-levels], "/") + "/", renames, true
+}
+
+// renameAndStart applies renames to the paused torrent t so it finds the
+// DB's data, then has the client check it and, with start, start it. The
+// calls aren't cut
+// short when ctx is done, so that the torrent isn't left half renamed.
+func renameAndStart(ctx context.Context, cl *endpoint, t client.Torrent, renames []rename, start bool) error {
+	call := context.WithoutCancel(ctx)
+	for _, r := range renames {
+		err := withRetry(ctx, "rename", client.Transient, func() error {
+			return cl.rpc.RenamePath(call, t.ID, r.path, r.name)
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("renamed", "torrent", t.Name, "path", r.path, "name", r.name)
+	}
+	if err := cl.rpc.Verify(call, t.ID); err != nil || !start {
+		return err
+	}
+	return cl.rpc.Start(call, t.ID)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"Great.Movie.2020.mkv", "great movie 2020"},
+		{"Great_Movie-2020 [1080p] (x265) {tag}.mkv", "great movie 2020"},
+		{"[Group] Show - 01.mkv", "show 01"},
+		{"  Émile   Zola.avi", "émile zola"},
+		{"no extension", "no extension"},
+		{"a.b.c.txt", "a b c"},
+		{"[only tags].mkv", ""},
+	} {
+		if got := fuzzyKey(tt.name); got != tt.want {
+			t.Errorf("fuzzyKey(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"same", "same", 0},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"great movie", "great movei", 2},
+		// runes, not bytes
+		{"émile", "emile", 1},
+		{"日本語", "日本", 1},
+	} {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestRenamed(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		p       string
+		renames []rename
+		want    string
+	}{
+		{"none", "Show/a.mkv", nil, "Show/a.mkv"},
+		{"file", "Show/a.mkv", []rename{{"Show/a.mkv", "A.mkv"}}, "Show/A.mkv"},
+		{"top-level file", "a.mkv", []rename{{"a.mkv", "A.mkv"}}, "A.mkv"},
+		{"dir", "Show/S01/a.mkv", []rename{{"Show", "The Show"}}, "The Show/S01/a.mkv"},
+		{"inner dir", "Show/S01/a.mkv", []rename{{"Show/S01", "Season 1"}}, "Show/Season 1/a.mkv"},
+		// deepest first, as fuzzyPlace gives them
+		{"file then dirs", "Show/S01/a.mkv", []rename{{"Show/S01/a.mkv", "A.mkv"}, {"Show/S01", "Season 1"}, {"Show", "The Show"}}, "The Show/Season 1/A.mkv"},
+		{"sibling", "Show/b.mkv", []rename{{"Show/a.mkv", "A.mkv"}}, "Show/b.mkv"},
+		// a rename of Show isn't one of Showtime
+		{"name prefix", "Showtime/a.mkv", []rename{{"Show", "The Show"}}, "Showtime/a.mkv"},
+		{"dir itself", "Show", []rename{{"Show", "The Show"}}, "The Show"},
+	} {
+		if got := renamed(tt.p, tt.renames); got != tt.want {
+			t.Errorf("%s: renamed(%q) = %q, want %q", tt.name, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyPlace(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		file    string
+		r       fuzzyResult
+		dir     string
+		renames []rename
+		ok      bool
+	}{
+		{"single file", "Great.Movie.2020.mkv", fuzzyResult{dir: "/data/films", file: "Great Movie 2020.mkv"},
+			"/data/films/", []rename{{"Great.Movie.2020.mkv", "Great Movie 2020.mkv"}}, true},
+		{"file in dir", "Movie/Great.Movie.2020.mkv", fuzzyResult{dir: "/data/films/Movie/", file: "Great Movie 2020.mkv"},
+			"/data/films/", []rename{{"Movie/Great.Movie.2020.mkv", "Great Movie 2020.mkv"}}, true},
+		{"dir and file", "Movie/Great.Movie.2020.mkv", fuzzyResult{dir: "/data/films/Great Movie", file: "Great Movie 2020.mkv"},
+			"/data/films/", []rename{{"Movie/Great.Movie.2020.mkv", "Great Movie 2020.mkv"}, {"Movie", "Great Movie"}}, true},
+		{"dir only", "Show/S01/e01.mkv", fuzzyResult{dir: "/tv/Show/Season 1", file: "e01.mkv"},
+			"/tv/", []rename{{"Show/S01", "Season 1"}}, true},
+		{"same names", "Show/e01.mkv", fuzzyResult{dir: "/tv/Show", file: "e01.mkv"},
+			"/tv/", nil, true},
+		{"deeper than the DB path", "a/b/c.mkv", fuzzyResult{dir: "/x", file: "c.mkv"},
+			"", nil, false},
+	} {
+		dir, renames, ok := fuzzyPlace(tt.file, &tt.r)
+		if dir != tt.dir || !reflect.DeepEqual(renames, tt.renames) || ok != tt.ok {
+			t.Errorf("%s: fuzzyPlace = %q, %v, %v, want %q, %v, %v", tt.name, dir, renames, ok, tt.dir, tt.renames, tt.ok)
+			continue
+		}
+		// renamed, the file lines up with the DB's
+		if ok {
+			if got, want := path.Join(dir, renamed(tt.file, renames)), path.Join(tt.r.dir, tt.r.file); got != want {
+				t.Errorf("%s: renamed to %q, want %q", tt.name, got, want)
+			}
+		}
+	}
+}
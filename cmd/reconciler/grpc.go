@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/pyrovski/reconciler/pkg/apipb"
+)
+
+// With --grpc-listen, `reconciler api` and daemon --dashboard also serve
+// the API as the Reconciler service of pkg/apipb, for Go services wanting
+// typed calls. Its runs are the HTTP API's, so one matched over either can
+// be added over the other. With --api-token, calls must carry it as
+// "authorization: Bearer" metadata; without one, --grpc-listen must be a
+// loopback address.
+var grpcListen string
+
+type grpcServer struct {
+	apipb.UnimplementedReconcilerServer
+	s *apiServer
+}
+
+// serveGRPC serves the gRPC service on --grpc-listen, if it's set, until
+// the returned function is called.
+func (s *apiServer) serveGRPC() (func(), error) {
+	if grpcListen == "" {
+		return func() {}, nil
+	}
+	ln, err := net.Listen("tcp", grpcListen)
+	if err != nil {
+		return nil, err
+	}
+	// uploads come as MatchRequest data
+	srv := grpc.NewServer(grpc.StreamInterceptor(grpcAuthorize), grpc.MaxRecvMsgSize(maxUpload+1<<10))
+	apipb.RegisterReconcilerServer(srv, &grpcServer{s: s})
+	go srv.Serve(ln)
+	slog.Info("serving gRPC", "addr", ln.Addr().String())
+	return func() {
+		// a client may hold a stream open
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			srv.Stop()
+		}
+	}, nil
+}
+
+// grpcAuthorize lets through calls with --api-token, if it's set.
+func grpcAuthorize(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if apiToken == "" {
+		return handler(srv, ss)
+	}
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1 {
+			return handler(srv, ss)
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong token")
+}
+
+// grpcError returns err, which the HTTP API answers with code, as a gRPC
+// status.
+func grpcError(code int, err error) error {
+	c := codes.Internal
+	switch code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		c = codes.InvalidArgument
+	case http.StatusNotFound:
+		c = codes.NotFound
+	case http.StatusConflict:
+		c = codes.FailedPrecondition
+	}
+	return status.Error(c, err.Error())
+}
+
+// MatchTorrent matches each torrent sent, as POST /torrents does.
+func (g *grpcServer) MatchTorrent(stream grpc.BidiStreamingServer[apipb.MatchRequest, apipb.Run]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tor, err := requestedTorrent(req)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		run, code, err := g.s.matchTorrent(stream.Context(), tor, req.GetFile())
+		if err != nil {
+			return grpcError(code, err)
+		}
+		if err := stream.Send(g.s.runMessage(run)); err != nil {
+			return err
+		}
+	}
+}
+
+// requestedTorrent returns the torrent req names, saving one sent as data
+// as an upload.
+func requestedTorrent(req *apipb.MatchRequest) (string, error) {
+	switch t := req.Torrent.(type) {
+	case *apipb.MatchRequest_Data:
+		return saveUpload(t.Data)
+	case *apipb.MatchRequest_Source:
+		return namedTorrent(t.Source)
+	}
+	return "", errors.New("no torrent given")
+}
+
+// AddMatched starts adding each run sent, as POST /torrents/{id}/add does,
+// and sends each run again once its add is done.
+func (g *grpcServer) AddMatched(stream grpc.BidiStreamingServer[apipb.AddRequest, apipb.Run]) error {
+	var adds sync.WaitGroup
+	defer adds.Wait()
+	// gives up on the adds still under way when the stream fails
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var mu sync.Mutex
+	send := func(run *apiRun) error {
+		msg := g.s.runMessage(run)
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Send(msg)
+	}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			adds.Wait()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		run, code, err := g.s.startAdd(req.GetId(), req.GetDir())
+		if err != nil {
+			return grpcError(code, err)
+		}
+		g.s.mu.Lock()
+		added := run.added
+		g.s.mu.Unlock()
+		if err := send(run); err != nil {
+			return err
+		}
+		adds.Add(1)
+		go func() {
+			defer adds.Done()
+			select {
+			case <-added:
+				send(run)
+			case <-ctx.Done():
+			}
+		}()
+	}
+}
+
+// ListUnmatched sends the torrents GET /unmatched lists.
+func (g *grpcServer) ListUnmatched(_ *apipb.ListUnmatchedRequest, stream grpc.ServerStreamingServer[apipb.Unmatched]) error {
+	for _, u := range g.s.unmatchedTorrents() {
+		if err := stream.Send(&apipb.Unmatched{Torrent: u.Torrent, Run: u.Run}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMessage returns run as the gRPC service sends it.
+func (s *apiServer) runMessage(run *apiRun) *apipb.Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := &apipb.Run{
+		Id:       run.ID,
+		Torrent:  run.Torrent,
+		File:     run.File,
+		InfoHash: run.InfoHash,
+		Status:   run.Status,
+		Dir:      run.Dir,
+		Created:  timestamppb.New(run.Created),
+		Updated:  timestamppb.New(run.Updated),
+	}
+	for _, c := range run.Candidates {
+		msg.Candidates = append(msg.Candidates, &apipb.Candidate{
+			Dir:         c.Dir,
+			DownloadDir: c.DownloadDir,
+			Client:      c.Client,
+			Confidence:  c.Confidence,
+			Download:    c.Download,
+		})
+	}
+	for _, f := range run.Failures {
+		msg.Failures = append(msg.Failures, &apipb.Failure{Kind: f.Kind, Torrent: f.Torrent, Error: f.Error})
+	}
+	return msg
+}
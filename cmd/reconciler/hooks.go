@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hooks run before and after each add on the seeding host, over SSH when
+// hookHost is set and through the local shell otherwise.
+var preAddHooks stringList
+var postAddHooks stringList
+var hookHost string
+var hookTimeout time.Duration
+
+// hookResult records a single hook invocation for the report.
+type hookResult struct {
+	Stage    string        `json:"stage"`
+	Command  string        `json:"command"`
+	Torrent  string        `json:"torrent"`
+	Duration time.Duration `json:"duration"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// shellQuote quotes s for use as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// expandHook substitutes {torrent}, {hash}, and {dir} in a hook command with
+// shell-quoted values from the match.
+func expandHook(command string, match *matchedFile) string {
+	return strings.NewReplacer(
+		"{torrent}", shellQuote(match.tor),
+		"{hash}", shellQuote(match.infoHash),
+		"{dir}", shellQuote(match.path),
+	).Replace(command)
+}
+
+func hookCommand(ctx context.Context, command string) *exec.Cmd {
+	if hookHost == "" {
+		return exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// BatchMode keeps ssh from prompting for a password on a terminal we
+	// don't have.
+	return exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", hookHost, command)
+}
+
+// runHooks runs each command in order for match, stopping at the first
+// failure. All results are added to rep.
+func runHooks(stage string, commands []string, match *matchedFile, rep *report) error {
+	for _, command := range commands {
+		if err := execHook(stage, expandHook(command, match), match.tor, hookCommand, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execHook runs the hook command for torrent tor, as built by cmdFor, and
+// adds the result to rep.
+func execHook(stage, command, tor string, cmdFor func(context.Context, string) *exec.Cmd, rep *report) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := cmdFor(ctx, command)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", hookTimeout)
+	}
+	res := &hookResult{
+		Stage:    stage,
+		Command:  command,
+		Torrent:  tor,
+		Duration: time.Since(start),
+		Output:   strings.TrimSpace(out.String()),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	rep.hook(res)
+	if err != nil {
+		slog.Warn("hook failed", "stage", stage, "command", command, "torrent", tor, "err", err, "output", res.Output)
+	}
+	return err
+}
+
+// eventHooks are commands from the config run on this host as torrents
+// are matched, added, or fail, through the shell with what's known of the
+// torrent in TORRENT_PATH, INFO_HASH and DOWNLOAD_DIR, and in RESULT the
+// match's confidence, "added", or the kind of failure, whose message is
+// in ERROR. A nil *eventHooks runs nothing.
+type eventHooks struct {
+	OnMatch []string `json:"on_match,omitempty"`
+	OnAdd   []string `json:"on_add,omitempty"`
+	OnError []string `json:"on_error,omitempty"`
+}
+
+func (h *eventHooks) matched(match *matchedFile, rep *report) {
+	if h != nil {
+		runEventHooks("on_match", h.OnMatch, eventEnv(match.tor, match.infoHash, match.path, match.confidence), match.tor, rep)
+	}
+}
+
+func (h *eventHooks) added(match *matchedFile, rep *report) {
+	if h != nil {
+		runEventHooks("on_add", h.OnAdd, eventEnv(match.tor, match.infoHash, match.path, outcomeAdded), match.tor, rep)
+	}
+}
+
+func (h *eventHooks) failed(e *pipelineError, rep *report) {
+	if h != nil {
+		runEventHooks("on_error", h.OnError, append(eventEnv(e.Torrent, "", "", e.Kind), "ERROR="+e.Error), e.Torrent, rep)
+	}
+}
+
+func eventEnv(tor, hash, dir, result string) []string {
+	return append(os.Environ(), "TORRENT_PATH="+tor, "INFO_HASH="+hash, "DOWNLOAD_DIR="+dir, "RESULT="+result)
+}
+
+// runEventHooks runs each command in order with env, stopping at the
+// first failure.
+func runEventHooks(stage string, commands, env []string, tor string, rep *report) {
+	cmdFor := func(ctx context.Context, command string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = env
+		return cmd
+	}
+	for _, command := range commands {
+		if execHook(stage, command, tor, cmdFor, rep) != nil {
+			return
+		}
+	}
+}
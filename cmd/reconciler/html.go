@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"time"
+)
+
+// With --html-report, the run report is also written as a single HTML page,
+// with no outside resources, that can be mailed or served as is: the run's
+// counts, per-tracker counts, its failures, and tables of the matches, the
+// added torrents and the unmatched ones, sortable by clicking a column's
+// header.
+var htmlReportPath string
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"added": func(ms []*matchOutcome) []*matchOutcome {
+		var l []*matchOutcome
+		for _, m := range ms {
+			if m.Outcome == outcomeAdded {
+				l = append(l, m)
+			}
+		}
+		return l
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>reconciler run {{.Start.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.15em; margin-top: 2em; }
+table { border-collapse: collapse; margin-top: .5em; }
+th, td { border: 1px solid #ccc; padding: .25em .6em; text-align: left; vertical-align: top; }
+th { background: #f2f2f2; }
+table.sortable th { cursor: pointer; user-select: none; }
+table.sortable th::after { content: " \2195"; color: #aaa; }
+td.n { text-align: right; }
+td.path { font-family: monospace; word-break: break-all; }
+.failed, .error { color: #b00; }
+.added { color: #070; }
+.warn { color: #a60; }
+</style>
+</head>
+<body>
+<h1>reconciler run {{.Start.Format "2006-01-02 15:04:05 MST"}}</h1>
+<table>
+<tr><th>host</th><td>{{.Host}}</td></tr>
+<tr><th>took</th><td>{{.Took}}</td></tr>
+{{- if .Interrupted}}
+<tr><th>cut short</th><td class="warn">{{if .Deadline}}deadline reached{{else}}interrupted{{end}}; {{.Skipped}} matches skipped, {{.Unprocessed}} torrents unprocessed</td></tr>
+{{- end}}
+</table>
+
+<h2>Counts</h2>
+<table>
+<tr><th>scanned</th><td class="n">{{.Scanned}}</td></tr>
+<tr><th>input duplicates</th><td class="n">{{.InputDuplicates}}</td></tr>
+<tr><th>matched</th><td class="n">{{.Matched}}</td></tr>
+<tr><th>already present</th><td class="n">{{.Present}}</td></tr>
+{{- if .Misplaced}}
+<tr><th>misplaced</th><td class="n warn">{{len .Misplaced}}</td></tr>
+{{- end}}
+<tr><th>unmatched</th><td class="n">{{.Unmatched}}</td></tr>
+<tr><th>excluded</th><td class="n">{{.Excluded}}</td></tr>
+<tr><th>packed</th><td class="n">{{len .Packed}}</td></tr>
+{{- if .Split}}
+<tr><th>split across dirs</th><td class="n">{{len .Split}}</td></tr>
+{{- end}}
+{{- if .CrossSeeds}}
+<tr><th>cross-seed sets</th><td class="n">{{len .CrossSeeds}}</td></tr>
+<tr><th>cross-seeds not added</th><td class="n">{{.CrossSeeded}}</td></tr>
+{{- end}}
+<tr><th>added</th><td class="n added">{{.Added}}</td></tr>
+<tr><th>partial</th><td class="n">{{.Partial}}</td></tr>
+<tr><th>duplicates</th><td class="n">{{.Duplicates}}</td></tr>
+<tr><th>deferred</th><td class="n">{{.Deferred}}</td></tr>
+{{- if .Scripted}}
+<tr><th>scripted</th><td class="n">{{.Scripted}}</td></tr>
+{{- end}}
+{{- if .Resumed}}
+<tr><th>resumed</th><td class="n">{{.Resumed}}</td></tr>
+{{- end}}
+{{- if .Offline}}
+<tr><th>offline</th><td class="n">{{.Offline}}</td></tr>
+{{- end}}
+<tr><th>failed</th><td class="n{{if .Failures}} failed{{end}}">{{len .Failures}}</td></tr>
+</table>
+
+{{- if .Trackers}}
+<h2>Trackers</h2>
+<table class="sortable">
+<thead><tr><th>tracker</th><th>scanned</th><th>matched</th><th>added</th><th>unmatched</th></tr></thead>
+<tbody>
+{{- range $host, $c := .Trackers}}
+<tr><td>{{$host}}</td><td class="n">{{$c.Scanned}}</td><td class="n">{{$c.Matched}}</td><td class="n">{{$c.Added}}</td><td class="n">{{$c.Unmatched}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Errors}}
+<h2>Errors</h2>
+<table class="sortable">
+<thead><tr><th>kind</th><th>count</th></tr></thead>
+<tbody>
+{{- range $kind, $n := .Errors}}
+<tr><td>{{$kind}}</td><td class="n">{{$n}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<table class="sortable">
+<thead><tr><th>kind</th><th>torrent</th><th>error</th></tr></thead>
+<tbody>
+{{- range .Failures}}
+<tr><td>{{.Kind}}</td><td class="path">{{.Torrent}}</td><td class="error">{{.Error}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- with added .Matches}}
+<h2>Added ({{len .}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th><th>info hash</th><th>download dir</th></tr></thead>
+<tbody>
+{{- range .}}
+<tr><td class="path">{{.Torrent}}</td><td class="path">{{.InfoHash}}</td><td class="path">{{.DownloadDir}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Matches}}
+<h2>Matched ({{len .Matches}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th><th>info hash</th><th>download dir</th><th>data dir</th><th>private</th><th>outcome</th></tr></thead>
+<tbody>
+{{- range .Matches}}
+<tr><td class="path">{{.Torrent}}</td><td class="path">{{.InfoHash}}</td><td class="path">{{.DownloadDir}}</td><td class="path">{{.DataDir}}</td><td>{{if .Private}}yes{{end}}</td><td class="{{.Outcome}}">{{.Outcome}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Misplaced}}
+<h2>Present under another download dir ({{len .Misplaced}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th><th>client</th><th>client's dir</th><th>matched dir</th><th>fixed</th></tr></thead>
+<tbody>
+{{- range .Misplaced}}
+<tr><td class="path">{{.Torrent}}</td><td>{{.Client}}</td><td class="path">{{.ClientDir}}</td><td class="path">{{.MatchedDir}}</td><td>{{if .Fixed}}yes{{else}}no{{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .UnmatchedTorrents}}
+<h2>Unmatched ({{len .UnmatchedTorrents}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th></tr></thead>
+<tbody>
+{{- range .UnmatchedTorrents}}
+<tr><td class="path">{{.}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Split}}
+<h2>Split across dirs ({{len .Split}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th><th>dirs</th><th>linked</th></tr></thead>
+<tbody>
+{{- range .Split}}
+<tr><td class="path">{{.Torrent}}</td><td class="path">{{range $i, $d := .Dirs}}{{if $i}}<br>{{end}}{{$d}}{{end}}</td><td>{{if .Linked}}yes{{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .CrossSeeds}}
+<h2>Cross-seed sets ({{len .CrossSeeds}})</h2>
+<table class="sortable">
+<thead><tr><th>data</th><th>torrents</th></tr></thead>
+<tbody>
+{{- range .CrossSeeds}}
+<tr><td class="path">{{.Data}}</td><td class="path">{{range $i, $t := .Torrents}}{{if $i}}<br>{{end}}{{$t}}{{end}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if .Packed}}
+<h2>Packed in archives ({{len .Packed}})</h2>
+<table class="sortable">
+<thead><tr><th>torrent</th><th>name</th><th>archives</th><th>files</th></tr></thead>
+<tbody>
+{{- range .Packed}}
+<tr><td class="path">{{.Torrent}}</td><td>{{.Name}}</td><td class="n">{{.Archives}}</td><td class="n">{{.Files}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+	table.querySelectorAll("th").forEach(function (th, col) {
+		var asc = true;
+		th.addEventListener("click", function () {
+			var body = table.tBodies[0];
+			var rows = Array.prototype.slice.call(body.rows);
+			rows.sort(function (a, b) {
+				var x = a.cells[col].textContent, y = b.cells[col].textContent;
+				var c = (x !== "" && y !== "" && !isNaN(x) && !isNaN(y)) ? x - y : x.localeCompare(y);
+				return asc ? c : -c;
+			});
+			asc = !asc;
+			rows.forEach(function (r) { body.appendChild(r); });
+		});
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// writeHTML writes the report to path as a page.
+func (r *report) writeHTML(path string) error {
+	host, _ := os.Hostname()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b bytes.Buffer
+	err := htmlReport.Execute(&b, struct {
+		*report
+		Host string
+		Took time.Duration
+	}{r, host, time.Since(r.Start).Round(time.Second)})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
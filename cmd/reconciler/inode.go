@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// The catalog table may also have device and inode columns, which the
+// in-memory catalog of --source=fs fills in when --seed-root is given.
+// With --seed-root, candidate dirs whose matched files are hard links of
+// one file, by the catalog's device and inode or, for a catalog without
+// them, as stat'ed here, are taken for the same content: of each such set
+// only the one under a --seed-root is kept, rather than a link of it under
+// a media library, or the first if none is.
+var seedRoots stringList
+
+// InodeQuery returns the device and inode of a DB file, null for each if
+// the table hasn't got them.
+const InodeQuery = "select {device}, {inode} from {files} where {path} = ? and {file} = ? limit 1"
+
+// Whether the catalog table has device and inode columns, as found by
+// checkCatalog.
+var catalogInodes bool
+
+// fileKey identifies a file, whichever of its hard links it's found by.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// inodeColumns returns the catalog's device and inode columns.
+func inodeColumns() (dev, ino string) {
+	dev, ino = "device", "inode"
+	if c := cfg.Catalog; c != nil && source == "db" {
+		if c.DeviceColumn != "" {
+			dev = c.DeviceColumn
+		}
+		if c.InodeColumn != "" {
+			ino = c.InodeColumn
+		}
+	}
+	return
+}
+
+// unlinkedCandidates returns dirs, the candidates for tf, without those
+// whose matched file is a hard link of an earlier one's. Of two such, one
+// under a --seed-root is kept in the other's place.
+func unlinkedCandidates(stmt *sql.Stmt, ti *metainfo.Info, tf *torFile, dirs []string) ([]string, error) {
+	if len(seedRoots) == 0 || len(dirs) < 2 || ti == nil {
+		return dirs, nil
+	}
+	var kept []string
+	at := make(map[fileKey]int)
+	for _, dir := range dirs {
+		key, ok, err := fileKeyOf(stmt, names().ContainedPath(ti, dir, tf.file, tf.size))
+		if err != nil {
+			return nil, err
+		}
+		i, linked := at[key]
+		if !ok || !linked {
+			if ok {
+				at[key] = len(kept)
+			}
+			kept = append(kept, dir)
+			continue
+		}
+		if !under(slashed(kept[i]), seedRoots) && under(slashed(dir), seedRoots) {
+			kept[i], dir = dir, kept[i]
+		}
+		slog.Info("candidate is a hard link of another", "torrent", tf.tor, "dir", dir, "kept", kept[i])
+	}
+	return kept, nil
+}
+
+// fileKeyOf returns the device and inode of the DB file at fullpath, from
+// the catalog or by stat'ing it here, and false if neither can tell.
+func fileKeyOf(stmt *sql.Stmt, fullpath string) (fileKey, bool, error) {
+	slash := strings.LastIndex(fullpath, "/")
+	if slash < 0 {
+		return fileKey{}, false, nil
+	}
+	if stmt != nil {
+		var dev, ino sql.NullInt64
+		defer stats.observeQuery(time.Now())
+		qctx, cancel := dbContext()
+		defer cancel()
+		err := stmt.QueryRowContext(qctx, fullpath[:slash], fullpath[slash+1:]).Scan(&dev, &ino)
+		if err != nil && err != sql.ErrNoRows {
+			return fileKey{}, false, err
+		}
+		if dev.Valid && ino.Valid {
+			return fileKey{uint64(dev.Int64), uint64(ino.Int64)}, true, nil
+		}
+	}
+	if !locallyVisible(fullpath[:slash]) {
+		return fileKey{}, false, nil
+	}
+	fi, err := os.Stat(fullpath)
+	if err != nil {
+		return fileKey{}, false, nil
+	}
+	key, ok := statKey(fi)
+	return key, ok, nil
+}
@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// statKey returns false: os.FileInfo carries no file index on Windows.
+func statKey(fi os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// expandInputs expands glob patterns among args and replaces directories
+// with the input files under them, recursively and in lexical order: those
+// that isInputName accepts, skipping hidden files and directories. A
+// pattern matching nothing is an error.
+func expandInputs(args []string) ([]string, []error) {
+	var names []string
+	var errs []error
+	for _, arg := range args {
+		matches := []string{arg}
+		if arg != "-" && strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err == nil && len(matches) == 0 {
+				err = fmt.Errorf("%s: no files match", arg)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || !fi.IsDir() {
+				// "-", or left for openInput to report
+				names = append(names, m)
+				continue
+			}
+			var files []string
+			err = filepath.WalkDir(m, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if p != m && strings.HasPrefix(d.Name(), ".") {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.Type().IsRegular() && isInputName(p) {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+			sort.Strings(files)
+			names = append(names, files...)
+		}
+	}
+	return names, errs
+}
+
+// isInputName reports whether a file found in a directory argument is
+// input, going by its extension: .torrent or a list format, maybe
+// compressed.
+func isInputName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".gz" || ext == ".zst" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+	switch ext {
+	case ".torrent", ".tsv", ".txt", ".csv", ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// isTorrentFile reports whether the input file name is a .torrent, read
+// as a torrent to match by its largest file rather than as a list.
+func isTorrentFile(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".torrent")
+}
+
+// input is an input file, decompressed.
+type input struct {
+	io.Reader
+	closers []func() error
+}
+
+func (in *input) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if cerr := in.closers[i](); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openInput opens the input file name, or stdin for "-". Gzip and zstd
+// input, from a file or not, is recognized by its magic number and
+// decompressed.
+func openInput(name string) (*input, error) {
+	in := &input{}
+	f := os.Stdin
+	if name != "-" {
+		var err error
+		f, err = os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		in.closers = append(in.closers, f.Close)
+	}
+	br := bufio.NewReader(f)
+	in.Reader = br
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Reader = zr
+		in.closers = append(in.closers, zr.Close)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Reader = zr
+		in.closers = append(in.closers, func() error { zr.Close(); return nil })
+	}
+	return in, nil
+}
+
+// inputFormat is the format of the input files: tsv, csv, jsonl, or auto
+// to go by each file's extension, defaulting to tsv.
+var inputFormat string
+
+func checkInputFormat() error {
+	switch inputFormat {
+	case "auto", "tsv", "csv", "jsonl":
+		return nil
+	}
+	return fmt.Errorf("invalid --input-format %q", inputFormat)
+}
+
+// formatOf returns the format of the input file name.
+func formatOf(name string) string {
+	if inputFormat != "auto" {
+		return inputFormat
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".gz" || ext == ".zst" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+	switch ext {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	return "tsv"
+}
+
+// record is one torrent and contained file from the input. The file is
+// empty for a magnet to match by display name, and size is zero if not
+// given. Others are further contained files listed with it, and sizes
+// those of the others that were given.
+type record struct {
+	tor    string
+	file   string
+	size   int64
+	others []string
+	sizes  map[string]int64
+}
+
+// addOther lists another contained file, of size bytes if that's known.
+func (r *record) addOther(file string, size int64) {
+	r.others = append(r.others, file)
+	if size > 0 {
+		if r.sizes == nil {
+			r.sizes = make(map[string]int64)
+		}
+		r.sizes[file] = size
+	}
+}
+
+// badRecord is an input record that couldn't be parsed. Reading goes on
+// after it.
+type badRecord struct {
+	err error
+}
+
+func (e *badRecord) Error() string { return e.err.Error() }
+
+// recordReader reads records, returning io.EOF at the end of the input.
+type recordReader interface {
+	next() (*record, error)
+}
+
+func newRecordReader(r io.Reader, format string) recordReader {
+	switch format {
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		return &csvReader{r: cr}
+	case "jsonl":
+		return &jsonlReader{s: bufio.NewScanner(r)}
+	}
+	return &tsvReader{s: bufio.NewScanner(r)}
+}
+
+// tsvReader reads torrent <tab> contained file lines, with any further
+// contained files in more fields. The fields are trimmed, so names can't
+// have tabs or surrounding spaces; csv and jsonl input can.
+type tsvReader struct {
+	s *bufio.Scanner
+}
+
+func (t *tsvReader) next() (*record, error) {
+	if !t.s.Scan() {
+		if err := t.s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	line := t.s.Text()
+	ts := strings.Split(line, "\t")
+	for i := range ts {
+		ts[i] = strings.TrimSpace(ts[i])
+	}
+	rec := &record{tor: ts[0]}
+	if len(ts) > 1 {
+		rec.file = ts[1]
+	}
+	if len(ts) > 2 {
+		for _, f := range ts[2:] {
+			if f == "" {
+				return nil, &badRecord{fmt.Errorf("invalid line: %q", line)}
+			}
+			rec.others = append(rec.others, f)
+		}
+	}
+	return rec, nil
+}
+
+// csvReader reads torrent,file[,size] records, skipping a header naming
+// the fields. Further contained files follow the size as more file,size
+// pairs, with the size left empty when it isn't known.
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (c *csvReader) next() (*record, error) {
+	for {
+		fields, err := c.r.Read()
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return nil, &badRecord{err}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line, _ := c.r.FieldPos(0); line == 1 && len(fields) >= 2 && fields[0] == "torrent" && fields[1] == "file" {
+			continue
+		}
+		if len(fields) == 0 || len(fields) > 3 && len(fields)%2 == 0 {
+			return nil, &badRecord{fmt.Errorf("invalid record: %q", fields)}
+		}
+		rec := &record{tor: fields[0]}
+		if len(fields) > 1 {
+			rec.file = fields[1]
+		}
+		for i := 1; i+1 < len(fields); i += 2 {
+			var size int64
+			if fields[i+1] != "" {
+				if _, err := fmt.Sscan(fields[i+1], &size); err != nil {
+					return nil, &badRecord{fmt.Errorf("invalid size: %q", fields[i+1])}
+				}
+			}
+			if i == 1 {
+				rec.size = size
+				continue
+			}
+			if fields[i] == "" {
+				return nil, &badRecord{fmt.Errorf("invalid record: %q", fields)}
+			}
+			rec.addOther(fields[i], size)
+		}
+		return rec, nil
+	}
+}
+
+// jsonlReader reads one {"torrent", "file", "size"} object per line,
+// skipping blank lines. Several contained files may be given as "files"
+// instead of "file", each a name or a {"file", "size"} object.
+type jsonlReader struct {
+	s *bufio.Scanner
+}
+
+func (j *jsonlReader) next() (*record, error) {
+	for j.s.Scan() {
+		line := j.s.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var v struct {
+			Torrent string       `json:"torrent"`
+			File    string       `json:"file"`
+			Size    int64        `json:"size"`
+			Files   []listedFile `json:"files"`
+		}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return nil, &badRecord{fmt.Errorf("invalid line: %v", err)}
+		}
+		if v.Torrent == "" {
+			return nil, &badRecord{fmt.Errorf("no torrent: %q", line)}
+		}
+		rec := &record{tor: v.Torrent, file: v.File, size: v.Size}
+		files := v.Files
+		if rec.file == "" && len(files) > 0 {
+			rec.file, rec.size, files = files[0].File, files[0].Size, files[1:]
+		}
+		for _, f := range files {
+			if f.File == "" {
+				return nil, &badRecord{fmt.Errorf("file without a name: %q", line)}
+			}
+			rec.addOther(f.File, f.Size)
+		}
+		return rec, nil
+	}
+	if err := j.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// listedFile is an element of a JSON "files" list: a name, or a name and
+// size.
+type listedFile struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+func (f *listedFile) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &f.File)
+	}
+	type plain listedFile
+	return json.Unmarshal(data, (*plain)(f))
+}
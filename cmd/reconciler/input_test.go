@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll returns the records read from input in format, and the errors of
+// its bad records.
+func readAll(t *testing.T, input, format string) ([]*record, []string) {
+	t.Helper()
+	rr := newRecordReader(strings.NewReader(input), format)
+	var recs []*record
+	var bad []string
+	for {
+		rec, err := rr.next()
+		if err == io.EOF {
+			return recs, bad
+		}
+		var br *badRecord
+		if errors.As(err, &br) {
+			bad = append(bad, err.Error())
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestRecordReaders(t *testing.T) {
+	for _, tt := range []struct {
+		name, format, input string
+		want                []*record
+		bad                 []string
+	}{
+		{"tsv", "tsv", "a.torrent\tdir/f.mkv\n b.torrent \t f.mkv \t g.nfo\tdir/h.srt\nmagnet:?xt=x\n",
+			[]*record{
+				{tor: "a.torrent", file: "dir/f.mkv"},
+				{tor: "b.torrent", file: "f.mkv", others: []string{"g.nfo", "dir/h.srt"}},
+				{tor: "magnet:?xt=x"},
+			}, nil},
+		{"tsv empty other", "tsv", "a.torrent\tf\t\tg\nb.torrent\tf\n",
+			[]*record{{tor: "b.torrent", file: "f"}},
+			[]string{`invalid line: "a.torrent\tf\t\tg"`}},
+		{"csv", "csv", "torrent,file,size\na.torrent,f.mkv,5\n\"b,c.torrent\",\"x, y.mkv\",,o.nfo,3,p.srt,\n",
+			[]*record{
+				{tor: "a.torrent", file: "f.mkv", size: 5},
+				{tor: "b,c.torrent", file: "x, y.mkv", others: []string{"o.nfo", "p.srt"}, sizes: map[string]int64{"o.nfo": 3}},
+			}, nil},
+		// a header is only skipped on the first line
+		{"csv late header", "csv", "a.torrent,f\ntorrent,file\n",
+			[]*record{{tor: "a.torrent", file: "f"}, {tor: "torrent", file: "file"}}, nil},
+		{"csv bad", "csv", "a.torrent,f,big\nb.torrent,f,1,o\nc.torrent,f,1,,2\nd.torrent\n",
+			[]*record{{tor: "d.torrent"}},
+			[]string{`invalid size: "big"`, `invalid record: ["b.torrent" "f" "1" "o"]`, `invalid record: ["c.torrent" "f" "1" "" "2"]`}},
+		{"jsonl", "jsonl", `{"torrent":"a.torrent","file":"f.mkv","size":2}` + "\n\n  \n" +
+			`{"torrent":"b.torrent","files":["x.mkv",{"file":"y.nfo","size":4},{"file":"z.srt"}]}` + "\n" +
+			`{"torrent":"c.torrent","file":"f.mkv","files":["g.nfo"]}` + "\n",
+			[]*record{
+				{tor: "a.torrent", file: "f.mkv", size: 2},
+				{tor: "b.torrent", file: "x.mkv", others: []string{"y.nfo", "z.srt"}, sizes: map[string]int64{"y.nfo": 4}},
+				{tor: "c.torrent", file: "f.mkv", others: []string{"g.nfo"}},
+			}, nil},
+		{"jsonl bad", "jsonl", "{\"file\":\"f\"}\nnot json\n" + `{"torrent":"a","files":["x",{"size":1}]}` + "\n" + `{"torrent":"b"}`,
+			[]*record{{tor: "b"}},
+			[]string{`no torrent: "{\"file\":\"f\"}"`, "invalid line: invalid character 'o' in literal null (expecting 'u')", `file without a name: "{\"torrent\":\"a\",\"files\":[\"x\",{\"size\":1}]}"`}},
+	} {
+		recs, bad := readAll(t, tt.input, tt.format)
+		if !reflect.DeepEqual(recs, tt.want) {
+			t.Errorf("%s: records:", tt.name)
+			for _, r := range recs {
+				t.Errorf("\t%+v", *r)
+			}
+			t.Errorf("want:")
+			for _, r := range tt.want {
+				t.Errorf("\t%+v", *r)
+			}
+		}
+		if !reflect.DeepEqual(bad, tt.bad) {
+			t.Errorf("%s: bad records %q, want %q", tt.name, bad, tt.bad)
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	defer func(f string) { inputFormat = f }(inputFormat)
+	for _, tt := range []struct {
+		format, name, want string
+	}{
+		{"auto", "list.csv", "csv"},
+		{"auto", "list.CSV.gz", "csv"},
+		{"auto", "list.jsonl.zst", "jsonl"},
+		{"auto", "list.ndjson", "jsonl"},
+		{"auto", "list.txt", "tsv"},
+		{"auto", "list.gz", "tsv"},
+		{"auto", "-", "tsv"},
+		{"csv", "list.jsonl", "csv"},
+	} {
+		inputFormat = tt.format
+		if got := formatOf(tt.name); got != tt.want {
+			t.Errorf("--input-format %s: formatOf(%q) = %q, want %q", tt.format, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenInput(t *testing.T) {
+	dir := t.TempDir()
+	const content = "a.torrent\tf.mkv\n"
+	plain := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(plain, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// gzip is recognized by its magic number, not the name
+	gz := filepath.Join(dir, "list")
+	f, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for _, name := range []string{plain, gz} {
+		in, err := openInput(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		b, err := io.ReadAll(in)
+		if err != nil || string(b) != content {
+			t.Errorf("%s: read %q, %v, want %q", name, b, err, content)
+		}
+		if err := in.Close(); err != nil {
+			t.Errorf("%s: Close: %v", name, err)
+		}
+	}
+	if _, err := openInput(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: %v, want not exist", err)
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.tsv", "a.torrent", "sub/c.csv.gz", "sub/notes.md", ".hidden/d.txt", "sub/.e.jsonl", "f.JSONL"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(names ...string) []string {
+		var l []string
+		for _, n := range names {
+			l = append(l, filepath.Join(dir, n))
+		}
+		return l
+	}
+	for _, tt := range []struct {
+		name string
+		args []string
+		want []string
+		errs int
+	}{
+		{"dir", []string{dir}, in("a.torrent", "b.tsv", "f.JSONL", "sub/c.csv.gz"), 0},
+		{"glob", []string{filepath.Join(dir, "*.t*")}, in("a.torrent", "b.tsv"), 0},
+		{"file and stdin", []string{filepath.Join(dir, "sub/notes.md"), "-"}, append(in("sub/notes.md"), "-"), 0},
+		// left for openInput to report
+		{"missing", []string{filepath.Join(dir, "nope.txt")}, in("nope.txt"), 0},
+		{"unmatched glob", []string{filepath.Join(dir, "*.nope"), filepath.Join(dir, "b.tsv")}, in("b.tsv"), 1},
+	} {
+		names, errs := expandInputs(tt.args)
+		if !reflect.DeepEqual(names, tt.want) || len(errs) != tt.errs {
+			t.Errorf("%s: expandInputs = %q, %v, want %q and %d errors", tt.name, names, errs, tt.want, tt.errs)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The keyring is the login keychain, through security(1).
+
+// security's exit status when there's no such item
+const errSecItemNotFound = 44
+
+func keyringGet(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == errSecItemNotFound {
+		return "", errNoKey
+	}
+	if err != nil {
+		return "", securityError(err, stderr.String())
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func keyringSet(service, account, secret string) error {
+	// given as a command on stdin, the password isn't in the arguments
+	// other processes can see
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		strconv.Quote(service), strconv.Quote(account), strconv.Quote(secret))
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return securityError(err, stderr.String())
+	}
+	return nil
+}
+
+func securityError(err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("security: %s", msg)
+	}
+	return fmt.Errorf("security: %v", err)
+}
@@ -0,0 +1,48 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The keyring is the freedesktop Secret Service, through libsecret's
+// secret-tool.
+
+func keyringGet(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && stderr.Len() == 0 {
+		// secret-tool exits 1, saying nothing, when there's no such secret
+		return "", errNoKey
+	}
+	if err != nil {
+		return "", secretToolError(err, stderr.String())
+	}
+	return string(out), nil
+}
+
+func keyringSet(service, account, secret string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label="+service+" "+account, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError(err, stderr.String())
+	}
+	return nil
+}
+
+func secretToolError(err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("secret-tool: %s", msg)
+	}
+	return fmt.Errorf("secret-tool: %v", err)
+}
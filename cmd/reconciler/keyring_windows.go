@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// The keyring is the Windows Credential Manager, as generic credentials
+// targeted service:account.
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// credential is CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func keyringGet(service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errors.Is(err, errorNotFound) {
+			return "", errNoKey
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func keyringSet(service, account, secret string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(secret) > 0 {
+		blob := []byte(secret)
+		cred.CredentialBlob = &blob[0]
+	}
+	r, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// With --link-dir, a torrent whose files aren't all under the matched dir,
+// because its root folder is named differently or its files are spread
+// across directories, is given a tree of hard links laid out as the torrent
+// expects, under linkDir/<info hash>, and is added there. Each file missing
+// from the matched dir is looked up in the DB by name, preferring the
+// location sharing the most trailing path components with the torrent's,
+// and must be readable here at the torrent's size. The links are only
+// made when the torrent is added. Hard links must be on the data's
+// filesystem; with --link-mode=symlink the tree is of symlinks instead,
+// and with auto, of hard links but for data on other filesystems. A
+// symlink points at the data's path on this host, which the client must
+// see the data at too: before adding a torrent given symlinks, a symlink
+// to each dir they point into is made beside them, and the client is asked
+// for the free space through it, which fails where it can't follow it. Unlike with hard links, removing such a
+// torrent with its data in the client deletes only the links, and deleting
+// or moving the data leaves the links dangling.
+var linkDir string
+var linkMode string
+
+// A torrent whose files are found under more than one dir, as when its
+// data has been split across disks, is reported as split when its links
+// are planned, or with --report-split, also without --link-dir.
+var reportSplit bool
+
+// With --download-dir-template too, every matched torrent with a file list
+// is given a link tree, at the template's expansion rather than under
+// linkDir/<info hash>, so torrents are filed by their own names instead of
+// the matched paths. {torrent_name}, {tracker}, {year}, from the name, and
+// {hash} are replaced; a relative result is under linkDir.
+var downloadDirTemplate string
+
+var templateVar = regexp.MustCompile(`\{[^}]*\}`)
+
+var templateYear = regexp.MustCompile(`\b(19|20)\d\d\b`)
+
+func checkLinks() error {
+	switch linkMode {
+	case "hard", "symlink", "auto":
+	default:
+		return fmt.Errorf("--link-mode must be hard, symlink or auto")
+	}
+	if downloadDirTemplate == "" {
+		return nil
+	}
+	if linkDir == "" {
+		return fmt.Errorf("--download-dir-template needs --link-dir")
+	}
+	for _, v := range templateVar.FindAllString(downloadDirTemplate, -1) {
+		switch v {
+		case "{torrent_name}", "{tracker}", "{year}", "{hash}":
+		default:
+			return fmt.Errorf("--download-dir-template: unknown variable %s", v)
+		}
+	}
+	return nil
+}
+
+// linkRoot returns the dir of ti's link tree.
+func linkRoot(ti *metainfo.Info) string {
+	if downloadDirTemplate == "" {
+		return filepath.Join(linkDir, ti.InfoHash)
+	}
+	year := ""
+	if m := templateYear.FindAllString(ti.Name, -1); len(m) > 0 {
+		// a title may have a year in it too
+		year = m[len(m)-1]
+	}
+	dir := templateVar.ReplaceAllStringFunc(downloadDirTemplate, func(v string) string {
+		switch v {
+		case "{torrent_name}":
+			return pathElement(ti.Name)
+		case "{tracker}":
+			return pathElement(cfg.trackerName(ti.Announce))
+		case "{year}":
+			return year
+		}
+		return ti.InfoHash
+	})
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(linkDir, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// pathElement makes s usable as one element of a path.
+func pathElement(s string) string {
+	s = strings.NewReplacer("/", "_", `\`, "_").Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// splitTorrent is a torrent whose files were found under several dirs.
+type splitTorrent struct {
+	Torrent string   `json:"torrent"`
+	Dirs    []string `json:"dirs"`
+	// given a link tree unifying them
+	Linked bool `json:"linked"`
+}
+
+// link is a hard link to make: dst, in the link tree, to src, the data.
+type link struct {
+	src, dst string
+}
+
+// planLinks returns the links giving ti's files, of which those at the
+// indices in missing aren't under dir, the torrent's layout under the
+// returned dir, and the dirs the files are taken from, in the torrent's
+// order. It returns false if any file can't be found.
+func planLinks(ctx context.Context, stmt *sql.Stmt, dir string, ti *metainfo.Info, missing []int) (string, []link, []string, bool, error) {
+	root := linkRoot(ti)
+	isMissing := make(map[int]bool)
+	for _, i := range missing {
+		isMissing[i] = true
+	}
+	var links []link
+	var dirs []string
+	for i, p := range ti.Paths() {
+		if p == "" {
+			// padding
+			continue
+		}
+		d := strings.TrimSuffix(dir, "/")
+		src := d + "/" + p
+		if isMissing[i] {
+			var ok bool
+			var err error
+			src, d, ok, err = findElsewhere(ctx, stmt, p, ti.Files[i].Length)
+			if err != nil || !ok {
+				return "", nil, nil, false, err
+			}
+		}
+		if !slices.Contains(dirs, d) {
+			dirs = append(dirs, d)
+		}
+		dst := filepath.Join(root, p)
+		if rel, err := filepath.Rel(root, dst); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", nil, nil, false, fmt.Errorf("%s would be linked outside %s", p, root)
+		}
+		links = append(links, link{src, dst})
+	}
+	return root, links, dirs, true, nil
+}
+
+// findElsewhere returns the DB file best standing in for the torrent file
+// at path p of the given length, and the dir it is under in place of the
+// torrent's download dir, as far as their paths agree.
+func findElsewhere(ctx context.Context, stmt *sql.Stmt, p string, length int64) (string, string, bool, error) {
+	want := strings.Split(p, "/")
+	var results []string
+	err := withRetry(ctx, "query", transientDB, func() (err error) {
+		results, err = lookup(stmt, "/"+want[len(want)-1])
+		return err
+	})
+	if err != nil {
+		return "", "", false, err
+	}
+	best, bestScore := "", 0
+	for _, fullpath := range results {
+		if excluded(fullpath) {
+			continue
+		}
+		if fi, err := os.Stat(fullpath); err != nil || fi.Size() != length {
+			continue
+		}
+		if score := sharedSuffix(strings.Split(fullpath, "/"), want); score > bestScore {
+			best, bestScore = fullpath, score
+		}
+	}
+	if best == "" {
+		return "", "", false, nil
+	}
+	parts := strings.Split(best, "/")
+	return best, strings.Join(parts[:len(parts)-bestScore], "/"), true, nil
+}
+
+// sharedSuffix counts the trailing path components a and b share.
+func sharedSuffix(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && names().Same(a[len(a)-1-n], b[len(b)-1-n]) {
+		n++
+	}
+	return n
+}
+
+// makeLinks creates the links, leaving those already in place.
+func makeLinks(links []link) error {
+	for _, l := range links {
+		if err := os.MkdirAll(filepath.Dir(l.dst), 0755); err != nil {
+			return err
+		}
+		err := makeLink(l.src, l.dst)
+		if err == nil {
+			continue
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		src, serr := os.Stat(l.src)
+		dst, derr := os.Stat(l.dst)
+		if serr != nil || derr != nil || !os.SameFile(src, dst) {
+			return fmt.Errorf("%s exists and isn't a link to %s", l.dst, l.src)
+		}
+	}
+	return nil
+}
+
+// makeLink links dst to src as --link-mode says.
+func makeLink(src, dst string) error {
+	if linkMode == "hard" {
+		return os.Link(src, dst)
+	}
+	if linkMode == "auto" {
+		if err := os.Link(src, dst); !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	symlinkWarning.Do(func() {
+		slog.Warn("making symlinks: removing their torrents with data in the client deletes only the links, and deleting or moving the data breaks the torrents")
+	})
+	return os.Symlink(src, dst)
+}
+
+var symlinkWarning sync.Once
+
+// followed holds the client and dir pairs checkFollows found followed.
+var followed sync.Map
+
+// checkFollows checks that cl follows the symlinks among links: for each
+// dir they point into, it makes a symlink to the dir beside the links and
+// asks cl for the free space through it, which fails where cl can't
+// follow it.
+func checkFollows(ctx context.Context, cl *endpoint, links []link) error {
+	// the dirs pointed into, and a dir of links into each
+	var dirs, at []string
+	for _, l := range links {
+		fi, err := os.Lstat(l.dst)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if d := filepath.Dir(l.src); !slices.Contains(dirs, d) {
+			dirs = append(dirs, d)
+			at = append(at, filepath.Dir(l.dst))
+		}
+	}
+	for i, d := range dirs {
+		key := cl.name + "\x00" + d
+		if _, ok := followed.Load(key); ok {
+			continue
+		}
+		b := make([]byte, 8)
+		rand.Read(b)
+		probe := filepath.Join(at[i], ".reconciler-follow-"+hex.EncodeToString(b))
+		if err := os.Symlink(d, probe); err != nil {
+			return err
+		}
+		err := withRetry(ctx, "free-space", client.Transient, func() error {
+			_, err := cl.rpc.FreeSpace(ctx, clientPath(probe))
+			return err
+		})
+		os.Remove(probe)
+		if err != nil {
+			return fmt.Errorf("client %s can't follow symlinks from %s to %s: %v", cl.name, at[i], d, err)
+		}
+		followed.Store(key, true)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// With --source=locate, files come from `locate`, which works with plocate
+// and mlocate alike but can't tell files from directories, or with
+// locateDB, from reading an mlocate database directly.
+var locateDB string
+
+// under reports whether p is below one of roots.
+func under(p string, roots []string) bool {
+	for _, root := range roots {
+		prefix := strings.TrimSuffix(slashed(root), "/") + "/"
+		if strings.HasPrefix(p, prefix) || windowsPaths && len(p) >= len(prefix) && strings.EqualFold(p[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// runLocate calls add with the dir and name of each path locate has under
+// roots.
+func runLocate(ctx context.Context, roots []string, add func(dir, file string) error) error {
+	for _, root := range roots {
+		cmd := exec.CommandContext(ctx, "locate", "-0", "--regex", "^"+regexp.QuoteMeta(strings.TrimSuffix(root, "/"))+"/")
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		r := bufio.NewReader(out)
+		for {
+			p, err := r.ReadString(0)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cmd.Wait()
+				return err
+			}
+			p = strings.TrimSuffix(p, "\x00")
+			if !under(p, []string{root}) {
+				continue
+			}
+			if err := add(path.Dir(p), path.Base(p)); err != nil {
+				cmd.Wait()
+				return err
+			}
+		}
+		if err := cmd.Wait(); err != nil {
+			// locate exits 1 when nothing matches
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+				return fmt.Errorf("locate: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+const mlocateMagic = "\x00mlocate"
+
+// mlocate directory entry types
+const (
+	mlocateFile = 0
+	mlocateDir  = 1
+	mlocateEnd  = 2
+)
+
+// readMlocate calls add with the dir and name of each file under roots in
+// the mlocate database at name.
+func readMlocate(ctx context.Context, name string, roots []string, add func(dir, file string) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 1<<16)
+	// magic, configuration block size, version, visibility flag, padding
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if string(header[:8]) != mlocateMagic {
+		return fmt.Errorf("%s: not an mlocate database; leave out --locate-db to run locate", name)
+	}
+	if header[12] != 0 {
+		return fmt.Errorf("%s: unknown mlocate version %d", name, header[12])
+	}
+	confSize := binary.BigEndian.Uint32(header[8:12])
+	if _, err := r.ReadString(0); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if _, err := r.Discard(int(confSize)); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the directory's mtime, and padding
+		var dirHeader [16]byte
+		if _, err := io.ReadFull(r, dirHeader[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		dir, err := r.ReadBytes(0)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		dir = bytes.TrimSuffix(dir, []byte{0})
+		keep := under(string(dir)+"/", roots)
+		for {
+			typ, err := r.ReadByte()
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if typ == mlocateEnd {
+				break
+			}
+			if typ != mlocateFile && typ != mlocateDir {
+				return fmt.Errorf("%s: bad entry type %d", name, typ)
+			}
+			entry, err := r.ReadBytes(0)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if keep && typ == mlocateFile {
+				if err := add(string(dir), string(bytes.TrimSuffix(entry, []byte{0}))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
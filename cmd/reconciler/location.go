@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// A matched torrent a client already has may be there with a download dir
+// other than where the DB says its data is. Each one is logged and listed
+// in the report as misplaced; with --fix-location, the client is pointed at
+// the DB's dir, without moving any data, and verifies the torrent there.
+// Torrents of dedupe-only clients are only reported.
+var fixLocation bool
+
+// misplacedTorrent is a torrent already in a client, under a download dir
+// that isn't the matched one.
+type misplacedTorrent struct {
+	Torrent  string `json:"torrent"`
+	InfoHash string `json:"info_hash"`
+	Client   string `json:"client"`
+	// the client's download dir and the matched one
+	ClientDir  string `json:"client_dir"`
+	MatchedDir string `json:"matched_dir"`
+	Fixed      bool   `json:"fixed"`
+}
+
+// sameDir reports whether download dirs a and b are the same, ignoring
+// trailing separators, and case with --windows-paths.
+func sameDir(a, b string) bool {
+	a, b = strings.TrimRight(a, `/\`), strings.TrimRight(b, `/\`)
+	if windowsPaths {
+		return strings.EqualFold(slashed(a), slashed(b))
+	}
+	return a == b
+}
+
+// checkLocation compares the download dir of match's torrent, which a
+// client already has, with the matched one.
+func checkLocation(ctx context.Context, clients *clientPool, match *matchedFile, rep *report, errc chan<- *pipelineError) {
+	l, ok := clients.listing(match.hashes()...)
+	if !ok {
+		// added earlier in this run
+		return
+	}
+	dir := clientPath(match.path)
+	if sameDir(l.t.DownloadDir, dir) {
+		return
+	}
+	m := &misplacedTorrent{
+		Torrent:    match.tor,
+		InfoHash:   match.infoHash,
+		Client:     l.e.name,
+		ClientDir:  l.t.DownloadDir,
+		MatchedDir: dir,
+	}
+	slog.Warn("present in another download dir", "torrent", match.tor, "name", l.t.Name, "client", l.e.name, "client_dir", l.t.DownloadDir, "matched_dir", dir)
+	if fixLocation && !l.e.dedupeOnly {
+		err := withRetry(ctx, "set location", client.Transient, func() error {
+			return l.e.rpc.SetLocation(context.WithoutCancel(ctx), l.t.ID, dir)
+		})
+		if err == nil {
+			err = withRetry(ctx, "verify", client.Transient, func() error {
+				return l.e.rpc.Verify(context.WithoutCancel(ctx), l.t.ID)
+			})
+		}
+		if err != nil {
+			errc <- failure(errRPC, match.tor, fmt.Errorf("setting location: %w", err))
+		} else {
+			slog.Info("set location", "torrent", match.tor, "name", l.t.Name, "client", l.e.name, "dir", dir)
+			m.Fixed = true
+		}
+	}
+	rep.misplaced(m)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --lock-file, runs that add or remove torrents hold an advisory lock,
+// so that one started from cron while the last is still going doesn't add
+// the same torrents again. The lock is a file, created holding its owner's
+// pid and host and removed when the run ends; one left by a run on this
+// host that's gone is stale and taken over. A run that finds the lock held
+// exits, or with --wait waits for it.
+var (
+	lockPath string
+	lockWait bool
+)
+
+var errLocked = errors.New("held by another run")
+
+const (
+	lockPoll = time.Second
+	// how long a lock file may be without its owner before it's stale,
+	// for one whose owner died while writing it
+	lockOwnerGrace = time.Minute
+)
+
+// acquireLock takes the lock at lockPath, if set, and returns a func that
+// releases it.
+func acquireLock(ctx context.Context) (func(), error) {
+	if lockPath == "" {
+		return func() {}, nil
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), host)
+	waiting := false
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(owner)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return func() { releaseLock(owner) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		held, err := readLock()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if stale(held, host) {
+			// another run may have found it stale first and taken it over
+			if now, err := readLock(); err == nil && now == held {
+				slog.Warn("taking over stale lock", "file", lockPath, "owner", strings.TrimSpace(held))
+				if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !lockWait {
+			return nil, fmt.Errorf("lock %s: %w: %s", lockPath, errLocked, strings.TrimSpace(held))
+		}
+		if !waiting {
+			slog.Info("waiting for lock", "file", lockPath, "owner", strings.TrimSpace(held))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// readLock returns the owner written in the lock file.
+func readLock() (string, error) {
+	b, err := os.ReadFile(lockPath)
+	return string(b), err
+}
+
+// stale reports whether the lock's owner is known to be gone: a process
+// on this host that no longer exists, or garbage. Owners on other hosts
+// can't be checked and hold the lock.
+func stale(owner, host string) bool {
+	if owner == "" {
+		// not written yet, unless it's been a while
+		fi, err := os.Stat(lockPath)
+		return err == nil && time.Since(fi.ModTime()) > lockOwnerGrace
+	}
+	pidStr, ownerHost, ok := strings.Cut(strings.TrimSpace(owner), " ")
+	pid, err := strconv.Atoi(pidStr)
+	if !ok || err != nil || pid <= 0 {
+		return true
+	}
+	if ownerHost != host {
+		return false
+	}
+	if pid == os.Getpid() {
+		// left by an earlier process with our pid, as in a container
+		return true
+	}
+	return processGone(pid)
+}
+
+// releaseLock removes the lock file if owner still holds it.
+func releaseLock(owner string) {
+	if held, err := readLock(); err != nil || held != owner {
+		slog.Warn("lock file was taken over; leaving it", "file", lockPath)
+		return
+	}
+	if err := os.Remove(lockPath); err != nil {
+		slog.Error("removing lock file", "file", lockPath, "err", err)
+	}
+}
+
+// lockFailed reports a failure to take the lock and returns the exit code.
+func lockFailed(err error) int {
+	switch {
+	case errors.Is(err, errLocked):
+		slog.Warn("not running", "err", err)
+		return exitLocked
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	}
+	log.Fatal(err)
+	return exitFailed
+}
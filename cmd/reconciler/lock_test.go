@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exitedPid returns the pid of a process that has exited.
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestStale(t *testing.T) {
+	defer func(p string) { lockPath = p }(lockPath)
+	lockPath = filepath.Join(t.TempDir(), "lock")
+	if err := os.WriteFile(lockPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	host := "here"
+	for _, tt := range []struct {
+		name, owner string
+		want        bool
+	}{
+		{"being written", "", false},
+		{"garbage", "garbage\n", true},
+		{"zero pid", "0 here\n", true},
+		{"other host", fmt.Sprintf("%d there\n", exitedPid(t)), false},
+		{"live", fmt.Sprintf("%d here\n", os.Getppid()), false},
+		{"exited", fmt.Sprintf("%d here\n", exitedPid(t)), true},
+		{"our pid", fmt.Sprintf("%d here\n", os.Getpid()), true},
+	} {
+		if got := stale(tt.owner, host); got != tt.want {
+			t.Errorf("%s: stale(%q) = %v, want %v", tt.name, tt.owner, got, tt.want)
+		}
+	}
+	old := time.Now().Add(-2 * lockOwnerGrace)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !stale("", host) {
+		t.Errorf("stale of an empty lock file left for %s = false, want true", 2*lockOwnerGrace)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	defer func(p string, w bool) { lockPath, lockWait = p, w }(lockPath, lockWait)
+	lockPath, lockWait = filepath.Join(t.TempDir(), "lock"), false
+	host, _ := os.Hostname()
+	ctx := context.Background()
+
+	release, err := acquireLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := readLock(); held != fmt.Sprintf("%d %s\n", os.Getpid(), host) {
+		t.Errorf("lock holds %q", held)
+	}
+	release()
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file after release: %v", err)
+	}
+
+	// held by a live process
+	live := fmt.Sprintf("%d %s\n", os.Getppid(), host)
+	if err := os.WriteFile(lockPath, []byte(live), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(ctx); !errors.Is(err, errLocked) || !strings.Contains(err.Error(), strings.TrimSpace(live)) {
+		t.Errorf("acquireLock of a held lock = %v, want %v naming its owner", err, errLocked)
+	}
+	lockWait = true
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireLock(cancelled); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquireLock waiting = %v, want %v", err, context.DeadlineExceeded)
+	}
+	lockWait = false
+
+	// left by an exited process
+	if err := os.WriteFile(lockPath, []byte(fmt.Sprintf("%d %s\n", exitedPid(t), host)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release, err = acquireLock(ctx)
+	if err != nil {
+		t.Fatalf("acquireLock of a stale lock: %v", err)
+	}
+	// then taken over by another run, whose lock stays
+	if err := os.WriteFile(lockPath, []byte(live), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if held, _ := readLock(); held != live {
+		t.Errorf("release of a lock taken over left %q, want %q", held, live)
+	}
+}
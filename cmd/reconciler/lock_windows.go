@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// processGone reports whether there's no running process pid. Signals
+// can't be sent on Windows, so the process is opened and its exit code
+// asked for instead.
+func processGone(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// another user's, which can't be opened, is still there
+		return !errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code != stillActive
+}
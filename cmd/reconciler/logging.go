@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logLevel string
+var logFormat string
+
+// --quiet and --verbose are --log-level=warn and debug.
+var quiet, verbose bool
+
+// Of the records with the same level, message and attributes, only logBurst
+// are logged per logWindow, so that thousands of lines of one kind don't bury the
+// rest. The others are counted, and logged as one "message repeated"
+// record as the window ends.
+var logBurst int
+
+const logWindow = time.Minute
+
+// setupLogging sends all logging, including the log package's, to console
+// and, if it isn't nil, file, at logLevel in logFormat. Under a progress
+// line, the console only gets warnings and errors, unless --log-level or
+// --verbose was given.
+func setupLogging(console, file io.Writer) error {
+	switch {
+	case quiet && verbose:
+		return fmt.Errorf("--quiet and --verbose conflict")
+	case (quiet || verbose) && flagGiven("log-level"):
+		return fmt.Errorf("--quiet and --verbose replace --log-level")
+	case quiet:
+		logLevel = "warn"
+	case verbose:
+		logLevel = "debug"
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid --log-level %q", logLevel)
+	}
+	if logBurst < 0 {
+		return fmt.Errorf("--log-burst can't be negative")
+	}
+	if progress == nil {
+		w := console
+		if file != nil {
+			w = io.MultiWriter(console, file)
+		}
+		h, err := newLogHandler(w, level)
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(limitRepeats(h)))
+		return nil
+	}
+	consoleLevel := level
+	if !flagGiven("log-level") && !verbose && consoleLevel < slog.LevelWarn {
+		consoleLevel = slog.LevelWarn
+	}
+	h, err := newLogHandler(progress, consoleLevel)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		fh, err := newLogHandler(file, level)
+		if err != nil {
+			return err
+		}
+		h = teeHandler{h, fh}
+	}
+	slog.SetDefault(slog.New(limitRepeats(h)))
+	return nil
+}
+
+// repeats limits the records logged, or is nil with --log-burst=0.
+var repeats *repeatLimiter
+
+type repeatKey struct {
+	level slog.Level
+	msg   string
+	// a hash of the record's attributes, so that messages about different
+	// torrents aren't repeats
+	attrs uint64
+}
+
+type repeatCount struct {
+	start time.Time
+	// records logged and held back in the window from start
+	logged, held int
+	// the attributes of the records
+	attrs []slog.Attr
+}
+
+type repeatLimiter struct {
+	mu  sync.Mutex
+	out slog.Handler
+	// level, message and attributes to their counts in the current window
+	counts map[repeatKey]*repeatCount
+}
+
+// limitRepeats returns h limited to logBurst repeats, and starts logging
+// the counts of those held back.
+func limitRepeats(h slog.Handler) slog.Handler {
+	if logBurst == 0 {
+		return h
+	}
+	repeats = &repeatLimiter{out: h, counts: make(map[repeatKey]*repeatCount)}
+	go func() {
+		for range time.Tick(logWindow) {
+			repeats.flush(false)
+		}
+	}()
+	return limitHandler{h, repeats}
+}
+
+// allow reports whether r is to be logged, counting it if not.
+func (l *repeatLimiter) allow(r slog.Record) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := repeatKey{r.Level, r.Message, attrsHash(r)}
+	c := l.counts[k]
+	if c == nil || r.Time.Sub(c.start) >= logWindow {
+		if c != nil {
+			l.summarize(k, c)
+		}
+		c = &repeatCount{start: r.Time}
+		r.Attrs(func(a slog.Attr) bool {
+			c.attrs = append(c.attrs, a)
+			return true
+		})
+		l.counts[k] = c
+	}
+	if c.logged < logBurst {
+		c.logged++
+		return true
+	}
+	c.held++
+	return false
+}
+
+// attrsHash hashes the attributes of r.
+func attrsHash(r slog.Record) uint64 {
+	h := fnv.New64a()
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(h, "%s\x00", a)
+		return true
+	})
+	return h.Sum64()
+}
+
+// flush logs the counts held back in windows that are over, or with all,
+// in every window.
+func (l *repeatLimiter) flush(all bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, c := range l.counts {
+		if all || time.Since(c.start) >= logWindow {
+			l.summarize(k, c)
+			delete(l.counts, k)
+		}
+	}
+}
+
+// summarize logs how many records c held back. l.mu must be held.
+func (l *repeatLimiter) summarize(k repeatKey, c *repeatCount) {
+	if c.held == 0 || !l.out.Enabled(context.Background(), k.level) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), k.level, "message repeated", 0)
+	r.AddAttrs(slog.String("message", k.msg), slog.Int("times", c.held), slog.Duration("within", time.Since(c.start).Round(time.Second)))
+	r.AddAttrs(c.attrs...)
+	l.out.Handle(context.Background(), r)
+}
+
+// limitHandler logs through its handler the records its limiter allows.
+type limitHandler struct {
+	slog.Handler
+	l *repeatLimiter
+}
+
+func (h limitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.l.allow(r) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h limitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return limitHandler{h.Handler.WithAttrs(attrs), h.l}
+}
+
+func (h limitHandler) WithGroup(name string) slog.Handler {
+	return limitHandler{h.Handler.WithGroup(name), h.l}
+}
+
+func newLogHandler(w io.Writer, level slog.Level) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		// source locations only help when debugging
+		AddSource: level <= slog.LevelDebug,
+	}
+	var h slog.Handler
+	switch strings.ToLower(logFormat) {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "journal":
+		// journald stamps lines itself
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+		h = &journalHandler{slog.NewTextHandler(w, opts), w, new(sync.Mutex)}
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q", logFormat)
+	}
+	return h, nil
+}
+
+// flagGiven reports whether the flag name was set on the command line.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// teeHandler sends records to each of its handlers that takes them.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	u := make(teeHandler, len(t))
+	for i, h := range t {
+		u[i] = h.WithAttrs(attrs)
+	}
+	return u
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	u := make(teeHandler, len(t))
+	for i, h := range t {
+		u[i] = h.WithGroup(name)
+	}
+	return u
+}
+
+// journalHandler writes text lines prefixed with their syslog priority,
+// which journald takes from lines written to it on stderr, so that levels
+// show as priorities in journalctl.
+type journalHandler struct {
+	slog.Handler
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	// the prefix and its line go out together
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(h.w, "<%d>", journalPriority(r.Level)); err != nil {
+		return err
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &journalHandler{h.Handler.WithAttrs(attrs), h.w, h.mu}
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	return &journalHandler{h.Handler.WithGroup(name), h.w, h.mu}
+}
+
+// journalPriority returns the syslog priority of level.
+func journalPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// Magnet links stand in for .torrent files in the input. With no metainfo
+// there's no file list, so a magnet is matched by its contained file column
+// or, failing that, by its display name.
+
+const magnetPrefix = "magnet:?"
+
+// NameQuery finds files whose full path has a component with a given name,
+// last or otherwise.
+const NameQuery = "select {path} || '/' || {file} from {files} where {path} || '/' || {file} like ? or {path} || '/' || {file} like ?"
+
+func isMagnet(s string) bool {
+	return strings.HasPrefix(s, magnetPrefix)
+}
+
+// parseMagnet returns what a magnet link says about its torrent.
+func parseMagnet(uri string) (*metainfo.Info, error) {
+	q, err := url.ParseQuery(strings.TrimPrefix(uri, magnetPrefix))
+	if err != nil {
+		return nil, &metainfo.ParseError{File: uri, Err: err}
+	}
+	ti := &metainfo.Info{
+		Name:     q.Get("dn"),
+		Announce: q["tr"],
+	}
+	// a hybrid's link carries both hashes
+	for _, xt := range q["xt"] {
+		if h, ok := strings.CutPrefix(xt, "urn:btih:"); ok {
+			ti.InfoHash, err = btih(h)
+		} else if h, ok := strings.CutPrefix(xt, "urn:btmh:"); ok {
+			ti.InfoHashV2, err = btmh(h)
+		}
+		if err != nil {
+			return nil, &metainfo.ParseError{File: uri, Err: err}
+		}
+	}
+	if ti.InfoHash == "" && ti.InfoHashV2 != "" {
+		ti.InfoHash = ti.InfoHashV2[:40]
+	}
+	if ti.InfoHash == "" {
+		return nil, &metainfo.ParseError{File: uri, Err: fmt.Errorf("no btih or btmh")}
+	}
+	return ti, nil
+}
+
+// btih returns the hex form of an info hash given in hex or base32.
+func btih(h string) (string, error) {
+	switch len(h) {
+	case 40:
+		if _, err := hex.DecodeString(h); err != nil {
+			return "", fmt.Errorf("invalid btih %q", h)
+		}
+		return strings.ToLower(h), nil
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(h))
+		if err != nil {
+			return "", fmt.Errorf("invalid btih %q", h)
+		}
+		return hex.EncodeToString(b), nil
+	}
+	return "", fmt.Errorf("invalid btih %q", h)
+}
+
+// btmh returns the hex SHA-256 from a v2 multihash, which is hex with a
+// 0x12 (SHA-256) 0x20 (32 bytes) prefix.
+func btmh(h string) (string, error) {
+	h = strings.ToLower(h)
+	rest, ok := strings.CutPrefix(h, "1220")
+	if !ok || len(rest) != 64 {
+		return "", fmt.Errorf("invalid btmh %q", h)
+	}
+	if _, err := hex.DecodeString(rest); err != nil {
+		return "", fmt.Errorf("invalid btmh %q", h)
+	}
+	return rest, nil
+}
+
+// lookupName returns the full paths ending in the path component name, for
+// files named name and directories named name alike.
+func lookupName(stmt *sql.Stmt, name string) ([]string, error) {
+	ctx, cancel := dbContext()
+	defer cancel()
+	pattern := names().LikePattern(name)
+	rows, err := stmt.QueryContext(ctx, "%/"+pattern, "%/"+pattern+"/%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[string]bool)
+	var results []string
+	for rows.Next() {
+		var fullpath string
+		if err := rows.Scan(&fullpath); err != nil {
+			return nil, err
+		}
+		// cut everything below the named directory
+		parts := strings.Split(fullpath, "/")
+		i := len(parts) - 1
+		for i > 0 && !names().Same(parts[i], name) {
+			i--
+		}
+		if i == 0 {
+			// a like wildcard matched something else
+			continue
+		}
+		fullpath = strings.Join(parts[:i+1], "/")
+		if !seen[fullpath] {
+			seen[fullpath] = true
+			results = append(results, fullpath)
+		}
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// With --smtp, a digest of the run is mailed to each --mail-to: its counts,
+// failures and unmatched torrents. It's sent after every run, or with
+// --notify-on failure after failed ones only; in daemon mode, after passes
+// with failures. --smtp is smtp://[user[:password]@]host[:port], which
+// upgrades to TLS when the server offers STARTTLS, or smtps:// for TLS from
+// the start. A password left out of the URL is taken from $SMTP_PASSWORD.
+var smtpURL string
+var mailTo stringList
+var mailFrom string
+
+// lines of each list in a digest
+const maxMailedLines = 200
+
+// mailer sends digests. A nil *mailer, without --smtp, sends nothing.
+type mailer struct {
+	addr, host  string
+	implicitTLS bool
+	auth        smtp.Auth
+	from        string
+	to          []string
+	// mail only for failed passes
+	daemon bool
+}
+
+var mail *mailer
+
+func checkMail() error {
+	if smtpURL == "" {
+		return nil
+	}
+	u, err := url.Parse(smtpURL)
+	if err != nil {
+		return fmt.Errorf("invalid --smtp: %v", err)
+	}
+	if u.Scheme != "smtp" && u.Scheme != "smtps" || u.Hostname() == "" {
+		return fmt.Errorf("--smtp must be smtp://host[:port] or smtps://host[:port]")
+	}
+	if len(mailTo) == 0 {
+		return fmt.Errorf("--smtp needs at least one --mail-to")
+	}
+	return nil
+}
+
+func newMailer() *mailer {
+	if smtpURL == "" {
+		return nil
+	}
+	// checked by checkMail
+	u, _ := url.Parse(smtpURL)
+	m := &mailer{host: u.Hostname(), implicitTLS: u.Scheme == "smtps", from: mailFrom, to: mailTo}
+	port := u.Port()
+	if port == "" {
+		port = "587"
+		if m.implicitTLS {
+			port = "465"
+		}
+	}
+	m.addr = net.JoinHostPort(m.host, port)
+	if u.User != nil {
+		pw, ok := u.User.Password()
+		if !ok {
+			pw = os.Getenv("SMTP_PASSWORD")
+		}
+		m.auth = smtp.PlainAuth("", u.User.Username(), pw, m.host)
+	}
+	if m.from == "" {
+		host, _ := os.Hostname()
+		m.from = "reconciler@" + host
+	}
+	return m
+}
+
+// finished mails the digest of a run or daemon pass.
+func (m *mailer) finished(rep *report) {
+	if m == nil {
+		return
+	}
+	code := rep.exitCode()
+	failed := code == exitFailed || code == exitRPC
+	if !failed && (notifyOn == "failure" || m.daemon) {
+		return
+	}
+	subject := "reconciler: run finished"
+	if failed {
+		subject = "reconciler: run finished with failures"
+	}
+	m.send(subject, rep.digest())
+}
+
+// send mails text. Failures are logged; they never affect the run.
+func (m *mailer) send(subject, text string) {
+	if m == nil {
+		return
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	// the data writer ends lines with CRLF and escapes leading dots
+	b.WriteString(text)
+	if err := m.deliver(b.Bytes()); err != nil {
+		slog.Warn("mail failed", "server", m.addr, "err", err)
+	}
+}
+
+func (m *mailer) deliver(msg []byte) error {
+	d := &net.Dialer{Timeout: notifyTimeout}
+	var conn net.Conn
+	var err error
+	if m.implicitTLS {
+		conn, err = tls.DialWithDialer(d, "tcp", m.addr, &tls.Config{ServerName: m.host})
+	} else {
+		conn, err = d.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(3 * notifyTimeout))
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !m.implicitTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// digest describes the run for mail: the summary, then the failures and
+// the unmatched torrents.
+func (r *report) digest() string {
+	var b strings.Builder
+	host, _ := os.Hostname()
+	fmt.Fprintf(&b, "run on %s at %s, took %s\n\n", host, r.Start.Format(time.RFC1123), time.Since(r.Start).Round(time.Second))
+	b.WriteString(r.summary())
+	b.WriteString("\n")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Failures) > 0 {
+		fmt.Fprintf(&b, "\nfailures (%d):\n", len(r.Failures))
+		for i, e := range r.Failures {
+			if i == maxMailedLines {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(r.Failures)-i)
+				break
+			}
+			if e.Torrent != "" {
+				fmt.Fprintf(&b, "  %s: %s: %s\n", e.Kind, e.Torrent, e.Error)
+			} else {
+				fmt.Fprintf(&b, "  %s: %s\n", e.Kind, e.Error)
+			}
+		}
+	}
+	if len(r.UnmatchedTorrents) > 0 {
+		fmt.Fprintf(&b, "\nunmatched (%d):\n", len(r.UnmatchedTorrents))
+		for i, tor := range r.UnmatchedTorrents {
+			if i == maxMailedLines {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(r.UnmatchedTorrents)-i)
+				break
+			}
+			fmt.Fprintf(&b, "  %s\n", tor)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// With --mark-data, the data dirs of torrents added or found present are
+// marked with the info hashes seeding from them, so that tools looking for
+// orphaned data can tell what's accounted for: xattr sets the
+// user.reconciler.info_hashes extended attribute, a comma-separated list,
+// and file appends to a .reconciled file in the dir, a hash a line. The
+// dir is the matched data dir, or each of them for a torrent split across
+// dirs, and is only marked where --stat-paths would stat it.
+var markData string
+
+const (
+	markAttr = "user.reconciler.info_hashes"
+	markFile = ".reconciled"
+)
+
+// marks serializes the read-modify-writes of marks.
+var marks sync.Mutex
+
+func checkMark() error {
+	switch markData {
+	case "", "file":
+		return nil
+	case "xattr":
+		return xattrSupported()
+	}
+	return fmt.Errorf("--mark-data must be xattr or file")
+}
+
+// mark marks match's data dirs with its hashes.
+func mark(match *matchedFile) error {
+	if markData == "" {
+		return nil
+	}
+	dirs := match.split
+	if len(dirs) == 0 {
+		dirs = []string{strings.TrimSuffix(match.dataDir, "/")}
+	}
+	marks.Lock()
+	defer marks.Unlock()
+	for _, dir := range dirs {
+		if !locallyVisible(dir) {
+			slog.Debug("not marking data dir not visible here", "dir", dir)
+			continue
+		}
+		var err error
+		if markData == "xattr" {
+			err = markXattr(dir, match.hashes())
+		} else {
+			err = appendMarkFile(dir, match.hashes())
+		}
+		if err != nil {
+			return fmt.Errorf("marking %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+func markXattr(dir string, hashes []string) error {
+	v, err := getXattr(dir, markAttr)
+	if err != nil {
+		return err
+	}
+	have := strings.Split(v, ",")
+	if v == "" {
+		have = nil
+	}
+	n := len(have)
+	for _, h := range hashes {
+		if !slices.Contains(have, h) {
+			have = append(have, h)
+		}
+	}
+	if len(have) == n {
+		return nil
+	}
+	return setXattr(dir, markAttr, strings.Join(have, ","))
+}
+
+func appendMarkFile(dir string, hashes []string) error {
+	path := filepath.Join(dir, markFile)
+	var have []string
+	if f, err := os.Open(path); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			have = append(have, strings.TrimSpace(s.Text()))
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	var b strings.Builder
+	for _, h := range hashes {
+		if !slices.Contains(have, h) {
+			fmt.Fprintln(&b, h)
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// With --source=jellyfin, files come from the Jellyfin server at jellyfinURL,
+// whose api_key query parameter holds the API key. With --source=plex, they
+// come from the media parts in the Plex library DB at plexDB, which is
+// only read.
+var jellyfinURL string
+var plexDB string
+
+// items asked of Jellyfin at once
+const jellyfinPage = 1000
+
+// listJellyfin calls add with the dir and name of each media file the
+// Jellyfin server has.
+func listJellyfin(ctx context.Context, add func(dir, file string) error) error {
+	base, err := url.Parse(jellyfinURL)
+	if err != nil {
+		return err
+	}
+	key := base.Query().Get("api_key")
+	name := apiName(base)
+	n := 0
+	// the server may send fewer items than asked for
+	for start := 0; ; {
+		var page struct {
+			Items []struct {
+				Path string
+			}
+			TotalRecordCount int
+		}
+		err := getJSON(ctx, base, "/Items", url.Values{
+			"Recursive":  {"true"},
+			"IsFolder":   {"false"},
+			"Fields":     {"Path"},
+			"StartIndex": {fmt.Sprint(start)},
+			"Limit":      {fmt.Sprint(jellyfinPage)},
+		}, map[string]string{"X-Emby-Token": key}, &page)
+		if err != nil {
+			return err
+		}
+		for _, it := range page.Items {
+			if it.Path == "" {
+				continue
+			}
+			n++
+			dir, file := path.Split(slashed(it.Path))
+			if err := add(strings.TrimSuffix(dir, "/"), file); err != nil {
+				return err
+			}
+		}
+		start += len(page.Items)
+		if len(page.Items) == 0 || start >= page.TotalRecordCount {
+			break
+		}
+	}
+	slog.Info("listed files", "app", "Jellyfin", "url", name, "files", n)
+	return nil
+}
+
+// listPlex calls add with the dir and name of each media file in the Plex
+// library DB.
+func listPlex(ctx context.Context, add func(dir, file string) error) error {
+	params := url.Values{}
+	params.Set("mode", "ro")
+	db, err := sql.Open("sqlite3", sqliteDSN(plexDB, params))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, "select file from media_parts where file is not null and file != ''")
+	if err != nil {
+		return fmt.Errorf("%s: %v", plexDB, err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return err
+		}
+		n++
+		dir, file := path.Split(slashed(p))
+		if err := add(strings.TrimSuffix(dir, "/"), file); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	slog.Info("listed files", "app", "Plex", "db", plexDB, "files", n)
+	return nil
+}
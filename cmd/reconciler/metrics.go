@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// node_exporter textfile collector output, for runs from cron.
+var textfilePath string
+
+const lastSuccessMetric = "reconciler_last_success_timestamp_seconds"
+
+// previousLastSuccess returns the last-success timestamp recorded in an
+// existing textfile, so a failed run doesn't reset it.
+func previousLastSuccess(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == lastSuccessMetric {
+			v, _ := strconv.ParseFloat(fields[1], 64)
+			return v
+		}
+	}
+	return 0
+}
+
+func writeMetric(b *bytes.Buffer, name, typ, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
+
+// writeTextfile writes the run's metrics to path. The file is replaced
+// atomically so the collector never reads a partial file.
+func writeTextfile(path string, rep *report, end time.Time) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	var b bytes.Buffer
+	writeMetric(&b, "reconciler_last_run_timestamp_seconds", "gauge",
+		"Time the last run finished.", float64(end.Unix()))
+	lastSuccess := previousLastSuccess(path)
+	if rep.ok() {
+		lastSuccess = float64(end.Unix())
+	}
+	writeMetric(&b, lastSuccessMetric, "gauge",
+		"Time the last run without errors finished.", lastSuccess)
+	writeMetric(&b, "reconciler_last_run_duration_seconds", "gauge",
+		"Duration of the last run.", end.Sub(rep.Start).Seconds())
+	writeMetric(&b, "reconciler_last_run_matched", "gauge",
+		"Torrents matched in the DB by the last run.", float64(rep.Matched))
+	writeMetric(&b, "reconciler_last_run_unmatched", "gauge",
+		"Torrents with no match in the DB in the last run.", float64(rep.Unmatched))
+	writeMetric(&b, "reconciler_last_run_added", "gauge",
+		"Torrents added by the last run.", float64(rep.Added))
+	writeMetric(&b, "reconciler_last_run_duplicates", "gauge",
+		"Torrents the client reported as duplicates in the last run.", float64(rep.Duplicates))
+
+	b.WriteString("# HELP reconciler_last_run_rpc_errors RPC errors in the last run by class.\n")
+	b.WriteString("# TYPE reconciler_last_run_rpc_errors gauge\n")
+	for _, class := range sortedKeys(rep.RPCErrors) {
+		fmt.Fprintf(&b, "reconciler_last_run_rpc_errors{class=%q} %d\n", class, rep.RPCErrors[class])
+	}
+	b.WriteString("# HELP reconciler_last_run_queue_blocked_seconds Time pipeline stages spent blocked sending on each channel in the last run.\n")
+	b.WriteString("# TYPE reconciler_last_run_queue_blocked_seconds gauge\n")
+	for _, q := range rep.Queues {
+		fmt.Fprintf(&b, "reconciler_last_run_queue_blocked_seconds{queue=%q} %v\n", q.Name, q.BlockedSeconds)
+	}
+	b.WriteString("# HELP reconciler_last_run_errors Failures in the last run by category.\n")
+	b.WriteString("# TYPE reconciler_last_run_errors gauge\n")
+	for _, kind := range sortedKeys(rep.Errors) {
+		fmt.Fprintf(&b, "reconciler_last_run_errors{kind=%q} %d\n", kind, rep.Errors[kind])
+	}
+	writeMetric(&b, "reconciler_last_run_hook_failures", "gauge",
+		"Hook commands that failed in the last run.", float64(rep.hookFailures()))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".reconciler-metrics-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// CreateTemp creates files 0600; the collector may run as another user.
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), path)
+}
+
+// upper bounds, in seconds, of the latency histogram buckets
+var latencyBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write writes h as histogram name; labels, if any, are like `method="x"`.
+func (h *histogram) write(b *bytes.Buffer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%v\"} %d\n", name, labels, sep, le, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %v\n%s_count%s %d\n", name, labels, h.sum, name, labels, h.count)
+}
+
+// runMetrics accumulates counters across daemon passes for /metrics. The
+// nil *runMetrics of a one-shot run records nothing.
+type runMetrics struct {
+	mu sync.Mutex
+
+	passes       int
+	failedPasses int
+	lastPass     time.Time
+	lastSuccess  time.Time
+	processed    int
+	matches      int
+	adds         int
+	duplicates   int
+	// by errClass
+	rpcErrors map[string]int
+	query     *histogram
+	// by RPC method
+	rpc map[string]*histogram
+}
+
+var stats *runMetrics
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		rpcErrors: make(map[string]int),
+		query:     newHistogram(),
+		rpc:       make(map[string]*histogram),
+	}
+}
+
+// observeQuery records the latency of a DB lookup begun at start.
+func (m *runMetrics) observeQuery(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.query.observe(time.Since(start).Seconds())
+}
+
+// observeRPC records the latency of an RPC call begun at start.
+func (m *runMetrics) observeRPC(method string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.rpc[method]
+	if !ok {
+		h = newHistogram()
+		m.rpc[method] = h
+	}
+	h.observe(time.Since(start).Seconds())
+}
+
+// record adds a finished pass; rep is nil for a pass that failed to start.
+func (m *runMetrics) record(rep *report, end time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.passes++
+	m.lastPass = end
+	if rep == nil {
+		m.failedPasses++
+		return
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.ok() {
+		m.lastSuccess = end
+	}
+	m.processed += rep.Scanned
+	m.matches += rep.Matched
+	m.adds += rep.Added
+	m.duplicates += rep.Duplicates
+	for class, n := range rep.RPCErrors {
+		m.rpcErrors[class] += n
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *runMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	var b bytes.Buffer
+	writeMetric(&b, "reconciler_passes_total", "counter",
+		"Reconciliation passes run.", float64(m.passes))
+	writeMetric(&b, "reconciler_failed_passes_total", "counter",
+		"Passes that could not start, e.g. because a client was unreachable.", float64(m.failedPasses))
+	writeMetric(&b, "reconciler_last_pass_timestamp_seconds", "gauge",
+		"Time the last pass finished.", unixSeconds(m.lastPass))
+	writeMetric(&b, "reconciler_last_success_timestamp_seconds", "gauge",
+		"Time the last pass without errors finished.", unixSeconds(m.lastSuccess))
+	writeMetric(&b, "reconciler_torrents_processed_total", "counter",
+		"Distinct torrents read from the input, per pass.", float64(m.processed))
+	writeMetric(&b, "reconciler_matches_total", "counter",
+		"Torrents matched in the DB.", float64(m.matches))
+	writeMetric(&b, "reconciler_adds_total", "counter",
+		"Torrents added to a client.", float64(m.adds))
+	writeMetric(&b, "reconciler_duplicates_total", "counter",
+		"Adds the client reported as duplicates.", float64(m.duplicates))
+	b.WriteString("# HELP reconciler_rpc_errors_total RPC errors by class.\n")
+	b.WriteString("# TYPE reconciler_rpc_errors_total counter\n")
+	for _, class := range sortedKeys(m.rpcErrors) {
+		fmt.Fprintf(&b, "reconciler_rpc_errors_total{class=%q} %d\n", class, m.rpcErrors[class])
+	}
+	b.WriteString("# HELP reconciler_query_duration_seconds Latency of DB lookups.\n")
+	b.WriteString("# TYPE reconciler_query_duration_seconds histogram\n")
+	m.query.write(&b, "reconciler_query_duration_seconds", "")
+	b.WriteString("# HELP reconciler_rpc_duration_seconds Latency of Transmission RPC calls by method.\n")
+	b.WriteString("# TYPE reconciler_rpc_duration_seconds histogram\n")
+	methods := make([]string, 0, len(m.rpc))
+	for method := range m.rpc {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		m.rpc[method].write(&b, "reconciler_rpc_duration_seconds", fmt.Sprintf("method=%q", method))
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
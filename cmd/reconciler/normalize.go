@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pyrovski/reconciler/pkg/matcher"
+)
+
+// Paths from macOS are usually NFD and from elsewhere NFC, and some
+// filesystems ignore case. With --normalize=nfc or --case-insensitive, DB
+// paths and torrent file names are compared in canonical form.
+var normalize string
+var caseInsensitive bool
+
+func checkNormalize() error {
+	switch normalize {
+	case "", "nfc":
+		return nil
+	}
+	return fmt.Errorf("invalid --normalize %q", normalize)
+}
+
+// names is how the flags say paths are compared.
+func names() matcher.Names {
+	return matcher.Names{NFC: normalize == "nfc", CaseInsensitive: caseInsensitive}
+}
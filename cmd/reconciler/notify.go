@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notifications are POSTed to each --notify URL after a run, or in daemon
+// mode for each add and each pass with failures.
+var notifyURLs stringList
+var notifyFormat string
+
+// "all" or "failure"
+var notifyOn string
+
+const notifyTimeout = 10 * time.Second
+
+var notifyFormats = map[string]bool{
+	"json":    true,
+	"discord": true,
+	"slack":   true,
+	"ntfy":    true,
+}
+
+func checkNotify() error {
+	if !notifyFormats[notifyFormat] {
+		return fmt.Errorf("invalid --notify-format %q", notifyFormat)
+	}
+	if notifyOn != "all" && notifyOn != "failure" {
+		return fmt.Errorf("invalid --notify-on %q", notifyOn)
+	}
+	return nil
+}
+
+// notifier sends notifications. A nil *notifier, when no URLs are set,
+// sends nothing.
+type notifier struct {
+	urls   []string
+	format string
+	client *http.Client
+	// notify for each add rather than with the run summary
+	perAdd bool
+}
+
+var notify *notifier
+
+func newNotifier() *notifier {
+	if len(notifyURLs) == 0 {
+		return nil
+	}
+	return &notifier{
+		urls:   notifyURLs,
+		format: notifyFormat,
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+// request builds the POST of a notification to target in n.format.
+func (n *notifier) request(target, title, text string, failed bool) (*http.Request, error) {
+	var body interface{}
+	switch n.format {
+	case "ntfy":
+		// ntfy takes the message as the body and the rest as headers
+		req, err := http.NewRequest("POST", target, strings.NewReader(text))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", title)
+		if failed {
+			req.Header.Set("Priority", "high")
+			req.Header.Set("Tags", "warning")
+		}
+		return req, nil
+	case "discord":
+		body = map[string]string{"content": "**" + title + "**\n" + text}
+	case "slack":
+		body = map[string]string{"text": "*" + title + "*\n" + text}
+	default:
+		body = map[string]interface{}{"title": title, "text": text, "failed": failed}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// send posts a notification to every URL. Failures are logged; they never
+// affect the run.
+func (n *notifier) send(title, text string, failed bool) {
+	if n == nil {
+		return
+	}
+	for _, target := range n.urls {
+		req, err := n.request(target, title, text, failed)
+		if err == nil {
+			var resp *http.Response
+			resp, err = n.client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode/100 != 2 {
+					err = fmt.Errorf("%s", resp.Status)
+				}
+			}
+		}
+		if err != nil {
+			// the URL itself may be a secret
+			slog.Warn("notification failed", "host", urlHost(target), "err", err)
+		}
+	}
+}
+
+func urlHost(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// added notifies of a single add, in daemon mode.
+func (n *notifier) added(match *matchedFile, name, client string) {
+	if n == nil || !n.perAdd {
+		return
+	}
+	n.send("reconciler: added "+name, fmt.Sprintf("%s\nto %s in %s", match.tor, client, match.path), false)
+}
+
+// finished notifies of the outcome of a run or daemon pass.
+func (n *notifier) finished(rep *report) {
+	if n == nil {
+		return
+	}
+	// unmatched torrents and interruptions aren't failures of the run
+	code := rep.exitCode()
+	failed := code == exitFailed || code == exitRPC
+	if !failed && (notifyOn == "failure" || n.perAdd) {
+		return
+	}
+	title := "reconciler: run finished"
+	if failed {
+		title = "reconciler: run finished with failures"
+	}
+	n.send(title, rep.summary(), failed)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// A torrent that's mostly archives, like the .rar sets of older releases,
+// only has what's on disk once extracted, so its files can't match. Unless
+// --match-packed, such torrents aren't matched but listed as packed, in the
+// report and the --packed-file, instead of being counted as unmatched.
+var matchPacked bool
+var packedFilePath string
+
+// archive parts: .rar with .r00 or .s00 volumes, .zip with .z01, .7z and
+// numbered splits
+var archiveName = regexp.MustCompile(`(?i)\.(rar|r\d\d|s\d\d|zip|z\d\d|7z|\d{3})$`)
+
+// share of a torrent's bytes in archives over which it's packed
+const packedShare = 0.5
+
+type packedTorrent struct {
+	Torrent string `json:"torrent"`
+	Name    string `json:"name"`
+	// files that are archive parts, of all the torrent's files
+	Archives int `json:"archives"`
+	Files    int `json:"files"`
+}
+
+// packedTorrentOf returns the torrent tor, described by ti, as packed, or
+// nil if it isn't.
+func packedTorrentOf(tor string, ti *metainfo.Info) *packedTorrent {
+	if matchPacked || ti.Size == 0 {
+		return nil
+	}
+	p := &packedTorrent{Torrent: tor, Name: ti.Name}
+	var bytes int64
+	for _, f := range ti.Files {
+		if f.Pad {
+			continue
+		}
+		p.Files++
+		if archiveName.MatchString(f.Path) {
+			p.Archives++
+			bytes += f.Length
+		}
+	}
+	if float64(bytes) <= packedShare*float64(ti.Size) {
+		return nil
+	}
+	return p
+}
+
+func (r *report) packed(p *packedTorrent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Packed = append(r.Packed, p)
+}
+
+// writePackedFile writes the packed torrents to path, a line each of the
+// torrent, its name, and how many of its files are archive parts.
+func (r *report) writePackedFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b strings.Builder
+	for _, p := range r.Packed {
+		fmt.Fprintf(&b, "%s\t%s\t%d/%d archives\n", p.Torrent, p.Name, p.Archives, p.Files)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
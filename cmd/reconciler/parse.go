@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// Torrents are parsed, and fetched if they're URLs, by parseWorkers at
+// once ahead of matching, which otherwise waits on each in turn; over NFS
+// that's most of a run. They're passed on in the order they came.
+var parseWorkers int
+
+// parseAhead passes on the torrents from i with their metainfo parsed.
+func parseAhead(ctx context.Context, i <-chan *torFile) <-chan *torFile {
+	if parseWorkers <= 1 {
+		return i
+	}
+	type job struct {
+		tf   *torFile
+		done chan struct{}
+	}
+	jobs := make(chan job, parseWorkers)
+	go func() {
+		defer close(jobs)
+		sem := make(chan struct{}, parseWorkers)
+		for tf := range i {
+			j := job{tf, make(chan struct{})}
+			sem <- struct{}{}
+			go func() {
+				defer close(j.done)
+				defer func() { <-sem }()
+				if ctx.Err() == nil {
+					j.tf.meta(ctx)
+				}
+			}()
+			jobs <- j
+		}
+	}()
+	o := make(chan *torFile)
+	go func() {
+		defer close(o)
+		for j := range jobs {
+			<-j.done
+			o <- j.tf
+		}
+	}()
+	return o
+}
+
+// meta returns what tf's torrent says about itself, parsing it the first
+// time.
+func (tf *torFile) meta(ctx context.Context) (*metainfo.Info, error) {
+	if !tf.parsed {
+		tf.ti, tf.metaErr = torrentMeta(ctx, tf.tor)
+		tf.parsed = true
+	}
+	return tf.ti, tf.metaErr
+}
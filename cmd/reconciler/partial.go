@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// How to add torrents whose files are only partly in the DB: "" adds them
+// as-is, letting the client download what's missing; "unwanted" marks the
+// missing files unwanted so nothing is downloaded.
+var partial string
+
+// With checkSpace, a torrent is only added if the files it will download
+// fit in the client's free space on the download dir, less minFree.
+var checkSpace bool
+var minFree int64
+
+const ExistsQuery = "select 1 from {files} where {path} = ? and {file} = ? limit 1"
+
+// ExistsLikeQuery is ExistsQuery for canonical matching, whose candidates
+// are compared with sameName.
+const ExistsLikeQuery = "select {path}, {file} from {files} where {path} like ? and {file} like ?"
+
+func checkPartial() error {
+	switch partial {
+	case "", "unwanted":
+		return nil
+	}
+	return fmt.Errorf("invalid --partial %q", partial)
+}
+
+// missingFiles returns the indices of the files in paths that the DB doesn't
+// have under dir. Empty paths are skipped.
+func missingFiles(ctx context.Context, stmt *sql.Stmt, dir string, paths []string) ([]int, error) {
+	var missing []int
+	for i, p := range paths {
+		if p == "" {
+			// padding
+			continue
+		}
+		full := strings.TrimSuffix(dir, "/") + "/" + p
+		slash := strings.LastIndex(full, "/")
+		var found bool
+		err := withRetry(ctx, "query", transientDB, func() (err error) {
+			found, err = exists(stmt, full[:slash], full[slash+1:])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missing = append(missing, i)
+		}
+	}
+	return missing, nil
+}
+
+// exists reports whether the DB has file in dir, using the statement
+// prepared from ExistsQuery or, in canonical mode, ExistsLikeQuery.
+func exists(stmt *sql.Stmt, dir, file string) (bool, error) {
+	ctx, cancel := dbContext()
+	defer cancel()
+	if !names().Canonical() {
+		var one int
+		err := stmt.QueryRowContext(ctx, dir, file).Scan(&one)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, names().LikePattern(dir), names().LikePattern(file))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p, f string
+		if err := rows.Scan(&p, &f); err != nil {
+			return false, err
+		}
+		if names().Same(p, dir) && names().Same(f, file) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// checkFreeSpace fails if match's download wouldn't fit on its download
+// dir as the client sees it.
+func checkFreeSpace(ctx context.Context, cl *endpoint, match *matchedFile) error {
+	if match.download == 0 {
+		return nil
+	}
+	var free int64
+	err := withRetry(ctx, "free-space", client.Transient, func() (err error) {
+		free, err = cl.rpc.FreeSpace(ctx, clientPath(match.path))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if match.download > free-minFree {
+		return fmt.Errorf("needs %s in %s, which has %s free", humanSize(match.download), match.path, humanSize(free))
+	}
+	return nil
+}
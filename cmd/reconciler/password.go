@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// A client with no password from -p or the config file can get one from
+// the OS keyring, with --keyring, or from a prompt on the terminal, with
+// --ask-pass. With both, the keyring is tried first and a password typed
+// at the prompt is stored there for later runs. Keyring entries are under
+// keyringService, for the account user@url.
+var askPass bool
+var useKeyring bool
+
+const keyringService = "reconciler"
+
+// errNoKey is returned by keyringGet when the keyring has no such entry.
+var errNoKey = errors.New("not in the keyring")
+
+// passwords found so far, by account, so daemon passes don't ask again
+var passwords = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// clientPassword returns the password for user on the client at url: pw
+// if it's set, and otherwise as described above.
+func clientPassword(url, user, pw string) (string, error) {
+	if pw != "" || !askPass && !useKeyring {
+		return pw, nil
+	}
+	account := user + "@" + url
+	passwords.Lock()
+	defer passwords.Unlock()
+	if pw, ok := passwords.m[account]; ok {
+		return pw, nil
+	}
+	if useKeyring {
+		pw, err := keyringGet(keyringService, account)
+		if err == nil {
+			passwords.m[account] = pw
+			return pw, nil
+		}
+		if !errors.Is(err, errNoKey) || !askPass {
+			return "", fmt.Errorf("keyring: %s: %w", account, err)
+		}
+	}
+	pw, err := promptPassword(account)
+	if err != nil {
+		return "", err
+	}
+	if useKeyring {
+		if err := keyringSet(keyringService, account, pw); err != nil {
+			return "", fmt.Errorf("keyring: %s: %w", account, err)
+		}
+	}
+	passwords.m[account] = pw
+	return pw, nil
+}
+
+// promptPassword reads a password for account from the terminal without
+// echoing it.
+func promptPassword(account string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("--ask-pass needs a terminal: %v", err)
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "Password for %s: ", account)
+	pw, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+	return string(pw), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Added torrents get --bandwidth-priority, and files whose paths in the
+// torrent match a --high-priority or --low-priority regex get that file
+// priority, high winning over low. With --low-priority-extras, samples,
+// .nfo files and the like are low priority too, so that the files a
+// partly present torrent still needs come first.
+var bandwidthPriority string
+var highPriority, lowPriority regexpList
+var lowPriorityExtras bool
+
+// extras matches files that come along with a release's main files.
+var extras = regexp.MustCompile(`(?i)(^|/)(samples?|extras?|featurettes?|screens?|proofs?)/|(^|/|[._ -])sample([._ -][^/]*)?\.\w+$|\.(nfo|txt|sfv|md5|jpe?g|png|url)$`)
+
+func checkPriority() error {
+	if _, ok := bandwidthPriorities[bandwidthPriority]; !ok {
+		return fmt.Errorf("--bandwidth-priority must be low, normal or high")
+	}
+	return nil
+}
+
+// torrent-add's bandwidthPriority by --bandwidth-priority
+var bandwidthPriorities = map[string]int{"low": -1, "normal": 0, "high": 1}
+
+// filePriorities returns the indices of the files at paths, as returned by
+// Paths, to make high and low priority.
+func filePriorities(paths []string) (high, low []int) {
+	if len(highPriority) == 0 && len(lowPriority) == 0 && !lowPriorityExtras {
+		return nil, nil
+	}
+	for i, p := range paths {
+		switch {
+		case p == "":
+		case highPriority.matchAny([]string{p}):
+			high = append(high, i)
+		case lowPriority.matchAny([]string{p}) || lowPriorityExtras && extras.MatchString(p):
+			low = append(low, i)
+		}
+	}
+	return high, low
+}
+
+// remotePriorityArgs returns transmission-remote options setting match's
+// priorities.
+func remotePriorityArgs(match *matchedFile) string {
+	var s string
+	switch match.bandwidthPriority {
+	case -1:
+		s += " --bandwidth-low"
+	case 1:
+		s += " --bandwidth-high"
+	}
+	if len(match.priorityHigh) > 0 {
+		s += " --priority-high " + joinInts(match.priorityHigh)
+	}
+	if len(match.priorityLow) > 0 {
+		s += " --priority-low " + joinInts(match.priorityLow)
+	}
+	return s
+}
+
+func joinInts(l []int) string {
+	s := make([]string, len(l))
+	for i, n := range l {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// As from a watch folder, with --processed-dir each .torrent file is moved
+// there once its torrent is added or found in a client, and with
+// --failed-dir once it's unmatched or doesn't parse, so that later runs
+// don't read it again. A torrent that failed for a reason that may pass,
+// or was held back for review, stays. Magnets and URLs have no file to
+// move.
+var processedDir, failedDir string
+
+// moveOnly, if set, limits the .torrent files moved to those it accepts.
+var moveOnly func(tor string) bool
+
+// moveTorrent moves the .torrent file tor into dir, if dir is set, under
+// a new name if dir has one by that name already.
+func moveTorrent(tor, dir string) {
+	if dir == "" || isMagnet(tor) || isURL(tor) || moveOnly != nil && !moveOnly(tor) {
+		return
+	}
+	to, err := moveInto(tor, dir)
+	if err != nil {
+		slog.Error("moving torrent file", "torrent", tor, "dir", dir, "err", err)
+		return
+	}
+	slog.Debug("moved torrent file", "torrent", tor, "to", to)
+}
+
+func moveInto(name, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	to := filepath.Join(dir, base)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(to); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		to = filepath.Join(dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), n, ext))
+	}
+	err := os.Rename(name, to)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyFile(name, to)
+		if err == nil {
+			err = os.Remove(name)
+		}
+	}
+	return to, err
+}
+
+// copyFile copies the file at from to the new file to.
+func copyFile(from, to string) error {
+	r, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(to)
+		return err
+	}
+	return w.Close()
+}
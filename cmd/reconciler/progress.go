@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// On a terminal, a run shows a status line instead of logging each torrent:
+// the torrent being matched, the counts so far and, once all the input has
+// been read, an ETA. Warnings and errors still print above it, in color,
+// and the summary prints at the end. --progress=never keeps the plain log,
+// as when stderr isn't a terminal, and an explicit --log-level brings back
+// the lines below warn. NO_COLOR turns the color off.
+var progressMode string
+
+// progress is the status line of the run, or nil without one.
+var progress *progressLine
+
+// how often the status line is redrawn
+const progressInterval = 200 * time.Millisecond
+
+var colorEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+type progressLine struct {
+	mu    sync.Mutex
+	out   *os.File
+	color bool
+	start time.Time
+	rep   *report
+	// the records of each torrent read that the matcher hasn't finished,
+	// the torrents with none left, and the latest record taken up, and
+	// whether it's still being matched
+	pending  map[string]int
+	finished int
+	current  string
+	busy     bool
+	// all the input was read
+	inputDone bool
+	// the counts drawn, copied from rep by the ticker: reading rep while
+	// logging could deadlock with a log call made under rep.mu
+	counts progressCounts
+	shown  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type progressCounts struct {
+	matched, added, failed int
+}
+
+func checkProgress() error {
+	switch progressMode {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("--progress must be auto, always or never")
+}
+
+// newProgress returns the status line for a run, or nil if there's to be
+// none.
+func newProgress() *progressLine {
+	switch {
+	case progressMode == "never" || review || resolve == "interactive":
+		// the prompts would be drawn over
+		return nil
+	case progressMode == "auto" && !term.IsTerminal(int(os.Stderr.Fd())):
+		return nil
+	}
+	return &progressLine{
+		out:     os.Stderr,
+		color:   os.Getenv("NO_COLOR") == "",
+		pending: make(map[string]int),
+	}
+}
+
+// begin starts drawing the status line of the pass reported in rep.
+func (p *progressLine) begin(rep *report) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.rep, p.start = rep, time.Now()
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	p.mu.Unlock()
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				c := p.read()
+				p.mu.Lock()
+				p.counts = c
+				p.draw()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (p *progressLine) read() progressCounts {
+	r := p.rep
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := progressCounts{matched: r.Matched, added: r.Added}
+	for _, n := range r.Errors {
+		c.failed += n
+	}
+	return c
+}
+
+// queued records that a record of tor was read.
+func (p *progressLine) queued(tor string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[tor] == 0 && p.started(tor) {
+		// read again after it was finished
+		p.finished--
+	}
+	p.pending[tor]++
+}
+
+// started reports whether tor has been read. p.mu must be held.
+func (p *progressLine) started(tor string) bool {
+	_, ok := p.pending[tor]
+	return ok
+}
+
+// working records that the matcher has taken up a record of tor, and so
+// finished the one before.
+func (p *progressLine) working(tor string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finish()
+	p.current, p.busy = tor, true
+}
+
+// idle records that the matcher has finished the last record it took up.
+func (p *progressLine) idle() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finish()
+}
+
+// finish records that the matcher is done with the current record. p.mu
+// must be held.
+func (p *progressLine) finish() {
+	if !p.busy || p.pending[p.current] == 0 {
+		return
+	}
+	p.busy = false
+	p.pending[p.current]--
+	if p.pending[p.current] == 0 {
+		p.finished++
+	}
+}
+
+// inputRead records that all the input has been read, so the number of
+// torrents is known.
+func (p *progressLine) inputRead() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inputDone = true
+}
+
+// end takes the status line down and prints rep's summary in its place.
+func (p *progressLine) end(rep *report) {
+	if p == nil || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.rep = nil
+	p.pending, p.finished, p.current, p.busy = make(map[string]int), 0, "", false
+	fmt.Fprintln(p.out, rep.summary())
+}
+
+// Write writes log lines above the status line, warnings in yellow and
+// errors in red.
+func (p *progressLine) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	color := ""
+	switch {
+	case !p.color:
+	case bytes.Contains(b, []byte("level=ERROR")) || bytes.Contains(b, []byte(`"level":"ERROR"`)):
+		color = "\x1b[31m"
+	case bytes.Contains(b, []byte("level=WARN")) || bytes.Contains(b, []byte(`"level":"WARN"`)):
+		color = "\x1b[33m"
+	}
+	var err error
+	if color != "" {
+		_, err = fmt.Fprintf(p.out, "%s%s\x1b[0m\n", color, bytes.TrimSuffix(b, []byte("\n")))
+	} else {
+		_, err = p.out.Write(b)
+	}
+	p.draw()
+	return len(b), err
+}
+
+// clear erases the status line. p.mu must be held.
+func (p *progressLine) clear() {
+	if p.shown {
+		io.WriteString(p.out, "\r\x1b[K")
+		p.shown = false
+	}
+}
+
+// draw redraws the status line. p.mu must be held.
+func (p *progressLine) draw() {
+	if p.rep == nil {
+		return
+	}
+	c := p.counts
+	done, total := p.finished, len(p.pending)
+	var b strings.Builder
+	if p.inputDone && total > 0 {
+		fmt.Fprintf(&b, "[%d/%d %d%%]", done, total, 100*done/total)
+	} else {
+		fmt.Fprintf(&b, "[%d/%d+]", done, total)
+	}
+	fmt.Fprintf(&b, " %s %s", p.paint("\x1b[32m", "matched", c.matched), p.paint("\x1b[32m", "added", c.added))
+	if c.failed > 0 {
+		fmt.Fprintf(&b, " %s", p.paint("\x1b[31m", "failed", c.failed))
+	}
+	if p.inputDone && done > 0 && done < total {
+		eta := time.Since(p.start) / time.Duration(done) * time.Duration(total-done)
+		fmt.Fprintf(&b, " ETA %s", eta.Round(time.Second))
+	}
+	line := b.String()
+	width, _, err := term.GetSize(int(p.out.Fd()))
+	if err != nil {
+		width = 80
+	}
+	// the color escapes take no room
+	room := width - 1 - len(colorEscape.ReplaceAllString(line, ""))
+	if p.current != "" && room > 4 {
+		// the end of a path is the part that tells torrents apart
+		cur := []rune(p.current)
+		if len(cur) > room-1 {
+			cur = append([]rune("…"), cur[len(cur)-(room-2):]...)
+		}
+		line += " " + string(cur)
+	}
+	p.clear()
+	io.WriteString(p.out, line)
+	p.shown = true
+}
+
+// paint returns name and n, n in the color when there's color.
+func (p *progressLine) paint(color, name string, n int) string {
+	if !p.color {
+		return fmt.Sprintf("%s %d", name, n)
+	}
+	return fmt.Sprintf("%s %s%d\x1b[0m", name, color, n)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// Torrents can also be skipped by their own properties before the DB is
+// queried: a name matching an --exclude-name, a total size outside
+// --min-size and --max-size, a creation date outside --created-after and
+// --created-before, or by --private, "only" or "skip", which --private-only
+// and --public-only are short for. A torrent that doesn't give its creation
+// date never passes a date bound. Magnets are only known by name.
+var excludeName regexpList
+var minSize, maxSize byteSize
+var createdAfter, createdBefore dateFlag
+var private string
+
+// With --pause-public, torrents that aren't private, magnets among them,
+// are added paused, so that a box seeding for private trackers doesn't
+// join public swarms unless they're started by hand.
+var pausePublic bool
+
+// privateFlag returns the func of a boolean flag setting --private to v.
+func privateFlag(v string) func(string) error {
+	return func(s string) error {
+		on, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		if on {
+			private = v
+		}
+		return nil
+	}
+}
+
+// byteSize is a flag.Value of a number of bytes, with an optional K, M, G
+// or T suffix for powers of 1024.
+type byteSize int64
+
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+func (b *byteSize) Set(s string) error {
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	mult := 1.0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			mult = math.Pow(1024, float64(i+1))
+			num = num[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// dateFlag is a flag.Value of a date, YYYY-MM-DD in UTC, or an RFC 3339
+// time.
+type dateFlag struct{ time.Time }
+
+func (d *dateFlag) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(time.RFC3339)
+}
+
+func (d *dateFlag) Set(s string) error {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("invalid date %q: want YYYY-MM-DD or RFC 3339", s)
+		}
+	}
+	d.Time = t
+	return nil
+}
+
+func checkProps() error {
+	switch private {
+	case "", "only", "skip":
+	default:
+		return fmt.Errorf("--private must be only or skip")
+	}
+	if maxSize > 0 && minSize > maxSize {
+		return fmt.Errorf("--min-size is over --max-size")
+	}
+	return nil
+}
+
+func propsFiltered() bool {
+	return len(excludeName) > 0 || minSize > 0 || maxSize > 0 ||
+		!createdAfter.IsZero() || !createdBefore.IsZero() || private != ""
+}
+
+// propsExcluded returns what about ti excludes it, or "" if nothing does.
+func propsExcluded(ti *metainfo.Info) string {
+	if excludeName.matchAny([]string{ti.Name}) {
+		return "name"
+	}
+	if len(ti.Files) == 0 {
+		// a magnet; nothing more is known
+		return ""
+	}
+	if minSize > 0 && ti.Size < int64(minSize) || maxSize > 0 && ti.Size > int64(maxSize) {
+		return "size"
+	}
+	created := time.Unix(ti.CreationDate, 0)
+	if !createdAfter.IsZero() && (ti.CreationDate == 0 || created.Before(createdAfter.Time)) ||
+		!createdBefore.IsZero() && (ti.CreationDate == 0 || !created.Before(createdBefore.Time)) {
+		return "creation date"
+	}
+	if private == "only" && !ti.Private || private == "skip" && ti.Private {
+		return "private"
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Buffer sizes of the pipeline's channels: scanned torrents waiting to be
+// matched, and matches waiting to be added. A buffer lets a stage run ahead
+// of a slower one downstream rather than wait on it item by item.
+var scanBuffer, matchBuffer int
+
+// sends blocked at least this long are logged at debug level
+const slowSend = time.Second
+
+// queueStats instruments a pipeline channel. The time its senders spent
+// blocked is time the stage reading it held the pipeline up.
+type queueStats struct {
+	mu sync.Mutex
+
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+	Sends    int    `json:"sends"`
+	// most items buffered at once
+	MaxQueued      int     `json:"max_queued"`
+	BlockedSeconds float64 `json:"blocked_seconds"`
+}
+
+// the current pass's channels: scanned torrents, and matches
+var scanQueue, matchQueue *queueStats
+
+// sendTor sends tf on c, counting it on the progress line.
+func (q *queueStats) sendTor(c chan<- *torFile, tf *torFile) {
+	progress.queued(tf.tor)
+	start := time.Now()
+	c <- tf
+	q.sent(start, len(c))
+}
+
+// sendMatch sends match on c.
+func (q *queueStats) sendMatch(c chan<- *matchedFile, match *matchedFile) {
+	start := time.Now()
+	c <- match
+	q.sent(start, len(c))
+}
+
+// sent records a send begun at start, after which queued items were
+// buffered.
+func (q *queueStats) sent(start time.Time, queued int) {
+	markProgress()
+	d := time.Since(start)
+	if d >= slowSend {
+		slog.Debug("pipeline blocked", "queue", q.Name, "for", d)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Sends++
+	q.BlockedSeconds += d.Seconds()
+	if queued > q.MaxQueued {
+		q.MaxQueued = queued
+	}
+}
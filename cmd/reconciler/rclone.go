@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// With --source=rclone, files come from an `rclone lsjson -R` dump, or
+// from listing rcloneFS through the rclone RC API at rcloneRC, whose URL
+// may carry the --rc-user credentials. The one --root is where the
+// listing's top is mounted, as the client sees it.
+var rcloneLsjson string
+var rcloneRC string
+var rcloneFS string
+
+func checkRclone() error {
+	if len(roots) != 1 {
+		return fmt.Errorf("--source=rclone needs one --root, where the remote is mounted")
+	}
+	if (rcloneLsjson == "") == (rcloneRC == "") {
+		return fmt.Errorf("--source=rclone needs one of --rclone-lsjson and --rclone-rc")
+	}
+	if rcloneRC != "" && rcloneFS == "" {
+		return fmt.Errorf("--rclone-rc needs --rclone-fs, the remote to list")
+	}
+	return nil
+}
+
+// lsjsonEntry is an object of rclone's lsjson output.
+type lsjsonEntry struct {
+	// relative to the listed remote, slash-separated
+	Path  string
+	Name  string
+	Size  int64
+	IsDir bool
+}
+
+// listRclone calls add with the dir under mount and name of each file in the
+// rclone listing.
+func listRclone(ctx context.Context, mount string, add func(dir, file string) error) error {
+	addEntry := func(e *lsjsonEntry) error {
+		if e.IsDir {
+			return nil
+		}
+		dir := strings.TrimSuffix(mount, "/")
+		if d := path.Dir(e.Path); d != "." {
+			dir += "/" + d
+		}
+		return add(dir, e.Name)
+	}
+	if rcloneLsjson != "" {
+		in, err := openInput(rcloneLsjson)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if err := decodeLsjson(json.NewDecoder(in), addEntry); err != nil {
+			return fmt.Errorf("%s: %v", rcloneLsjson, err)
+		}
+		return nil
+	}
+	return listRcloneRC(ctx, addEntry)
+}
+
+// decodeLsjson calls fn with each entry of the lsjson array dec is at.
+func decodeLsjson(dec *json.Decoder, fn func(*lsjsonEntry) error) error {
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('[') {
+		return fmt.Errorf("not a JSON array")
+	}
+	for dec.More() {
+		var e lsjsonEntry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// listRcloneRC lists rcloneFS with the RC API's operations/list, whose
+// reply holds the entries in its "list" array.
+func listRcloneRC(ctx context.Context, fn func(*lsjsonEntry) error) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"fs":     rcloneFS,
+		"remote": "",
+		"opt":    map[string]interface{}{"recurse": true, "filesOnly": true, "noModTime": true, "noMimeType": true},
+	})
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(rcloneRC, "/") + "/operations/list"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("rclone rc: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	dec := json.NewDecoder(resp.Body)
+	if t, err := dec.Token(); err != nil {
+		return fmt.Errorf("rclone rc: %v", err)
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("rclone rc: reply not a JSON object")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("rclone rc: %v", err)
+		}
+		if key == "list" {
+			if err := decodeLsjson(dec, fn); err != nil {
+				return fmt.Errorf("rclone rc: %v", err)
+			}
+			continue
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return fmt.Errorf("rclone rc: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1612 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+	"github.com/pyrovski/reconciler/pkg/matcher"
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+var dbFile string
+var dbTimeout time.Duration
+
+var server string // host:port or URL
+var rpcPath string
+var username string
+var password string
+var ssl bool
+
+// limits on each RPC call and on the whole run, or each daemon pass
+var rpcTimeout time.Duration
+var deadline time.Duration
+var reportPath string
+var logFile string
+
+// stringList is a flag.Value collecting each occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+// File format: torrent filename <tab> contained filename
+//
+// With --input-format, or going by a .csv, .jsonl or .ndjson extension,
+// input may instead be CSV records of torrent, contained filename and
+// optional size, or JSON Lines objects with torrent, file and size fields.
+// Either can carry names with tabs. A size, in bytes, picks out the file
+// among those in the torrent with the same name.
+//
+// A torrent may be listed with several contained files, in further fields
+// of a tab-separated line, as further file,size pairs of a CSV record, as a
+// JSON "files" list of names or {"file", "size"} objects, or on
+// consecutive lines. The first file finds the candidate dirs, and those
+// with the most of the others in place are kept. The sizes given for the
+// others are checked by --stat-sizes.
+//
+// The torrent may instead be a magnet link, and the contained filename may
+// then be left out to match by the link's display name. It may also be an
+// http(s) URL to download the .torrent from. A file named "-" is read from
+// stdin, and gzip or zstd input is decompressed.
+//
+// Arguments may also be glob patterns, directories to search for input
+// files, or .torrent files, each matched by its largest file.
+
+// TODO: first restrict by basename; this should have an index.
+const LookupQuery = "select {path} || '/' || {file} from {files} where {path} || '/' || {file} like ?"
+
+type torFile struct {
+	tor  string
+	file string
+	// file is a magnet's display name rather than a contained file
+	byName bool
+	// the contained file's size, or 0 if unknown
+	size int64
+	// further contained files listed for the torrent, and the sizes given
+	// for them
+	others []string
+	sizes  map[string]int64
+	// with --lookup-batch, the DB files ending in file, looked up ahead of
+	// matching; nil if they weren't
+	found []string
+	// the torrent's metainfo, once meta has parsed it
+	parsed  bool
+	ti      *metainfo.Info
+	metaErr error
+}
+
+type matchedFile struct {
+	tor      string
+	infoHash string
+	// full v2 info hash, for v2 and hybrid torrents
+	infoHashV2 string
+	path       string
+	// the contained file that matched; empty for a magnet matched by name
+	file string
+	// indices of torrent files to mark unwanted, for --partial=unwanted
+	unwanted []int
+	labels   []string
+	// the torrent's private flag
+	private bool
+	// the dirs the torrent's files were found under, if more than one
+	split []string
+	// for --prioritize mtime: the .torrent file's modification time, or
+	// the torrent's creation date
+	fetched time.Time
+	// bandwidth group and seeding limits to set once added, if any
+	seed *client.SeedOptions
+	// torrent-add's bandwidthPriority, and the indices of files to make
+	// high and low priority
+	bandwidthPriority         int
+	priorityHigh, priorityLow []int
+	// where the DB says the data is; path may differ by rule
+	dataDir string
+	// the matched file in dataDir, as the DB has it
+	dataFile string
+	// client to add to, if a rule chose one
+	client string
+	// the host of the torrent's first tracker, if known
+	tracker string
+	// total size of the torrent's files
+	size int64
+	// bytes the client will download: the wanted files not in the DB, when
+	// known
+	download int64
+	// how the match was made, for review
+	confidence string
+	// for a fuzzy match, renames making the torrent's names the DB's
+	renames []rename
+	// with --link-dir, the link tree to make at path before adding
+	links []link
+	// the retry queue entry the match was made from, if any
+	queued *queueEntry
+	// the candidate dirs the match's dir was resolved from
+	candidates []string
+}
+
+// paused reports whether m is to stay paused once added.
+func (m *matchedFile) paused() bool {
+	return pausePublic && !m.private
+}
+
+// hashes returns the hashes a client may report for the torrent: a v2-only
+// torrent may show up by its full or truncated v2 hash, and a hybrid by
+// either version's.
+func (m *matchedFile) hashes() []string {
+	hs := []string{m.infoHash}
+	if m.infoHashV2 != "" {
+		hs = append(hs, m.infoHashV2)
+		if short := m.infoHashV2[:40]; short != m.infoHash {
+			hs = append(hs, short)
+		}
+	}
+	return hs
+}
+
+// catalogFiles is the catalog as the matcher's file source.
+type catalogFiles struct {
+	stmt *sql.Stmt
+}
+
+func (c catalogFiles) Lookup(ctx context.Context, file string) ([]string, error) {
+	return lookup(c.stmt, file)
+}
+
+// lookup returns the full paths of DB files ending in file.
+func lookup(stmt *sql.Stmt, file string) ([]string, error) {
+	defer stats.observeQuery(time.Now())
+	ctx, cancel := dbContext()
+	defer cancel()
+	rows, err := stmt.QueryContext(ctx, "%"+names().LikePattern(file))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []string
+	for rows.Next() {
+		var fullpath string
+		if err := rows.Scan(&fullpath); err != nil {
+			return nil, err
+		}
+		results = append(results, fullpath)
+	}
+	return results, rows.Err()
+}
+
+// confirmListed returns the candidate dirs for tf under which the most of
+// its other listed files are in the DB, and how many are.
+func confirmListed(ctx context.Context, existsStmt *sql.Stmt, ti *metainfo.Info, tf *torFile, dirs []string) ([]string, int, error) {
+	var best []string
+	most := -1
+	for _, dir := range dirs {
+		found := 0
+		for _, o := range tf.others {
+			full := names().ContainedPath(ti, dir, o, 0)
+			slash := strings.LastIndex(full, "/")
+			var ok bool
+			err := withRetry(ctx, "query", transientDB, func() (err error) {
+				ok, err = exists(existsStmt, full[:slash], full[slash+1:])
+				return err
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				found++
+			}
+		}
+		slog.Debug("listed files", "torrent", tf.tor, "dir", dir, "found", found, "listed", len(tf.others))
+		if found > most {
+			best, most = nil, found
+		}
+		if found == most {
+			best = append(best, dir)
+		}
+	}
+	return best, most, nil
+}
+
+// newMatch builds the match of tf with its data in dir, once renames are
+// applied to the torrent.
+func newMatch(ctx context.Context, tf *torFile, dir string, renames []rename, stmt, existsStmt *sql.Stmt) (*matchedFile, error) {
+	ti, err := tf.meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unwanted []int
+	var download int64
+	var linkRoot string
+	var links []link
+	var split []string
+	adapted := false
+	// a magnet has no file list to check
+	if (partial == "unwanted" || checkSpace || linkDir != "" || reportSplit || renameToDisk) && len(ti.Files) > 0 {
+		paths := ti.Paths()
+		for i, p := range paths {
+			if p != "" {
+				paths[i] = renamed(p, renames)
+			}
+		}
+		missing, err := missingFiles(ctx, existsStmt, dir, paths)
+		if err != nil {
+			return nil, err
+		}
+		// a fuzzy match's renames already line its files up
+		if len(missing) > 0 && renameToDisk && len(renames) == 0 {
+			newDir, rs, ok, err := planRenames(ctx, stmt, dir, ti, missing)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				slog.Info("renaming to names on disk", "torrent", tf.tor, "dir", newDir, "renames", len(rs))
+				dir, renames, missing, adapted = newDir, rs, nil, true
+			}
+		}
+		if len(renames) == 0 && (len(missing) > 0 && (linkDir != "" || reportSplit) || downloadDirTemplate != "" && linkDir != "") {
+			root, ls, dirs, ok, err := planLinks(ctx, stmt, dir, ti, missing)
+			if err != nil {
+				return nil, err
+			}
+			if ok && len(dirs) > 1 {
+				slog.Info("split across dirs", "torrent", tf.tor, "dirs", strings.Join(dirs, ", "))
+				split = dirs
+			}
+			if ok && linkDir != "" {
+				slog.Info("linking files into place", "torrent", tf.tor, "dir", root, "elsewhere", len(missing), "files", len(ti.Files))
+				linkRoot, links, missing = root, ls, nil
+			}
+		}
+		if len(missing) > 0 {
+			slog.Info("partial match", "torrent", tf.tor, "missing", len(missing), "files", len(ti.Files))
+		}
+		if partial == "unwanted" {
+			unwanted = missing
+		} else {
+			for _, i := range missing {
+				download += ti.Files[i].Length
+			}
+		}
+	}
+	match := &matchedFile{
+		tor:        tf.tor,
+		infoHash:   ti.InfoHash,
+		infoHashV2: ti.InfoHashV2,
+		path:       dir,
+		dataDir:    dir,
+		dataFile:   dataFile(ti, tf, dir, renames),
+		file:       tf.file,
+		unwanted:   unwanted,
+		download:   download,
+		size:       ti.Size,
+		confidence: "exact",
+		renames:    renames,
+		tracker:    trackerHost(ti.Announce),
+		private:    ti.Private,
+		split:      split,
+	}
+	if fi, err := os.Stat(tf.tor); err == nil && !isMagnet(tf.tor) {
+		match.fetched = fi.ModTime()
+	} else if ti.CreationDate > 0 {
+		match.fetched = time.Unix(ti.CreationDate, 0)
+	}
+	match.bandwidthPriority = bandwidthPriorities[bandwidthPriority]
+	match.priorityHigh, match.priorityLow = filePriorities(ti.Paths())
+	if len(unwanted) > 0 {
+		n := len(ti.Files)
+		match.confidence = fmt.Sprintf("%d/%d files", n-len(unwanted), n)
+	}
+	if adapted {
+		match.confidence = "renamed"
+	}
+	if tf.byName {
+		match.file = ""
+		match.confidence = "display name"
+	}
+	seed := seedFlags
+	if r := cfg.ruleFor(ti.Announce, ti.Size); r != nil {
+		if r.DownloadDir != "" {
+			match.path = cfg.expand(r.DownloadDir, ti.Announce)
+			slog.Debug("rule sets download dir", "torrent", tf.tor, "rule", r.Announce, "dir", match.path)
+		}
+		for _, l := range r.Labels {
+			match.labels = append(match.labels, cfg.expand(l, ti.Announce))
+		}
+		match.client = r.Client
+		seed = r.seedPolicy.over(seedFlags)
+	}
+	match.seed = seed.options()
+	if len(links) > 0 {
+		// the client reads the data through the links
+		match.path = linkRoot
+		match.links = links
+		match.confidence = "linked"
+	}
+	return match, nil
+}
+
+// Each pipeline stage stops working when ctx is done, but keeps draining its
+// input so that upstream stages never block.
+
+// Failures are sent to errc, and the stage moves on to the next torrent.
+
+func matchDBFiles(ctx context.Context, db *sql.DB, state *stateDB, i chan *torFile, o chan *matchedFile, rep *report, errc chan<- *pipelineError, wg *sync.WaitGroup) {
+	defer wg.Done()
+	stmt, err := db.Prepare(lookupQuery())
+	var existsStmt, nameStmt *sql.Stmt
+	if err == nil {
+		if names().Canonical() {
+			existsStmt, err = db.Prepare(catalogSQL(ExistsLikeQuery))
+		} else {
+			existsStmt, err = db.Prepare(catalogSQL(ExistsQuery))
+		}
+	}
+	if err == nil {
+		nameStmt, err = db.Prepare(catalogSQL(NameQuery))
+	}
+	var fuzzyStmt, releaseStmt *sql.Stmt
+	if err == nil && fuzzy {
+		fuzzyStmt, err = db.Prepare(catalogSQL(FuzzyQuery))
+	}
+	if err == nil && source == "arr" {
+		releaseStmt, err = db.Prepare(ReleaseQuery)
+	}
+	var dataStmt, inodeStmt *sql.Stmt
+	if err == nil && (catalogSizes || catalogHashes) {
+		dataStmt, err = db.Prepare(catalogSQL(DataQuery))
+	}
+	if err == nil && catalogInodes && len(seedRoots) > 0 {
+		inodeStmt, err = db.Prepare(catalogSQL(InodeQuery))
+	}
+	if err != nil {
+		errc <- failure(errQuery, "", err)
+		for range i {
+		}
+		return
+	}
+	// torrents that failed or were held back, which aren't moved to
+	// failedDir as unmatched
+	undecided := make(map[string]bool)
+	fail := func(e *pipelineError) {
+		undecided[e.Torrent] = true
+		errc <- e
+	}
+	m := &matcher.Matcher{
+		Names:  names(),
+		Source: catalogFiles{stmt},
+		Exclude: func(fullpath string) bool {
+			if excluded(fullpath) {
+				slog.Debug("excluded", "path", fullpath)
+				return true
+			}
+			return false
+		},
+	}
+	if dataStmt != nil {
+		m.Confirm = func(_ context.Context, ti *metainfo.Info, file string, size int64, fullpath string) (bool, error) {
+			same, err := sameData(dataStmt, ti, file, size, fullpath)
+			if err == nil && !same {
+				slog.Debug("size or hash differs", "path", fullpath)
+			}
+			return same, err
+		}
+	}
+	// maps torrent files to paths at which torrents should be added
+	matches := make(map[string]string)
+	// torrents seen, and whether a DB match was excluded
+	seen := make(map[string]bool)
+	// torrents excluded before the DB was queried, and why
+	excludedBy := make(map[string]string)
+	// torrents in the order they were first seen, for the report
+	var order []string
+	// the tracker each torrent is counted under
+	trackerOf := make(map[string]string)
+	defer func() {
+		var unmatched, excluded int
+		var unmatchedTors []string
+		for _, tor := range order {
+			ex := seen[tor]
+			if _, ok := matches[tor]; ok {
+				continue
+			}
+			if ex {
+				excluded++
+				if excludedBy[tor] == "" && ctx.Err() == nil {
+					events.excluded(tor, "filters")
+				}
+			} else {
+				unmatched++
+				unmatchedTors = append(unmatchedTors, tor)
+				rep.tracker(trackerOf[tor], func(c *trackerCounts) { c.Unmatched++ })
+				if ctx.Err() == nil {
+					events.unmatched(tor)
+				}
+				if !undecided[tor] && ctx.Err() == nil {
+					moveTorrent(tor, failedDir)
+				}
+			}
+		}
+		rep.count(&rep.Unmatched, unmatched)
+		rep.count(&rep.Excluded, excluded)
+		rep.mu.Lock()
+		rep.UnmatchedTorrents = append(rep.UnmatchedTorrents, unmatchedTors...)
+		rep.mu.Unlock()
+	}()
+
+	// the first torrent read with each info hash, and the torrents
+	// duplicating it
+	byHash := make(map[string]string)
+	dupOf := make(map[string]string)
+
+	// torrents left unread because the run was cut short
+	cut := make(map[string]bool)
+	for tf := range prefetchLookups(ctx, db, parseAhead(ctx, i)) {
+		progress.working(tf.tor)
+		if ctx.Err() != nil {
+			if _, ok := seen[tf.tor]; !ok && !cut[tf.tor] {
+				cut[tf.tor] = true
+				rep.count(&rep.Unprocessed, 1)
+			}
+			continue
+		}
+		if first, ok := dupOf[tf.tor]; ok {
+			tf = &torFile{tor: first, file: tf.file, byName: tf.byName, size: tf.size, others: tf.others, sizes: tf.sizes, found: tf.found}
+		}
+		if _, ok := matches[tf.tor]; ok {
+			// only need one match per torrent
+			continue
+		}
+		if _, ok := seen[tf.tor]; !ok {
+			ti, err := tf.meta(ctx)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				// failed rather than unmatched; don't try again
+				matches[tf.tor] = ""
+				var pe *metainfo.ParseError
+				if errors.As(err, &pe) && !errors.Is(err, fs.ErrNotExist) {
+					moveTorrent(tf.tor, failedDir)
+				}
+				continue
+			}
+			if first, ok := byHash[ti.InfoHash]; ok {
+				slog.Info("duplicate in input", "torrent", tf.tor, "of", first, "hash", ti.InfoHash)
+				rep.count(&rep.InputDuplicates, 1)
+				dupOf[tf.tor] = first
+				tf = &torFile{tor: first, file: tf.file, byName: tf.byName, size: tf.size, others: tf.others, sizes: tf.sizes, found: tf.found}
+				if _, ok := matches[tf.tor]; ok {
+					continue
+				}
+			} else {
+				byHash[ti.InfoHash] = tf.tor
+				seen[tf.tor] = false
+				order = append(order, tf.tor)
+				rep.count(&rep.Scanned, 1)
+				trackerOf[tf.tor] = trackerHost(ti.Announce)
+				rep.tracker(trackerOf[tf.tor], func(c *trackerCounts) { c.Scanned++ })
+				events.scanned(tf.tor, ti.InfoHash, ti.Name)
+				if p := packedTorrentOf(tf.tor, ti); p != nil {
+					slog.Info("packed in archives; not matching", "torrent", tf.tor, "archives", p.Archives, "files", p.Files)
+					rep.packed(p)
+					events.excluded(tf.tor, "packed")
+					matches[tf.tor] = ""
+					continue
+				}
+			}
+		}
+		stage, dir, err := state.lookup(tf.tor)
+		if err != nil {
+			fail(failure(errState, tf.tor, err))
+		}
+		switch stage {
+		case stageAdded, stagePresent:
+			slog.Debug("skipping per state", "torrent", tf.tor, "stage", stage)
+			matches[tf.tor] = dir
+			rep.count(&rep.Present, 1)
+			continue
+		case stageBad:
+			slog.Debug("excluded as a bad match per state", "torrent", tf.tor)
+			seen[tf.tor] = true
+			if excludedBy[tf.tor] == "" {
+				excludedBy[tf.tor] = "bad"
+				events.excluded(tf.tor, "bad")
+			}
+			continue
+		case stageMatched:
+			// resume without querying again
+			slog.Debug("resuming match from state", "torrent", tf.tor, "dir", dir)
+			matches[tf.tor] = dir
+			match, err := newMatch(ctx, tf, dir, nil, stmt, existsStmt)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+			rep.count(&rep.Matched, 1)
+			events.matched(match)
+			matchQueue.sendMatch(o, match)
+			continue
+		}
+		if trackerFiltered() || propsFiltered() {
+			ti, err := tf.meta(ctx)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+			if !trackerAllowed(ti.Announce) {
+				slog.Debug("excluded by tracker", "torrent", tf.tor)
+				seen[tf.tor] = true
+				if excludedBy[tf.tor] == "" {
+					excludedBy[tf.tor] = "tracker"
+					events.excluded(tf.tor, "tracker")
+				}
+				continue
+			}
+			if by := propsExcluded(ti); by != "" {
+				slog.Debug("excluded by torrent properties", "torrent", tf.tor, "by", by)
+				seen[tf.tor] = true
+				if excludedBy[tf.tor] == "" {
+					excludedBy[tf.tor] = "properties"
+					events.excluded(tf.tor, "properties")
+				}
+				continue
+			}
+		}
+		slog.Debug("querying", "torrent", tf.tor, "file", tf.file)
+		// a magnet has no file list to place the file by
+		var ti *metainfo.Info
+		if !isMagnet(tf.tor) {
+			ti, err = tf.meta(ctx)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+		}
+		var found []matcher.Candidate
+		var ex bool
+		err = withRetry(ctx, "query", transientDB, func() (err error) {
+			switch {
+			case tf.found != nil:
+				found, ex, err = m.Candidates(ctx, ti, tf.file, tf.size, tf.found)
+			case tf.byName:
+				var results []string
+				if results, err = lookupName(nameStmt, tf.file); err == nil {
+					found, ex, err = m.Candidates(ctx, ti, tf.file, tf.size, results)
+				}
+			default:
+				found, ex, err = m.Match(ctx, ti, tf.file, tf.size)
+			}
+			return err
+		})
+		if err != nil {
+			fail(failure(errQuery, tf.tor, err))
+			continue
+		}
+		if ex {
+			seen[tf.tor] = true
+		}
+		var candidates []string
+		// candidates whose folders are named differently than the torrent's
+		renamedAt := make(map[string]bool)
+		for _, c := range found {
+			candidates = append(candidates, c.Dir)
+			renamedAt[c.Dir] = c.Renamed
+		}
+		var listed int
+		if err == nil && len(candidates) > 0 && len(tf.others) > 0 {
+			candidates, listed, err = confirmListed(ctx, existsStmt, ti, tf, candidates)
+		}
+		if err != nil {
+			fail(matchFailure(tf.tor, err))
+			continue
+		}
+		if statSizes && len(candidates) > 0 && !tf.byName {
+			if candidates, err = statCandidates(ti, tf, candidates); err != nil {
+				fail(failure(errSize, tf.tor, err))
+				// failed rather than unmatched
+				matches[tf.tor] = ""
+				continue
+			}
+		}
+		if candidates, err = unlinkedCandidates(inodeStmt, ti, tf, candidates); err != nil {
+			fail(failure(errQuery, tf.tor, err))
+			continue
+		}
+		if len(candidates) > 0 {
+			i, err := resolveCandidates(ctx, tf, candidates, existsStmt)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+			if i < 0 {
+				// skipped by the user; leave it unmatched
+				undecided[tf.tor] = true
+				continue
+			}
+			path := candidates[i]
+			slog.Info("matched", "torrent", tf.tor, "dir", path, "candidates", len(candidates))
+			if renamedAt[path] {
+				slog.Warn("torrent's folders are named differently on disk; see --rename-to-disk", "torrent", tf.tor, "dir", path)
+			}
+			matches[tf.tor] = path
+			match, err := newMatch(ctx, tf, path, nil, stmt, existsStmt)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+			if listed < len(tf.others) && match.confidence == "exact" {
+				match.confidence = fmt.Sprintf("%d/%d listed files", listed+1, len(tf.others)+1)
+			}
+			match.candidates = candidates
+			if err := state.record(match, stageMatched); err != nil {
+				fail(failure(errState, tf.tor, err))
+			}
+			rep.count(&rep.Matched, 1)
+			events.matched(match)
+			matchQueue.sendMatch(o, match)
+			continue
+		}
+		// renaming needs the metainfo, which a magnet hasn't got until
+		// the client fetches it
+		if isMagnet(tf.tor) || ctx.Err() != nil {
+			continue
+		}
+		if releaseStmt != nil && !tf.byName {
+			dir, renames, ok, err := releaseMatch(ctx, releaseStmt, tf)
+			if err != nil {
+				fail(matchFailure(tf.tor, err))
+				continue
+			}
+			if ok {
+				slog.Info("matched by release name", "torrent", tf.tor, "file", tf.file, "dir", dir, "renames", len(renames))
+				match, err := newMatch(ctx, tf, dir, renames, stmt, existsStmt)
+				if err != nil {
+					fail(matchFailure(tf.tor, err))
+					continue
+				}
+				match.confidence = "release name"
+				// not recorded as matched: resuming would lose the renames
+				matches[tf.tor] = dir
+				rep.count(&rep.Matched, 1)
+				events.matched(match)
+				matchQueue.sendMatch(o, match)
+				continue
+			}
+		}
+		if !fuzzy {
+			continue
+		}
+		var fr *fuzzyResult
+		err = withRetry(ctx, "query", transientDB, func() (err error) {
+			fr, err = fuzzyLookup(fuzzyStmt, tf.file)
+			return err
+		})
+		if err != nil {
+			fail(failure(errQuery, tf.tor, err))
+			continue
+		}
+		if fr == nil {
+			continue
+		}
+		fullpath := strings.TrimSuffix(fr.dir, "/") + "/" + fr.file
+		if excluded(fullpath) {
+			slog.Debug("excluded", "path", fullpath)
+			seen[tf.tor] = true
+			continue
+		}
+		dir, renames, ok := fuzzyPlace(tf.file, fr)
+		if !ok {
+			continue
+		}
+		if dataStmt != nil {
+			var same bool
+			err = withRetry(ctx, "query", transientDB, func() (err error) {
+				same, err = sameData(dataStmt, ti, tf.file, tf.size, fullpath)
+				return err
+			})
+			if err != nil {
+				fail(failure(errQuery, tf.tor, err))
+				continue
+			}
+			if !same {
+				slog.Info("fuzzy match's size or hash differs", "torrent", tf.tor, "file", tf.file, "path", fullpath)
+				continue
+			}
+		}
+		if !acceptFuzzy && !review {
+			slog.Info("fuzzy match held back; use --accept-fuzzy or --review", "torrent", tf.tor, "file", tf.file, "path", fullpath)
+			rep.count(&rep.Fuzzy, 1)
+			undecided[tf.tor] = true
+			if approvals != nil {
+				match, err := newMatch(ctx, tf, dir, renames, stmt, existsStmt)
+				if err != nil {
+					fail(matchFailure(tf.tor, err))
+					continue
+				}
+				match.confidence = fmt.Sprintf("fuzzy (%d)", fr.distance)
+				approvals.hold(match)
+			}
+			continue
+		}
+		slog.Info("fuzzy match", "torrent", tf.tor, "file", tf.file, "path", fullpath, "distance", fr.distance)
+		match, err := newMatch(ctx, tf, dir, renames, stmt, existsStmt)
+		if err != nil {
+			fail(matchFailure(tf.tor, err))
+			continue
+		}
+		match.confidence = fmt.Sprintf("fuzzy (%d)", fr.distance)
+		// not recorded as matched: resuming would lose the renames
+		matches[tf.tor] = dir
+		rep.count(&rep.Matched, 1)
+		events.matched(match)
+		matchQueue.sendMatch(o, match)
+	}
+	progress.idle()
+}
+
+func scanFiles(ctx context.Context, db *sql.DB, c chan *torFile, errc chan<- *pipelineError, args []string) {
+	names, errs := expandInputs(args)
+	for _, err := range errs {
+		errc <- failure(errInput, "", err)
+	}
+	for _, arg := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		if isTorrentFile(arg) {
+			tf, err := wholeTorFile(ctx, arg)
+			if err != nil {
+				errc <- failure(errParse, arg, err)
+				continue
+			}
+			scanQueue.sendTor(c, tf)
+			continue
+		}
+		f, err := openInput(arg)
+		if err != nil {
+			errc <- failure(errInput, "", err)
+			continue
+		}
+		defer f.Close()
+		r := newRecordReader(f, formatOf(arg))
+		var pending *torFile
+		for ctx.Err() == nil {
+			rec, err := r.next()
+			if err == io.EOF {
+				break
+			}
+			var bad *badRecord
+			if errors.As(err, &bad) {
+				errc <- failure(errInput, "", fmt.Errorf("%s: %v", arg, err))
+				continue
+			}
+			if err != nil {
+				errc <- failure(errInput, "", fmt.Errorf("%s: %v", arg, err))
+				break
+			}
+			if pending != nil && rec.tor == pending.tor && rec.file != "" {
+				// consecutive lines listing more of the torrent's files
+				pending.others = append(append(pending.others, rec.file), rec.others...)
+				if rec.size > 0 || len(rec.sizes) > 0 {
+					if pending.sizes == nil {
+						pending.sizes = make(map[string]int64)
+					}
+					maps.Copy(pending.sizes, rec.sizes)
+					if rec.size > 0 {
+						pending.sizes[rec.file] = rec.size
+					}
+				}
+				continue
+			}
+			if pending != nil {
+				scanQueue.sendTor(c, pending)
+				pending = nil
+			}
+			if rec.file == "" && isMagnet(rec.tor) {
+				ti, err := parseMagnet(rec.tor)
+				if err != nil {
+					errc <- failure(errInput, "", fmt.Errorf("%s: %v", arg, err))
+					continue
+				}
+				if ti.Name == "" {
+					errc <- failure(errInput, "", fmt.Errorf("%s: magnet without a display name needs a contained filename: %q", arg, rec.tor))
+					continue
+				}
+				scanQueue.sendTor(c, &torFile{tor: rec.tor, file: ti.Name, byName: true})
+				continue
+			}
+			if rec.file == "" {
+				errc <- failure(errInput, "", fmt.Errorf("%s: no contained filename for %q", arg, rec.tor))
+				continue
+			}
+			pending = &torFile{tor: rec.tor, file: rec.file, size: rec.size, others: rec.others, sizes: rec.sizes}
+		}
+		if pending != nil {
+			scanQueue.sendTor(c, pending)
+		}
+	}
+}
+
+func addTorrents(ctx context.Context, clients *clientPool, state *stateDB, m chan *matchedFile, rep *report, rf, carry *retryFile, sc *script, quota *addQuota, errc chan<- *pipelineError, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var lastAdd time.Time
+	for match := range m {
+		claimed := false
+		var addErr error
+		outcome := func(o string) {
+			switch o {
+			case outcomeFailed, outcomeRejected, outcomeDeferred, outcomeSkipped, outcomeCrossSeed:
+				// a later match of the same torrent may still add it
+				if claimed {
+					clients.release(match.hashes()...)
+				}
+			}
+			switch o {
+			case outcomeAdded, outcomePresent, outcomeDuplicate:
+				if err := mark(match); err != nil {
+					errc <- failure(errMark, match.tor, err)
+				}
+			}
+			rep.outcome(match, o)
+			failedAdds.outcome(match, o, addErr)
+			if err := results.record(match, o); err != nil {
+				errc <- failure(errState, match.tor, err)
+			}
+		}
+		if ctx.Err() != nil {
+			// left for the next run
+			rep.skipped()
+			outcome(outcomeSkipped)
+			continue
+		}
+		cfg.Hooks.matched(match, rep)
+		if _, claimed = clients.claim(match.hashes()...); !claimed {
+			// this torrent is already known in a BitTorrent client, or another
+			// worker is adding it
+			rep.count(&rep.Present, 1)
+			checkLocation(ctx, clients, match, rep, errc)
+			if err := state.record(match, stagePresent); err != nil {
+				errc <- failure(errState, match.tor, err)
+			}
+			outcome(outcomePresent)
+			moveTorrent(match.tor, processedDir)
+			continue
+		}
+		if first, ok := rep.crossSeedOf(match); ok {
+			slog.Info("cross-seed", "torrent", match.tor, "of", first, "data", match.dataFile)
+			if !crossSeed {
+				rep.count(&rep.CrossSeeded, 1)
+				outcome(outcomeCrossSeed)
+				continue
+			}
+		}
+		cl := clients.route(match)
+		if err := checkStale(match); err != nil {
+			errc <- failure(errStale, match.tor, err)
+			outcome(outcomeFailed)
+			continue
+		}
+		if err := runHooks("pre-add", preAddHooks, match, rep); err != nil {
+			outcome(outcomeRejected)
+			continue
+		}
+		if !quota.take() {
+			if err := carry.add(match); err != nil {
+				slog.Error("writing carry-over file", "err", err)
+			}
+			rep.count(&rep.Deferred, 1)
+			outcome(outcomeDeferred)
+			continue
+		}
+		if sc != nil {
+			filename, err := localTorrent(ctx, match.tor)
+			if err != nil {
+				quota.giveBack()
+				errc <- failure(errFetch, match.tor, err)
+				outcome(outcomeFailed)
+				continue
+			}
+			if err := sc.add(cl, match, filename); err != nil {
+				quota.giveBack()
+				errc <- failure(errInput, match.tor, fmt.Errorf("writing script: %v", err))
+				outcome(outcomeFailed)
+				continue
+			}
+			rep.count(&rep.Scripted, 1)
+			outcome(outcomeScripted)
+			continue
+		}
+		if resumeDir != "" {
+			if err := makeLinks(match.links); err != nil {
+				quota.giveBack()
+				errc <- failure(errLink, match.tor, err)
+				outcome(outcomeFailed)
+				continue
+			}
+			ti, have, err := verifyMatch(ctx, match)
+			if err != nil {
+				quota.giveBack()
+				errc <- failure(errVerify, match.tor, err)
+				outcome(outcomeFailed)
+				continue
+			}
+			if err := writeResume(ctx, match, ti, have); err != nil {
+				quota.giveBack()
+				errc <- failure(errInput, match.tor, fmt.Errorf("writing resume data: %v", err))
+				outcome(outcomeFailed)
+				continue
+			}
+			rep.count(&rep.Resumed, 1)
+			outcome(outcomeResumed)
+			continue
+		}
+		if offline {
+			slog.Info("offline add", "torrent", match.tor, "download_dir", match.path, "client", cl.name)
+			rep.count(&rep.Offline, 1)
+			outcome(outcomeOffline)
+			continue
+		}
+		if err := checkFreeSpace(ctx, cl, match); err != nil {
+			quota.giveBack()
+			errc <- failure(errSpace, match.tor, err)
+			outcome(outcomeFailed)
+			continue
+		}
+		if !waitAddInterval(ctx, lastAdd) {
+			quota.giveBack()
+			rep.skipped()
+			outcome(outcomeSkipped)
+			continue
+		}
+		lastAdd = time.Now()
+		if err := makeLinks(match.links); err != nil {
+			quota.giveBack()
+			errc <- failure(errLink, match.tor, err)
+			outcome(outcomeFailed)
+			continue
+		}
+		if err := checkFollows(ctx, cl, match.links); err != nil {
+			quota.giveBack()
+			errc <- failure(errLink, match.tor, err)
+			outcome(outcomeFailed)
+			continue
+		}
+		if verifyPieces {
+			if _, _, err := verifyMatch(ctx, match); err != nil {
+				quota.giveBack()
+				errc <- failure(errVerify, match.tor, err)
+				outcome(outcomeFailed)
+				continue
+			}
+		}
+		filename, err := localTorrent(ctx, match.tor)
+		if err != nil {
+			quota.giveBack()
+			errc <- failure(errFetch, match.tor, err)
+			outcome(outcomeFailed)
+			continue
+		}
+		var t client.Torrent
+		err = withRetry(ctx, "add", client.Transient, func() (err error) {
+			// an add under way is finished, not abandoned, when the run
+			// is cut short
+			t, err = cl.rpc.AddFile(context.WithoutCancel(ctx), filename, &client.AddOptions{
+				DownloadDir:       clientPath(match.path),
+				FilesUnwanted:     match.unwanted,
+				Labels:            match.labels,
+				BandwidthPriority: match.bandwidthPriority,
+				PriorityHigh:      match.priorityHigh,
+				PriorityLow:       match.priorityLow,
+				// start once renamed, or it would download the old names
+				Paused: len(match.renames) > 0 || match.paused(),
+			})
+			return err
+		})
+		if err == nil && match.seed != nil {
+			serr := withRetry(ctx, "set seeding", client.Transient, func() error {
+				return cl.rpc.SetSeeding(context.WithoutCancel(ctx), t.ID, match.seed)
+			})
+			if serr != nil {
+				// added all the same
+				errc <- failure(errRPC, match.tor, fmt.Errorf("setting group and seed limits: %w", serr))
+			}
+		}
+		if err == nil && len(match.renames) > 0 {
+			err = renameAndStart(ctx, cl, t, match.renames, !match.paused())
+		}
+		if err == client.ErrDuplicate {
+			// added since we listed the client's torrents
+			slog.Info("duplicate", "torrent", match.tor, "name", t.Name, "client", cl.name)
+			rep.duplicate()
+			clients.added(cl, match.hashes()...)
+			if err := state.record(match, stagePresent); err != nil {
+				errc <- failure(errState, match.tor, err)
+			}
+			outcome(outcomeDuplicate)
+			moveTorrent(match.tor, processedDir)
+			continue
+		}
+		if err != nil {
+			addErr = err
+			errc <- failure(errRPC, match.tor, err)
+			if err := state.event(match, "add failed", err.Error()); err != nil {
+				errc <- failure(errState, match.tor, err)
+			}
+			if client.Transient(err) {
+				if err := rf.add(match); err != nil {
+					slog.Error("writing retry file", "err", err)
+				}
+			}
+			outcome(outcomeFailed)
+			continue
+		}
+		slog.Info("added", "torrent", match.tor, "name", t.Name, "client", cl.name)
+		events.added(match, t.Name, cl.name)
+		clients.added(cl, match.hashes()...)
+		verifies.add(cl, t, match)
+		rep.added()
+		if err := state.record(match, stageAdded); err != nil {
+			errc <- failure(errState, match.tor, err)
+		}
+		outcome(outcomeAdded)
+		if len(match.unwanted) > 0 {
+			rep.partial()
+		}
+		if len(match.renames) > 0 {
+			rep.count(&rep.Renamed, len(match.renames))
+		}
+		notify.added(match, t.Name, cl.name)
+		runHooks("post-add", postAddHooks, match, rep)
+		cfg.Hooks.added(match, rep)
+		moveTorrent(match.tor, processedDir)
+	}
+}
+
+// exit codes
+const (
+	exitOK = 0
+	// failures other than RPC failures
+	exitFailed = 1
+	// everything matched was added, but some torrents had no match
+	exitUnmatched = 2
+	// at least one add failed
+	exitRPC = 3
+	// another run holds --lock-file
+	exitLocked      = 4
+	exitInterrupted = 130
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flag.StringVar(&dbFile, "db", "", "sqlite3 DB file")
+	flag.StringVar(&source, "source", "db", "where files are listed: db (--db), fs (walk --root), locate (the locate DB, under --root), rclone, arr, jellyfin or plex")
+	flag.BoolVar(&windowsPaths, "windows-paths", false, "the catalog's or media server's paths are Windows paths, with backslashes, and the clients run on Windows")
+	flag.Var(&seedRoots, "seed-root", "of candidates that are hard links of the same file, match the one under this dir; may be repeated")
+	flag.Var(&roots, "root", "directory to list files under for --source=fs or locate, or where the rclone remote is mounted; for other sources, only match files under it; may be repeated")
+	flag.StringVar(&rcloneLsjson, "rclone-lsjson", "", "rclone lsjson -R output to list files from, for --source=rclone")
+	flag.StringVar(&rcloneRC, "rclone-rc", "", "rclone RC API URL to list --rclone-fs with, for --source=rclone")
+	flag.StringVar(&rcloneFS, "rclone-fs", "", "the remote for --rclone-rc to list, as remote:path")
+	flag.Var(&arrURLs, "arr", "Sonarr or Radarr URL, with ?apikey=, to list files from for --source=arr; may be repeated")
+	flag.StringVar(&jellyfinURL, "jellyfin", "", "Jellyfin URL, with ?api_key=, to list files from for --source=jellyfin")
+	flag.StringVar(&plexDB, "plex-db", "", "Plex library DB to list files from for --source=plex")
+	flag.StringVar(&locateDB, "locate-db", "", "mlocate database to read for --source=locate instead of running locate")
+	flag.DurationVar(&dbTimeout, "dbtimeout", 30*time.Second, "timeout for DB operations, including waiting for locks")
+	flag.BoolVar(&dbWAL, "db-wal", false, "switch the DB to WAL journaling, so reads don't block on writers")
+	flag.BoolVar(&dbReadOnly, "db-ro", false, "open the DB read-only")
+	flag.BoolVar(&dbImmutable, "db-immutable", false, "open the DB as immutable: no locking, for DBs nothing else writes to")
+	flag.DurationVar(&maxDBAge, "max-db-age", 0, "refuse a DB built longer ago than this, by its metadata table or modification time; 0 for no limit")
+	flag.StringVar(&staleDB, "stale-db", "refuse", "what to do with a DB over --max-db-age: refuse or warn")
+	flag.Var(filterFlag{include: false}, "exclude", "regex for excluding matched paths from the DB (repeatable)")
+	flag.Var(filterFlag{include: true}, "include", "regex for keeping matched paths from the DB (repeatable); if the first filter is an include, paths matching no filter are excluded")
+	flag.Var(&filterFile{}, "filter-file", "file of gitignore-style include (!) and exclude globs for matched paths, applied in order with --include and --exclude")
+	flag.StringVar(&server, "server", "localhost:9091", "server host:port or URL")
+	flag.StringVar(&rpcPath, "rpc-path", "", "RPC path on the server (default "+client.DefaultRPCPath+", or the path of a --server URL)")
+	flag.StringVar(&username, "u", "transmission", "username")
+	flag.StringVar(&password, "p", "", "password")
+	flag.BoolVar(&askPass, "ask-pass", false, "prompt on the terminal for passwords not given by -p or the config file")
+	flag.BoolVar(&useKeyring, "keyring", false, "look up passwords not given by -p or the config file in the OS keyring; with --ask-pass, store the ones typed there")
+	flag.BoolVar(&ssl, "ssl", false, "use SSL in server connections")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM file of CA certificates to trust for the server")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM client certificate to present to the server")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key for --tls-cert")
+	flag.BoolVar(&tlsInsecure, "tls-insecure", false, "don't verify the server's certificate")
+	flag.DurationVar(&rpcTimeout, "rpc-timeout", 2*time.Minute, "time limit for each RPC call; 0 for none")
+	flag.DurationVar(&deadline, "deadline", 0, "stop matching and adding after this long, leaving the rest for the next run; for daemon, per pass")
+	flag.StringVar(&proxy, "proxy", "", "connect to the server through this proxy: http://, https://, socks5:// or socks5h://host:port (default HTTP_PROXY and HTTPS_PROXY)")
+	flag.Var(&preAddHooks, "pre-add", "command to run on the seeding host before each add (repeatable); {torrent}, {hash}, and {dir} are substituted")
+	flag.Var(&postAddHooks, "post-add", "command to run on the seeding host after each successful add (repeatable)")
+	flag.StringVar(&hookHost, "hook-host", "", "run hooks on this host via ssh instead of locally")
+	flag.DurationVar(&hookTimeout, "hook-timeout", time.Minute, "timeout for each hook command")
+	flag.IntVar(&retries, "retries", 3, "number of times to retry transient RPC and DB failures")
+	flag.DurationVar(&retryBackoff, "retry-backoff", time.Second, "wait before the first retry; doubled for each subsequent retry")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.2, "random fraction of the backoff added to each wait")
+	flag.StringVar(&retryFilePath, "retry-file", "", "append adds that failed after all retries to this file, in input format")
+	flag.Float64Var(&verifyThreshold, "verify-threshold", 0, "after adding, wait for the clients to verify the added torrents, and count those with less than this fraction of their wanted data as bad matches")
+	flag.DurationVar(&verifyTimeout, "verify-timeout", time.Hour, "how long to wait for added torrents' verification with --verify-threshold")
+	flag.BoolVar(&rollback, "rollback", false, "remove bad matches from the client, keeping the data, and record them as bad in the state DB so they aren't added again")
+	flag.BoolVar(&fixLocation, "fix-location", false, "point torrents already in a client under another download dir at the matched one, without moving data, and verify them")
+	flag.StringVar(&retryQueuePath, "retry-queue", "", "append adds the client refused to this queue file, for `reconciler retry` to add again")
+	flag.IntVar(&retryAttempts, "retry-attempts", 5, "retry: drop queued adds once they have failed this many times (0 for never)")
+	flag.BoolVar(&recordMatches, "record-matches", false, "record the outcome of every match in the DB's reconciler_matches table")
+	flag.BoolVar(&offline, "offline", false, "don't contact the clients: run everything else, recording the adds in the report instead of making them")
+	flag.StringVar(&emitScript, "emit-script", "", "write the adds as a script instead of making them: sh (transmission-remote) or curl")
+	flag.StringVar(&scriptPath, "script-file", "-", "file to write the --emit-script script to, or - for stdout")
+	flag.StringVar(&seedFlags.Group, "group", "", "put added torrents in this bandwidth group (Transmission 4)")
+	flag.Func("seed-ratio-limit", "stop seeding added torrents at this ratio; negative for no limit (default the client's)", parseRatioLimit)
+	flag.Func("seed-idle-limit", "stop seeding added torrents idle for this many minutes; negative for no limit (default the client's)", parseIdleLimit)
+	flag.StringVar(&bandwidthPriority, "bandwidth-priority", "normal", "bandwidth priority of added torrents: low, normal or high")
+	flag.Var(&highPriority, "high-priority", "regex of torrent file paths to download at high priority (repeatable)")
+	flag.Var(&lowPriority, "low-priority", "regex of torrent file paths to download at low priority (repeatable)")
+	flag.BoolVar(&lowPriorityExtras, "low-priority-extras", false, "download samples, .nfo files and the like at low priority")
+	flag.BoolVar(&verifyPieces, "verify-pieces", false, "hash each match's data against its pieces before adding it")
+	flag.StringVar(&statPaths, "stat-paths", "auto", "stat each match's data dir and file before adding it, and skip it if they're gone: auto (when the data is visible from this host), always or never")
+	flag.BoolVar(&statSizes, "stat-sizes", false, "stat each torrent's listed files under its candidate dirs, and drop dirs where one is missing or not the size the input or the torrent gives")
+	flag.StringVar(&processedDir, "processed-dir", "", "move .torrent files here once added or found in a client")
+	flag.StringVar(&failedDir, "failed-dir", "", "move .torrent files here once unmatched or found not to parse")
+	flag.StringVar(&resumeDir, "resume-dir", "", "write verified matches with resume data to this directory instead of adding them")
+	flag.StringVar(&resumeFormat, "resume-format", "qbittorrent", "resume data for --resume-dir: qbittorrent or rtorrent")
+	flag.StringVar(&inputFormat, "input-format", "auto", "format of the input files: tsv, csv, jsonl, or auto to go by extension")
+	flag.Var(&trackerInclude, "tracker-include", "only reconcile torrents with a tracker URL matching this regex (repeatable)")
+	flag.Var(&trackerExclude, "tracker-exclude", "skip torrents with a tracker URL matching this regex (repeatable)")
+	flag.Var(&excludeName, "exclude-name", "skip torrents whose name matches this regex (repeatable)")
+	flag.Var(&minSize, "min-size", "skip torrents smaller than this, in bytes or with a K, M, G or T suffix")
+	flag.Var(&maxSize, "max-size", "skip torrents larger than this, in bytes or with a K, M, G or T suffix")
+	flag.Var(&createdAfter, "created-after", "skip torrents created before this date, YYYY-MM-DD or RFC 3339")
+	flag.Var(&createdBefore, "created-before", "skip torrents created on or after this date, YYYY-MM-DD or RFC 3339")
+	flag.StringVar(&private, "private", "", "\"only\" reconciles only private torrents, \"skip\" skips them")
+	flag.BoolFunc("private-only", "same as --private only", privateFlag("only"))
+	flag.BoolFunc("public-only", "same as --private skip", privateFlag("skip"))
+	flag.BoolVar(&pausePublic, "pause-public", false, "add torrents that aren't private paused")
+	flag.BoolVar(&renameToDisk, "rename-to-disk", false, "rename torrents' folders and files to the names on disk when they differ")
+	flag.StringVar(&linkDir, "link-dir", "", "build hard link trees under this dir for torrents whose files aren't laid out as the torrent expects")
+	flag.StringVar(&markData, "mark-data", "", "mark the data dirs of torrents added or present with their info hashes: xattr, or file for a .reconciled file")
+	flag.StringVar(&linkMode, "link-mode", "hard", "make --link-dir trees of hard links, symlinks, or auto: hard links but for data on other filesystems")
+	flag.BoolVar(&reportSplit, "report-split", false, "look for the missing files of partial matches elsewhere, reporting torrents split across dirs, without --link-dir too")
+	flag.StringVar(&downloadDirTemplate, "download-dir-template", "", "with --link-dir, link every matched torrent into this dir, with {torrent_name}, {tracker}, {year} and {hash} replaced")
+	flag.IntVar(&maxAdd, "max-add", 0, "add at most this many torrents per run; 0 for no limit")
+	flag.DurationVar(&addInterval, "add-interval", 0, "wait at least this long between adds")
+	flag.IntVar(&addWorkers, "add-workers", 1, "make this many adds at once")
+	flag.StringVar(&prioritize, "prioritize", "", "add matches in this order once all are made, for --max-add: size-desc, size-asc or mtime, the oldest .torrent files first")
+	flag.IntVar(&scanBuffer, "scan-buffer", 0, "scanned torrents to queue for matching")
+	flag.IntVar(&matchBuffer, "match-buffer", 0, "matches to queue for adding")
+	flag.IntVar(&parseWorkers, "parse-workers", 4, "parse or fetch this many torrents at once ahead of matching")
+	flag.IntVar(&lookupBatch, "lookup-batch", 0, "look up the contained files of this many torrents per query")
+	flag.StringVar(&carryOverPath, "carry-over", "", "append matches beyond --max-add to this file, in input format, for the next run")
+	flag.StringVar(&textfilePath, "textfile", "", "write node_exporter textfile collector metrics for the run to this file")
+	flag.StringVar(&partial, "partial", "", "for torrents only partly in the DB: \"unwanted\" marks the missing files unwanted")
+	flag.BoolVar(&checkSpace, "check-space", false, "find the files of every match missing from the DB, and skip torrents whose download wouldn't fit in the client's free space")
+	flag.Int64Var(&minFree, "min-free", 0, "bytes to leave free on the download dir when checking space")
+	flag.BoolVar(&review, "review", false, "review matches in a terminal UI before adding; only approved matches are added")
+	flag.StringVar(&statePath, "state", "", "SQLite DB recording each torrent's progress, so interrupted runs can resume")
+	flag.StringVar(&cachePath, "cache", "", "SQLite DB caching parsed .torrent files by path, mtime, and size; may be the --state DB")
+	flag.StringVar(&lockPath, "lock-file", "", "hold this lock file while running, so runs that overlap don't both add torrents")
+	flag.BoolVar(&lockWait, "wait", false, "with --lock-file, wait for a run holding the lock to finish instead of exiting")
+	flag.StringVar(&configPath, "config", "", "JSON configuration file")
+	flag.BoolVar(&matchSnapshots, "match-snapshots", false, "match files in snapshot dirs and recycle bins, which are otherwise excluded")
+	flag.BoolVar(&matchPacked, "match-packed", false, "match torrents that are mostly archives, as if the archives themselves were on disk, instead of listing them as packed")
+	flag.BoolVar(&crossSeed, "cross-seed", false, "add torrents matching the data of another torrent added or present, instead of only listing them as cross-seeds")
+	flag.StringVar(&packedFilePath, "packed-file", "", "write the torrents that are mostly archives, and so weren't matched, to this file")
+	flag.StringVar(&reportPath, "report", "", "write the run report as JSON to this file")
+	flag.StringVar(&htmlReportPath, "html-report", "", "write the run report as a standalone HTML page to this file")
+	flag.StringVar(&logFile, "log-file", "", "also append log output to this file")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum level to log: debug, info, warn, or error")
+	flag.BoolVar(&quiet, "quiet", false, "log only warnings and errors, as --log-level=warn")
+	flag.BoolVar(&verbose, "verbose", false, "log everything, as --log-level=debug")
+	flag.IntVar(&logBurst, "log-burst", 50, "log at most this many records with the same message and attributes a minute, and then how many more there were (0 for no limit)")
+	flag.StringVar(&eventsFormat, "events", "", "write each pipeline event as it happens to --events-file: ndjson")
+	flag.StringVar(&eventsPath, "events-file", "-", "file to append --events to, or - for stdout")
+	flag.StringVar(&progressMode, "progress", "auto", "show a status line instead of logging each torrent: auto (when stderr is a terminal), always, or never")
+	flag.StringVar(&logFormat, "log-format", "text", "log format: text, json, or journal: text with syslog priorities, for journald")
+	flag.Var(&notifyURLs, "notify", "POST a notification of the run's outcome to this URL (repeatable); in daemon mode, of each add and each pass with failures")
+	flag.StringVar(&notifyFormat, "notify-format", "json", "notification format: json, discord, slack, or ntfy")
+	flag.StringVar(&notifyOn, "notify-on", "all", "when to notify of a run's outcome, by --notify or --smtp: all or failure")
+	flag.StringVar(&smtpURL, "smtp", "", "mail a digest of the run through this server: smtp://[user[:password]@]host[:port], or smtps:// for TLS; the password may be in $SMTP_PASSWORD")
+	flag.Var(&mailTo, "mail-to", "address to mail the --smtp digest to (repeatable)")
+	flag.StringVar(&mailFrom, "mail-from", "", "sender of the --smtp digest (default reconciler@ this host)")
+	flag.StringVar(&normalize, "normalize", "", "\"nfc\" compares paths in Unicode NFC, so NFD paths from macOS still match")
+	flag.BoolVar(&caseInsensitive, "case-insensitive", false, "compare paths ignoring case")
+	flag.StringVar(&resolve, "resolve", "first", "which DB directory to use when several have a torrent's file: first, largest, newest, most-complete, or interactive")
+	flag.BoolVar(&fuzzy, "fuzzy", false, "when a contained file has no exact match, try DB files with similar names")
+	flag.IntVar(&fuzzyDistance, "fuzzy-distance", 2, "most edits allowed between loosely normalized names in a fuzzy match")
+	flag.BoolVar(&acceptFuzzy, "accept-fuzzy", false, "add fuzzy matches without --review")
+	flag.StringVar(&fetchDir, "fetch-dir", "", "keep .torrent files downloaded from URLs in this directory (default a temporary directory per run)")
+	flag.Var(&fetchHeaders, "fetch-header", "\"Name: value\" header for .torrent downloads, e.g. Cookie (repeatable)")
+	flag.BoolVar(&prune, "prune", false, "audit: remove torrents, keeping any data, whose files are nowhere in the DB")
+	flag.BoolVar(&pruneDryRun, "prune-dry-run", false, "audit: with --prune, print what would be removed without removing it")
+	flag.IntVar(&pruneAfter, "prune-after", 1, "audit: with --prune, only remove torrents missing from this many audits in a row (needs --state)")
+	flag.DurationVar(&daemonInterval, "interval", 15*time.Minute, "daemon: time between passes; 0 for passes only on --schedule")
+	flag.Var(&schedules, "schedule", "daemon: also run a pass when this cron expression, in local time, matches, e.g. \"0 3 * * *\" (repeatable)")
+	flag.BoolVar(&windowsService, "service", false, "daemon: run as a Windows service, as started by the service manager; log with --log-file")
+	flag.StringVar(&listenAddr, "listen", "localhost:9742", "daemon: address to serve /metrics on; api: address to serve the API on, which must be a loopback address without --api-token")
+	flag.BoolVar(&dashboard, "dashboard", false, "daemon: also serve the API and a web dashboard on --listen")
+	flag.StringVar(&apiToken, "api-token", "", "api and --dashboard: require this bearer token, or basic auth password, of API requests (default $RECONCILER_API_TOKEN)")
+	flag.StringVar(&apiTorrentDir, "api-torrent-dir", "", "api and --dashboard: let JSON requests name .torrent files under this dir")
+	flag.StringVar(&grpcListen, "grpc-listen", "", "api and --dashboard: also serve the API over gRPC at this address, which must be a loopback address without --api-token")
+	flag.StringVar(&approvalURL, "approval-url", "", "daemon --dashboard: send matches held for approval through --notify and --smtp with signed links to approve or reject them at this URL of the dashboard")
+	flag.StringVar(&approvalSecret, "approval-secret", "", "the key signing --approval-url links, at least 16 bytes (default $RECONCILER_APPROVAL_SECRET)")
+	flag.DurationVar(&approvalTTL, "approval-ttl", 24*time.Hour, "how long --approval-url links stay valid")
+
+	commands := map[string]func(args []string) int{
+		"debug-bundle": debugBundle,
+		"api":          serveAPI,
+		"audit":        audit,
+		"daemon":       daemon,
+		"diff":         diffRuns,
+		"retry":        retryAdds,
+	}
+	// a subcommand, if any, comes before the flags
+	cmd := reconcile
+	argv := os.Args[1:]
+	subcommand := false
+	if len(argv) > 0 {
+		if c, ok := commands[argv[0]]; ok {
+			cmd, subcommand = c, true
+			argv = argv[1:]
+		}
+	}
+	flag.CommandLine.Parse(argv)
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if !subcommand {
+		progress = newProgress()
+	}
+	var logOut io.Writer
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		logOut = f
+	}
+	if err := setupLogging(os.Stderr, logOut); err != nil {
+		log.Fatal(err)
+	}
+	defer repeats.flush(true)
+	if configPath != "" {
+		var err error
+		cfg, err = loadConfig(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return cmd(flag.Args())
+}
+
+// checkRunFlags validates the flags and arguments for a reconciling run.
+func checkRunFlags(args []string) error {
+	if len(args) < 1 && len(cfg.Feeds) == 0 {
+		return fmt.Errorf("must provide one or more files or configure feeds")
+	}
+	if err := checkSource(); err != nil {
+		return err
+	}
+	if err := checkInputFormat(); err != nil {
+		return err
+	}
+	if err := checkResume(); err != nil {
+		return err
+	}
+	if addWorkers < 1 {
+		return fmt.Errorf("--add-workers must be at least 1")
+	}
+	if err := checkPrioritize(); err != nil {
+		return err
+	}
+	if err := checkLookupBatch(); err != nil {
+		return err
+	}
+	if scanBuffer < 0 || matchBuffer < 0 {
+		return fmt.Errorf("--scan-buffer and --match-buffer can't be negative")
+	}
+	if err := checkEmitScript(); err != nil {
+		return err
+	}
+	if offline && (emitScript != "" || resumeDir != "") {
+		return fmt.Errorf("--offline can't be used with --emit-script or --resume-dir")
+	}
+	if err := checkPartial(); err != nil {
+		return err
+	}
+	if err := checkPriority(); err != nil {
+		return err
+	}
+	if err := checkProps(); err != nil {
+		return err
+	}
+	if err := checkResolve(); err != nil {
+		return err
+	}
+	if err := checkNormalize(); err != nil {
+		return err
+	}
+	if err := checkNotify(); err != nil {
+		return err
+	}
+	if err := checkProgress(); err != nil {
+		return err
+	}
+	if err := checkEvents(); err != nil {
+		return err
+	}
+	if err := checkMail(); err != nil {
+		return err
+	}
+	if err := checkApproval(); err != nil {
+		return err
+	}
+	if err := checkRollback(); err != nil {
+		return err
+	}
+	if err := checkLinks(); err != nil {
+		return err
+	}
+	if err := checkMark(); err != nil {
+		return err
+	}
+	if err := checkStatPaths(); err != nil {
+		return err
+	}
+	if err := checkFetchHeaders(); err != nil {
+		return err
+	}
+	return checkDBFlags()
+}
+
+// signalContext returns a context that is cancelled by SIGINT or SIGTERM.
+// A second signal kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// reconcile matches the torrents listed in the input files against the DB and
+// adds them to the client. On SIGINT or SIGTERM it finishes the add in
+// progress, skips the rest, and still writes the report.
+func reconcile(args []string) int {
+	if err := checkRunFlags(args); err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return lockFailed(err)
+	}
+	defer release()
+	ctx, cancel := withDeadline(ctx)
+	defer cancel()
+	notify = newNotifier()
+	mail = newMailer()
+	if events, err = openEvents(); err != nil {
+		log.Fatal(err)
+	}
+	defer events.Close()
+	rep, err := reconcilePass(ctx, args)
+	if err != nil {
+		notify.send("reconciler: run failed", err.Error(), true)
+		mail.send("reconciler: run failed", err.Error())
+		log.Fatal(err)
+	}
+	finishReport(rep)
+	progress.end(rep)
+	notify.finished(rep)
+	mail.finished(rep)
+	return rep.exitCode()
+}
+
+// reconcilePass runs the pipeline once over the input files. An error means
+// the pass could not start; failures of single torrents are in the report.
+func reconcilePass(ctx context.Context, args []string) (*report, error) {
+	if err := listRoots(ctx); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", catalogDSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := checkCatalog(db); err != nil {
+		return nil, err
+	}
+
+	clients, err := newClients()
+	if err != nil {
+		return nil, err
+	}
+	// a script or resume data is for clients that may not be reachable
+	// from here, and --offline reaches none
+	if emitScript == "" && resumeDir == "" && !offline {
+		if err := clients.loadHashes(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := openState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	defer state.Close()
+
+	infoCache, err = openCache(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer infoCache.Close()
+
+	rf, err := openRetryFile(retryFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rf.Close()
+
+	if failedAdds, err = openRetryQueue(); err != nil {
+		return nil, err
+	}
+	defer failedAdds.Close()
+
+	pending, err := takeCarryOver()
+	if err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		args = append(args[:len(args):len(args)], pending)
+	}
+	carry, err := openRetryFile(carryOverPath)
+	if err != nil {
+		return nil, err
+	}
+	defer carry.Close()
+
+	sc, err := openScript()
+	if err != nil {
+		return nil, err
+	}
+	defer sc.Close()
+
+	cleanup, err := openFetchDir()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	rep := newReport()
+	progress.begin(rep)
+	results, err = openResults(db, rep.Start)
+	if err != nil {
+		return nil, err
+	}
+	errc := make(chan *pipelineError)
+	eg := &sync.WaitGroup{}
+	eg.Add(1)
+	go rep.collect(errc, eg)
+	pg := &sync.WaitGroup{}
+	cg := &sync.WaitGroup{}
+	c := make(chan *torFile, scanBuffer)
+	m := make(chan *matchedFile, matchBuffer)
+	scanQueue = &queueStats{Name: "scan", Capacity: scanBuffer}
+	matchQueue = &queueStats{Name: "match", Capacity: matchBuffer}
+	rep.Queues = []*queueStats{scanQueue, matchQueue}
+	matched := m
+	var held []*matchedFile
+	rg := &sync.WaitGroup{}
+	if review || prioritize != "" {
+		// hold every match until the review is done, or to sort them
+		matched = make(chan *matchedFile)
+		rg.Add(1)
+		go func() {
+			defer rg.Done()
+			for match := range matched {
+				held = append(held, match)
+			}
+		}()
+	}
+	pg.Add(1)
+	go matchDBFiles(ctx, db, state, c, matched, rep, errc, pg)
+	quota := &addQuota{}
+	verifies = newVerifyWatch()
+	for i := 0; i < addWorkers; i++ {
+		cg.Add(1)
+		go addTorrents(ctx, clients, state, m, rep, rf, carry, sc, quota, errc, cg)
+	}
+	scanFiles(ctx, db, c, errc, args)
+	scanFeeds(ctx, c, errc)
+	progress.inputRead()
+	close(c)
+	pg.Wait()
+	if review || prioritize != "" {
+		close(matched)
+		rg.Wait()
+		prioritized(held)
+	}
+	if review {
+		if ctx.Err() != nil {
+			held = nil
+		}
+		approved, ok, err := reviewMatches(held)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			slog.Warn("review aborted; adding nothing")
+			approved = nil
+		}
+		slog.Info("review done", "approved", len(approved), "matches", len(held))
+		held = approved
+	}
+	for _, match := range held {
+		m <- match
+	}
+	close(m)
+	cg.Wait()
+	verifies.wait(ctx, state, rep, errc)
+	close(errc)
+	eg.Wait()
+	if pending != "" && ctx.Err() == nil {
+		if err := os.Remove(pending); err != nil {
+			slog.Error("removing carried-over matches", "err", err)
+		}
+	}
+	if ctx.Err() != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			slog.Warn("deadline reached; unprocessed torrents are left for the next run", "deadline", deadline)
+		} else {
+			slog.Warn("interrupted; unprocessed torrents are left for the next run")
+		}
+		rep.interrupted(ctx.Err() == context.DeadlineExceeded)
+	}
+	return rep, nil
+}
+
+// withDeadline returns ctx limited to --deadline from now, if it's set.
+func withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, deadline)
+}
+
+// finishReport logs rep and writes it wherever the flags ask.
+func finishReport(rep *report) {
+	rep.log()
+	if textfilePath != "" {
+		if err := writeTextfile(textfilePath, rep, time.Now()); err != nil {
+			slog.Error("writing textfile metrics", "err", err)
+		}
+	}
+	if reportPath != "" {
+		if err := rep.writeJSON(reportPath); err != nil {
+			slog.Error("writing report", "err", err)
+		}
+	}
+	if htmlReportPath != "" {
+		if err := rep.writeHTML(htmlReportPath); err != nil {
+			slog.Error("writing HTML report", "err", err)
+		}
+	}
+	if packedFilePath != "" {
+		if err := rep.writePackedFile(packedFilePath); err != nil {
+			slog.Error("writing packed torrents", "err", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// With --rename-to-disk, a torrent whose files are in the DB under other
+// directory names, such as a renamed root folder, is added paused and
+// renamed with torrent-rename-path to the names on disk, then verified and
+// started. Unlike --link-dir this touches nothing on disk, but only names
+// change: every file must be at the same depth below one download dir,
+// under its own base name up to case. It is tried before --link-dir.
+var renameToDisk bool
+
+// planRenames returns the download dir and renames lining up ti's files
+// with the DB, given that those at the indices in missing aren't under
+// dir. Files already under dir keep their names, and dir with them. It
+// returns false if some file can't be lined up.
+func planRenames(ctx context.Context, stmt *sql.Stmt, dir string, ti *metainfo.Info, missing []int) (string, []rename, bool, error) {
+	isMissing := make(map[int]bool)
+	for _, i := range missing {
+		isMissing[i] = true
+	}
+	// the name on disk of each torrent path and its parents
+	names := make(map[string]string)
+	paths := ti.Paths()
+	fixed := false
+	for i, p := range paths {
+		if p == "" || isMissing[i] {
+			continue
+		}
+		fixed = true
+		want := strings.Split(p, "/")
+		for k := range want {
+			names[strings.Join(want[:k+1], "/")] = want[k]
+		}
+	}
+	dir = strings.TrimSuffix(dir, "/")
+	for _, i := range missing {
+		want := strings.Split(paths[i], "/")
+		var results []string
+		err := withRetry(ctx, "query", transientDB, func() (err error) {
+			results, err = lookup(stmt, "/"+want[len(want)-1])
+			return err
+		})
+		if err != nil {
+			return "", nil, false, err
+		}
+		var best []string
+		bestDir, bestScore := "", -1
+	candidates:
+		for _, fullpath := range results {
+			parts := strings.Split(fullpath, "/")
+			if excluded(fullpath) || len(parts) <= len(want) || !strings.EqualFold(parts[len(parts)-1], want[len(want)-1]) {
+				continue
+			}
+			have := parts[len(parts)-len(want):]
+			d := strings.Join(parts[:len(parts)-len(want)], "/")
+			if fixed && d != dir {
+				continue
+			}
+			for k := range want {
+				if n, ok := names[strings.Join(want[:k+1], "/")]; ok && n != have[k] {
+					continue candidates
+				}
+			}
+			if score := sharedSuffix(parts, want); score > bestScore {
+				best, bestDir, bestScore = have, d, score
+			}
+		}
+		if best == nil {
+			return "", nil, false, nil
+		}
+		dir, fixed = bestDir, true
+		for k := range want {
+			names[strings.Join(want[:k+1], "/")] = best[k]
+		}
+	}
+	var renames []rename
+	for p, name := range names {
+		if name != p[strings.LastIndex(p, "/")+1:] {
+			renames = append(renames, rename{p, name})
+		}
+	}
+	// deepest first, so each path still has its parents' old names
+	sort.Slice(renames, func(i, j int) bool {
+		di, dj := strings.Count(renames[i].path, "/"), strings.Count(renames[j].path, "/")
+		if di != dj {
+			return di > dj
+		}
+		return renames[i].path < renames[j].path
+	})
+	return dir + "/", renames, true, nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// failures of each kind listed in the end-of-run summary
+const maxLoggedFailures = 10
+
+// report accumulates the outcome of a run for the end-of-run summary.
+type report struct {
+	mu sync.Mutex
+
+	Start time.Time `json:"start"`
+	// distinct torrents read from the input
+	Scanned int `json:"scanned"`
+	// torrents with the info hash of one already read; only the first is
+	// matched
+	InputDuplicates int `json:"input_duplicates,omitempty"`
+	Matched         int `json:"matched"`
+	// already in a client, or recorded as added in the state DB
+	Present int `json:"present"`
+	// present in a client under a download dir other than the matched one
+	Misplaced []*misplacedTorrent `json:"misplaced,omitempty"`
+	// no DB match at all
+	Unmatched int `json:"unmatched"`
+	// the unmatched torrents, in input order
+	UnmatchedTorrents []string `json:"unmatched_torrents,omitempty"`
+	// every DB match was excluded by the filters, or the torrent by the
+	// tracker filters
+	Excluded int `json:"excluded"`
+	// torrents not matched for being mostly archives
+	Packed []*packedTorrent `json:"packed,omitempty"`
+	// torrents whose files were found under more than one dir
+	Split []*splitTorrent `json:"split,omitempty"`
+	// torrents matching the same data, and those of them not added for it
+	CrossSeeds  []*crossSeedSet `json:"cross_seeds,omitempty"`
+	CrossSeeded int             `json:"cross_seeded,omitempty"`
+	// the set of each data file matched, by path
+	crossData map[string]*crossSeedSet
+	// fuzzy matches held back for lack of --accept-fuzzy or --review;
+	// also counted as unmatched
+	Fuzzy      int `json:"fuzzy,omitempty"`
+	Added      int `json:"added"`
+	Duplicates int `json:"duplicates"`
+	// added with missing files marked unwanted
+	Partial int `json:"partial"`
+	// with --verify-threshold, added torrents the clients verified, those
+	// that verified below the threshold, and those of them removed
+	Verified   int `json:"verified,omitempty"`
+	BadMatches int `json:"bad_matches,omitempty"`
+	RolledBack int `json:"rolled_back,omitempty"`
+	// torrent-rename-path calls made to line added torrents up with the
+	// DB's names
+	Renamed int `json:"renamed,omitempty"`
+	// failures by category
+	Errors map[string]int `json:"errors"`
+	// RPC failures by errClass
+	RPCErrors map[string]int   `json:"rpc_errors"`
+	Failures  []*pipelineError `json:"failures,omitempty"`
+	// what became of each match the adder handled
+	Matches []*matchOutcome `json:"matches,omitempty"`
+	// counts by the host of each torrent's first tracker
+	Trackers map[string]*trackerCounts `json:"trackers,omitempty"`
+	Hooks    []*hookResult             `json:"hooks,omitempty"`
+	// how long each pipeline channel held up its senders
+	Queues []*queueStats `json:"queues,omitempty"`
+	// the run was cut short by a signal or, with Deadline, by --deadline
+	Interrupted bool `json:"interrupted,omitempty"`
+	Deadline    bool `json:"deadline,omitempty"`
+	// matches not added because of the interruption
+	Skipped int `json:"skipped,omitempty"`
+	// torrents read but not matched because of the interruption
+	Unprocessed int `json:"unprocessed,omitempty"`
+	// adds written to the --emit-script script instead of made
+	Scripted int `json:"scripted,omitempty"`
+	// matches written to --resume-dir instead of added
+	Resumed int `json:"resumed,omitempty"`
+	// adds recorded instead of made, with --offline
+	Offline int `json:"offline,omitempty"`
+	// matches over --max-add, left for the next run
+	Deferred int `json:"deferred,omitempty"`
+}
+
+// matchOutcome is a match and what became of it, one of the outcome*
+// constants.
+type matchOutcome struct {
+	Torrent     string `json:"torrent"`
+	InfoHash    string `json:"info_hash"`
+	DownloadDir string `json:"download_dir"`
+	DataDir     string `json:"data_dir"`
+	Outcome     string `json:"outcome"`
+	Private     bool   `json:"private,omitempty"`
+}
+
+// trackerCounts are a tracker's torrents scanned, matched, added and left
+// unmatched. Matched counts the matches the adder handled.
+type trackerCounts struct {
+	Scanned   int `json:"scanned"`
+	Matched   int `json:"matched"`
+	Added     int `json:"added"`
+	Unmatched int `json:"unmatched"`
+}
+
+func newReport() *report {
+	return &report{
+		Start:     time.Now(),
+		Errors:    make(map[string]int),
+		RPCErrors: make(map[string]int),
+	}
+}
+
+// error categories
+const (
+	errInput = "input"
+	errParse = "parse"
+	errFetch = "fetch"
+	errFeed  = "feed"
+	errSpace = "space"
+	errLink  = "link"
+	// with --mark-data, a data dir couldn't be marked
+	errMark = "mark"
+	// the data doesn't hash to the torrent's pieces
+	errVerify = "verify"
+	// with --stat-sizes, no candidate dir has the files at their sizes
+	errSize = "size"
+	// the matched data is gone from disk
+	errStale = "stale"
+	errQuery = "query"
+	errRPC   = "rpc"
+	errState = "state"
+)
+
+// pipelineError is a failure to process one torrent. Pipeline stages send
+// these to the report instead of stopping the run.
+type pipelineError struct {
+	Kind    string `json:"kind"`
+	Torrent string `json:"torrent,omitempty"`
+	Error   string `json:"error"`
+
+	err error
+}
+
+func failure(kind, torrent string, err error) *pipelineError {
+	return &pipelineError{Kind: kind, Torrent: torrent, Error: err.Error(), err: err}
+}
+
+// matchFailure categorizes an error from newMatch.
+func matchFailure(torrent string, err error) *pipelineError {
+	var pe *metainfo.ParseError
+	if errors.As(err, &pe) {
+		return failure(errParse, torrent, err)
+	}
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return failure(errFetch, torrent, err)
+	}
+	return failure(errQuery, torrent, err)
+}
+
+// collect records failures from errc until it is closed.
+func (r *report) collect(errc <-chan *pipelineError, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for e := range errc {
+		if e.Torrent != "" {
+			slog.Error("failed", "kind", e.Kind, "torrent", e.Torrent, "err", e.err)
+		} else {
+			slog.Error("failed", "kind", e.Kind, "err", e.err)
+		}
+		r.mu.Lock()
+		r.Errors[e.Kind]++
+		if e.Kind == errRPC {
+			r.RPCErrors[client.ErrClass(e.err)]++
+		}
+		r.Failures = append(r.Failures, e)
+		r.mu.Unlock()
+		events.failed(e)
+		cfg.Hooks.failed(e, r)
+	}
+}
+
+// count adds n to the counter at *field.
+func (r *report) count(field *int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*field += n
+}
+
+func (r *report) outcome(match *matchedFile, outcome string) {
+	markProgress()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Matches = append(r.Matches, &matchOutcome{match.tor, match.infoHash, match.path, match.dataDir, outcome, match.private})
+	if len(match.split) > 0 {
+		r.Split = append(r.Split, &splitTorrent{match.tor, match.split, len(match.links) > 0})
+	}
+	switch outcome {
+	case outcomeAdded, outcomePresent, outcomeDuplicate, outcomeScripted, outcomeResumed, outcomeOffline:
+		r.holdsData(match)
+	}
+	if match.tracker != "" {
+		c := r.trackerStats(match.tracker)
+		c.Matched++
+		if outcome == outcomeAdded {
+			c.Added++
+		}
+	}
+}
+
+// tracker adds to host's counts with f.
+func (r *report) tracker(host string, f func(c *trackerCounts)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r.trackerStats(host))
+}
+
+// trackerStats returns host's counts; r.mu must be held.
+func (r *report) trackerStats(host string) *trackerCounts {
+	if r.Trackers == nil {
+		r.Trackers = make(map[string]*trackerCounts)
+	}
+	c := r.Trackers[host]
+	if c == nil {
+		c = &trackerCounts{}
+		r.Trackers[host] = c
+	}
+	return c
+}
+
+func (r *report) misplaced(m *misplacedTorrent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Misplaced = append(r.Misplaced, m)
+}
+
+// relocated counts the misplaced torrents whose location was fixed. r.mu
+// must be held.
+func (r *report) relocated() int {
+	n := 0
+	for _, m := range r.Misplaced {
+		if m.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *report) added() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Added++
+}
+
+func (r *report) partial() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Partial++
+}
+
+func (r *report) duplicate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duplicates++
+}
+
+func (r *report) skipped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped++
+}
+
+func (r *report) interrupted(deadline bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Interrupted = true
+	r.Deadline = deadline
+}
+
+func (r *report) hook(res *hookResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Hooks = append(r.Hooks, res)
+}
+
+// hookFailures counts failed hook runs; r.mu must be held.
+func (r *report) hookFailures() int {
+	failed := 0
+	for _, h := range r.Hooks {
+		if h.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ok reports whether the run had no failures; r.mu must be held.
+func (r *report) ok() bool {
+	return len(r.Errors) == 0 && r.hookFailures() == 0 && !r.Interrupted
+}
+
+// exitCode maps the outcome of the run to the process exit status. RPC
+// failures take precedence over other failures, and any failure over
+// unmatched torrents.
+func (r *report) exitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.Interrupted:
+		return exitInterrupted
+	case r.Errors[errRPC] > 0:
+		return exitRPC
+	case len(r.Errors) > 0 || r.hookFailures() > 0 || r.BadMatches > 0:
+		return exitFailed
+	case r.Unmatched > 0:
+		return exitUnmatched
+	}
+	return exitOK
+}
+
+func (r *report) log() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slog.Info("summary",
+		"scanned", r.Scanned,
+		"input_duplicates", r.InputDuplicates,
+		"matched", r.Matched,
+		"present", r.Present,
+		"misplaced", len(r.Misplaced),
+		"relocated", r.relocated(),
+		"unmatched", r.Unmatched,
+		"excluded", r.Excluded,
+		"packed", len(r.Packed),
+		"split", len(r.Split),
+		"cross_seeds", len(r.CrossSeeds),
+		"cross_seeded", r.CrossSeeded,
+		"fuzzy", r.Fuzzy,
+		"added", r.Added,
+		"partial", r.Partial,
+		"renamed", r.Renamed,
+		"duplicates", r.Duplicates,
+		"verified", r.Verified,
+		"bad_matches", r.BadMatches,
+		"rolled_back", r.RolledBack,
+		"deferred", r.Deferred,
+		"scripted", r.Scripted,
+		"resumed", r.Resumed,
+		"offline", r.Offline,
+		"failed", len(r.Failures),
+	)
+	for _, kind := range sortedKeys(r.Errors) {
+		slog.Warn("errors", "kind", kind, "count", r.Errors[kind])
+		if kind == errRPC {
+			for _, class := range sortedKeys(r.RPCErrors) {
+				slog.Warn("rpc errors", "class", class, "count", r.RPCErrors[class])
+			}
+		}
+		shown := 0
+		for _, e := range r.Failures {
+			if e.Kind != kind {
+				continue
+			}
+			if shown == maxLoggedFailures {
+				slog.Warn("more failures omitted", "kind", kind, "count", r.Errors[kind]-shown)
+				break
+			}
+			shown++
+			slog.Warn("failure", "kind", kind, "torrent", e.Torrent, "err", e.Error)
+		}
+	}
+	if r.Interrupted {
+		slog.Warn("interrupted", "deadline", r.Deadline, "skipped", r.Skipped, "unprocessed", r.Unprocessed)
+	}
+	for _, q := range r.Queues {
+		slog.Info("queue", "name", q.Name, "capacity", q.Capacity, "sends", q.Sends, "max_queued", q.MaxQueued,
+			"blocked", time.Duration(q.BlockedSeconds*float64(time.Second)).Round(time.Millisecond))
+	}
+	if len(r.Hooks) > 0 {
+		slog.Info("hooks", "ran", len(r.Hooks), "failed", r.hookFailures())
+	}
+}
+
+// summary describes the run in a few lines, for notifications.
+func (r *report) summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "scanned %d, matched %d, already present %d, unmatched %d, excluded %d\n",
+		r.Scanned, r.Matched, r.Present, r.Unmatched, r.Excluded)
+	fmt.Fprintf(&b, "added %d (%d partial), %d duplicates\n", r.Added, r.Partial, r.Duplicates)
+	if r.BadMatches > 0 {
+		fmt.Fprintf(&b, "%d bad matches of %d verified, %d rolled back\n", r.BadMatches, r.Verified, r.RolledBack)
+	}
+	if len(r.Packed) > 0 {
+		fmt.Fprintf(&b, "%d packed in archives, not matched\n", len(r.Packed))
+	}
+	if len(r.Misplaced) > 0 {
+		fmt.Fprintf(&b, "%d present under another download dir, %d relocated\n", len(r.Misplaced), r.relocated())
+	}
+	if len(r.Split) > 0 {
+		fmt.Fprintf(&b, "%d split across dirs\n", len(r.Split))
+	}
+	if len(r.CrossSeeds) > 0 {
+		fmt.Fprintf(&b, "%d cross-seed sets, %d not added for sharing data\n", len(r.CrossSeeds), r.CrossSeeded)
+	}
+	if r.Deferred > 0 {
+		fmt.Fprintf(&b, "%d over --max-add, carried over\n", r.Deferred)
+	}
+	if r.Offline > 0 {
+		fmt.Fprintf(&b, "%d recorded offline, not added\n", r.Offline)
+	}
+	for _, kind := range sortedKeys(r.Errors) {
+		fmt.Fprintf(&b, "%d %s failures\n", r.Errors[kind], kind)
+	}
+	if n := r.hookFailures(); n > 0 {
+		fmt.Fprintf(&b, "%d hook failures\n", n)
+	}
+	switch {
+	case r.Deadline:
+		fmt.Fprintf(&b, "deadline reached; %d matches skipped, %d torrents unprocessed\n", r.Skipped, r.Unprocessed)
+	case r.Interrupted:
+		fmt.Fprintf(&b, "interrupted; %d matches skipped, %d torrents unprocessed\n", r.Skipped, r.Unprocessed)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *report) writeJSON(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
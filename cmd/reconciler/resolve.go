@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// How to pick among several DB directories holding a torrent's contained
+// file: "first" is the first row, "largest" and "newest" compare the file
+// itself as this host sees it, "most-complete" counts the torrent's files
+// under each, and "interactive" asks on the terminal.
+var resolve string
+
+func checkResolve() error {
+	switch resolve {
+	case "first", "largest", "newest", "most-complete", "interactive":
+		return nil
+	}
+	return fmt.Errorf("invalid --resolve %q", resolve)
+}
+
+// resolveCandidates returns the index in dirs of the directory to use for
+// tf, or -1 to skip it.
+func resolveCandidates(ctx context.Context, tf *torFile, dirs []string, existsStmt *sql.Stmt) (int, error) {
+	if len(dirs) == 1 {
+		return 0, nil
+	}
+	switch resolve {
+	case "largest", "newest":
+		ti, err := tf.meta(ctx)
+		if err != nil {
+			return 0, err
+		}
+		best, bestValue := 0, int64(-1)
+		for i, dir := range dirs {
+			fi, err := os.Stat(names().ContainedPath(ti, dir, tf.file, tf.size))
+			if err != nil {
+				continue
+			}
+			v := fi.Size()
+			if resolve == "newest" {
+				v = fi.ModTime().UnixNano()
+			}
+			if v > bestValue {
+				best, bestValue = i, v
+			}
+		}
+		return best, nil
+	case "most-complete":
+		// a magnet has no file list to count
+		if isMagnet(tf.tor) {
+			return 0, nil
+		}
+		ti, err := tf.meta(ctx)
+		if err != nil {
+			return 0, err
+		}
+		best, fewest := 0, len(ti.Files)+1
+		for i, dir := range dirs {
+			missing, err := missingFiles(ctx, existsStmt, dir, ti.Paths())
+			if err != nil {
+				return 0, err
+			}
+			if len(missing) < fewest {
+				best, fewest = i, len(missing)
+			}
+		}
+		return best, nil
+	case "interactive":
+		return askCandidate(tf, dirs)
+	}
+	return 0, nil
+}
+
+// askCandidate has the user pick one of dirs on the terminal.
+func askCandidate(tf *torFile, dirs []string) (int, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return 0, fmt.Errorf("--resolve=interactive needs a terminal: %v", err)
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "%s: %s is in %d places:\n", tf.tor, tf.file, len(dirs))
+	for i, dir := range dirs {
+		fmt.Fprintf(tty, "  %d) %s\n", i+1, dir)
+	}
+	in := bufio.NewReader(tty)
+	for {
+		fmt.Fprintf(tty, "use [1-%d, s to skip]: ", len(dirs))
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "s" {
+			return -1, nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(dirs) {
+			return n - 1, nil
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// With --record-matches, the outcome of every match a run tries to add is
+// written to the reconciler_matches table of the catalog DB, one row per
+// match and run, for auditing and comparing runs.
+var recordMatches bool
+
+// match outcomes in reconciler_matches
+const (
+	outcomeAdded     = "added"
+	outcomePresent   = "present"
+	outcomeDuplicate = "duplicate"
+	outcomeFailed    = "failed"
+	// a pre-add hook refused it
+	outcomeRejected = "rejected"
+	outcomeDeferred = "deferred"
+	outcomeScripted = "scripted"
+	// written to --resume-dir
+	outcomeResumed = "resumed"
+	// recorded instead of added, with --offline
+	outcomeOffline = "offline"
+	// interrupted before the add
+	outcomeSkipped = "skipped"
+	// matching another torrent's data, without --cross-seed
+	outcomeCrossSeed = "cross-seed"
+)
+
+const resultsSchema = `
+create table if not exists reconciler_matches (
+	run integer not null,
+	time integer not null,
+	torrent text not null,
+	info_hash text not null,
+	download_dir text not null,
+	data_dir text not null,
+	file text not null,
+	confidence text not null,
+	outcome text not null
+);
+create index if not exists reconciler_matches_torrent on reconciler_matches (torrent);
+`
+
+// matchResults writes to reconciler_matches. A nil *matchResults, without
+// --record-matches, writes nothing.
+type matchResults struct {
+	db *sql.DB
+	// when the run started, in Unix seconds
+	run int64
+}
+
+// results is where the current run's outcomes go.
+var results *matchResults
+
+func openResults(db *sql.DB, start time.Time) (*matchResults, error) {
+	if !recordMatches {
+		return nil, nil
+	}
+	if _, err := db.Exec(resultsSchema); err != nil {
+		return nil, err
+	}
+	return &matchResults{db, start.Unix()}, nil
+}
+
+func (r *matchResults) record(match *matchedFile, outcome string) error {
+	if r == nil {
+		return nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := r.db.ExecContext(ctx, `insert into reconciler_matches
+		(run, time, torrent, info_hash, download_dir, data_dir, file, confidence, outcome)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.run, time.Now().Unix(), match.tor, match.infoHash, match.path, match.dataDir, match.file, match.confidence, outcome)
+	return err
+}
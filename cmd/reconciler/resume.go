@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// With verifyPieces, each match's data is hashed against the torrent's v1
+// piece hashes before it's added, and a match with a piece that doesn't
+// match isn't added. Pieces touching files missing from disk go unchecked.
+var verifyPieces bool
+
+// With --resume-dir, matches aren't added to the clients but written to
+// resumeDir with resume data marking their verified pieces complete, so the
+// client loading them skips its own recheck. The qbittorrent format writes
+// <hash>.torrent and <hash>.fastresume, to copy into BT_backup while
+// qBittorrent is stopped; rtorrent writes <hash>.torrent with
+// libtorrent_resume data, to load with d.directory.set to the download dir.
+var resumeDir string
+var resumeFormat string
+
+func checkResume() error {
+	switch resumeFormat {
+	case "qbittorrent", "rtorrent":
+	default:
+		return fmt.Errorf("invalid --resume-format %q", resumeFormat)
+	}
+	if verifyPieces && emitScript != "" {
+		return fmt.Errorf("--verify-pieces checks the data where it is; it can't be used with --emit-script")
+	}
+	if resumeDir != "" && !verifyPieces {
+		return fmt.Errorf("--resume-dir marks the data complete, so it needs --verify-pieces")
+	}
+	return nil
+}
+
+// dataFiles returns where this host has each of match's files, empty for
+// padding: in the link tree if it has one, and otherwise in its data dir,
+// under the names its renames give them.
+func dataFiles(ti *metainfo.Info, match *matchedFile) []string {
+	dir := match.dataDir
+	if len(match.links) > 0 {
+		dir = match.path
+	}
+	paths := ti.Paths()
+	for i, p := range paths {
+		if p != "" {
+			paths[i] = filepath.Join(dir, filepath.FromSlash(renamed(p, match.renames)))
+		}
+	}
+	return paths
+}
+
+// verifyMatch hashes match's data, returning which pieces verified.
+func verifyMatch(ctx context.Context, match *matchedFile) (*metainfo.Info, []bool, error) {
+	if isMagnet(match.tor) {
+		return nil, nil, errors.New("a magnet link has no piece hashes to verify")
+	}
+	ti, err := torrentMeta(ctx, match.tor)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ti.Pieces) == 0 || ti.PieceLength <= 0 {
+		return nil, nil, errors.New("no v1 piece hashes to verify")
+	}
+	have, err := hashPieces(ctx, ti, dataFiles(ti, match))
+	if err != nil {
+		return nil, nil, err
+	}
+	n := 0
+	for _, h := range have {
+		if h {
+			n++
+		}
+	}
+	slog.Info("verified pieces", "torrent", match.tor, "verified", n, "pieces", len(have))
+	return ti, have, nil
+}
+
+// hashPieces hashes each piece of ti's data, read from paths, and reports
+// which matched. A piece that can't be read in full, because a file is
+// missing or short, is left false; one that reads but doesn't match fails.
+func hashPieces(ctx context.Context, ti *metainfo.Info, paths []string) ([]bool, error) {
+	var total int64
+	for _, f := range ti.Files {
+		total += f.Length
+	}
+	n := len(ti.Pieces) / sha1.Size
+	if want := (total + ti.PieceLength - 1) / ti.PieceLength; int64(n) != want {
+		return nil, fmt.Errorf("%d piece hashes for %d pieces", n, want)
+	}
+	files := make([]*os.File, len(paths))
+	gone := make([]bool, len(paths))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	have := make([]bool, n)
+	buf := make([]byte, ti.PieceLength)
+	// the first file the piece overlaps, and where it starts
+	first, firstStart := 0, int64(0)
+	for p := range have {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		start := int64(p) * ti.PieceLength
+		end := start + ti.PieceLength
+		if end > total {
+			end = total
+		}
+		for first < len(ti.Files) && firstStart+ti.Files[first].Length <= start {
+			if files[first] != nil {
+				files[first].Close()
+				files[first] = nil
+			}
+			firstStart += ti.Files[first].Length
+			first++
+		}
+		piece := buf[:end-start]
+		ok := true
+		pos := start
+		for i, off := first, firstStart; ok && pos < end; i++ {
+			f := ti.Files[i]
+			stop := off + f.Length
+			if stop > end {
+				stop = end
+			}
+			chunk := piece[pos-start : stop-start]
+			switch {
+			case len(chunk) == 0:
+			case f.Pad:
+				for j := range chunk {
+					chunk[j] = 0
+				}
+			default:
+				if files[i] == nil && !gone[i] {
+					h, err := os.Open(paths[i])
+					if errors.Is(err, fs.ErrNotExist) {
+						gone[i] = true
+					} else if err != nil {
+						return nil, err
+					}
+					files[i] = h
+				}
+				if gone[i] {
+					ok = false
+					break
+				}
+				_, err := files[i].ReadAt(chunk, pos-off)
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					ok = false
+				} else if err != nil {
+					return nil, err
+				}
+			}
+			pos = stop
+			off += f.Length
+		}
+		if !ok {
+			continue
+		}
+		sum := sha1.Sum(piece)
+		if !bytes.Equal(sum[:], ti.Pieces[p*sha1.Size:(p+1)*sha1.Size]) {
+			return nil, fmt.Errorf("piece %d of %d doesn't match the data", p, n)
+		}
+		have[p] = true
+	}
+	return have, nil
+}
+
+// writeResume writes match's .torrent and resume data to resumeDir.
+func writeResume(ctx context.Context, match *matchedFile, ti *metainfo.Info, have []bool) error {
+	filename, err := localTorrent(ctx, match.tor)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(resumeDir, 0755); err != nil {
+		return err
+	}
+	base := filepath.Join(resumeDir, ti.InfoHash)
+	if resumeFormat == "rtorrent" {
+		data, err = rtorrentResume(data, match, ti, have)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(base+".torrent", data, 0644)
+	}
+	resume, err := fastresume(match, ti, have)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".torrent", data, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(base+".fastresume", resume, 0644)
+}
+
+// fastresume returns libtorrent resume data as qBittorrent keeps it.
+func fastresume(match *matchedFile, ti *metainfo.Info, have []bool) ([]byte, error) {
+	hash, err := hex.DecodeString(ti.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	pieces := make([]byte, len(have))
+	for i, h := range have {
+		if h {
+			pieces[i] = 1
+		}
+	}
+	// 4 is libtorrent's default priority
+	priorities := make([]interface{}, len(ti.Files))
+	for i, f := range ti.Files {
+		priorities[i] = 4
+		if f.Pad {
+			priorities[i] = 0
+		}
+	}
+	for _, i := range match.unwanted {
+		priorities[i] = 0
+	}
+	tags := make([]interface{}, len(match.labels))
+	for i, l := range match.labels {
+		tags[i] = l
+	}
+	paused := 0
+	if match.paused() {
+		paused = 1
+	}
+	r := map[string]interface{}{
+		"file-format":   "libtorrent resume file",
+		"file-version":  1,
+		"info-hash":     hash,
+		"name":          ti.Name,
+		"save_path":     clientPath(match.path),
+		"pieces":        pieces,
+		"file_priority": priorities,
+		"paused":        paused,
+		"auto_managed":  1 - paused,
+		"qBt-savePath":  clientPath(match.path),
+		"qBt-category":  "",
+		"qBt-tags":      tags,
+	}
+	if len(match.renames) > 0 {
+		mapped := make([]interface{}, len(ti.Files))
+		for i, f := range ti.Files {
+			mapped[i] = renamed(f.Path, match.renames)
+		}
+		r["mapped_files"] = mapped
+	}
+	var b bytes.Buffer
+	if err := metainfo.Encode(&b, r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// rtorrentResume returns the .torrent data with rTorrent's
+// libtorrent_resume dict added. The info dict is kept byte for byte.
+func rtorrentResume(data []byte, match *matchedFile, ti *metainfo.Info, have []bool) ([]byte, error) {
+	if len(match.renames) > 0 {
+		return nil, errors.New("rtorrent resume data can't rename files")
+	}
+	v, info, err := metainfo.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	top, ok := v.(map[string]interface{})
+	if !ok || info == nil {
+		return nil, errors.New("not a torrent")
+	}
+	top["info"] = metainfo.Raw(info)
+	unwanted := make(map[int]bool)
+	for _, i := range match.unwanted {
+		unwanted[i] = true
+	}
+	paths := dataFiles(ti, match)
+	files := make([]interface{}, len(ti.Files))
+	var off int64
+	for i, f := range ti.Files {
+		priority := 1
+		if unwanted[i] {
+			priority = 0
+		}
+		// rTorrent rechecks a file whose mtime differs
+		var mtime int64
+		if paths[i] != "" {
+			if fi, err := os.Stat(paths[i]); err == nil {
+				mtime = fi.ModTime().Unix()
+			}
+		}
+		completed := 0
+		if f.Length > 0 {
+			for p := off / ti.PieceLength; p <= (off+f.Length-1)/ti.PieceLength; p++ {
+				if have[p] {
+					completed++
+				}
+			}
+		}
+		files[i] = map[string]interface{}{"priority": priority, "mtime": mtime, "completed": completed}
+		off += f.Length
+	}
+	// the piece count when all are done, and otherwise the bits
+	var bitfield interface{} = int64(len(have))
+	for _, h := range have {
+		if !h {
+			bits := make([]byte, (len(have)+7)/8)
+			for p, h := range have {
+				if h {
+					bits[p/8] |= 0x80 >> (p % 8)
+				}
+			}
+			bitfield = bits
+			break
+		}
+	}
+	top["libtorrent_resume"] = map[string]interface{}{"bitfield": bitfield, "files": files}
+	var b bytes.Buffer
+	if err := metainfo.Encode(&b, top); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var retries int
+var retryBackoff time.Duration
+
+// fraction of each backoff interval added at random
+var retryJitter float64
+var retryFilePath string
+
+// Adds are limited to maxAdd per run, at least addInterval apart, so a
+// large run doesn't start thousands of verifications at once. Matches over
+// the limit go to the carry-over file, which the next run reads as input.
+var maxAdd int
+var addInterval time.Duration
+var carryOverPath string
+
+// With --prioritize, every match is held until matching is done and they
+// are added in its order, so that the ones --max-add lets through are worth
+// the most: size-desc the largest first, size-asc the smallest, and mtime
+// the ones fetched longest ago, most likely to be close to a tracker's
+// deadline for seeding, by the .torrent file's modification time or, for
+// a torrent not read from a file, its creation date. Ties keep the input
+// order.
+var prioritize string
+
+func checkPrioritize() error {
+	switch prioritize {
+	case "", "size-desc", "size-asc", "mtime":
+		return nil
+	}
+	return fmt.Errorf("--prioritize must be size-desc, size-asc or mtime")
+}
+
+// prioritized sorts matches by --prioritize.
+func prioritized(matches []*matchedFile) {
+	switch prioritize {
+	case "size-desc":
+		slices.SortStableFunc(matches, func(a, b *matchedFile) int { return cmp.Compare(b.size, a.size) })
+	case "size-asc":
+		slices.SortStableFunc(matches, func(a, b *matchedFile) int { return cmp.Compare(a.size, b.size) })
+	case "mtime":
+		// those without a time last
+		slices.SortStableFunc(matches, func(a, b *matchedFile) int {
+			if a.fetched.IsZero() != b.fetched.IsZero() {
+				if a.fetched.IsZero() {
+					return 1
+				}
+				return -1
+			}
+			return a.fetched.Compare(b.fetched)
+		})
+	}
+}
+
+// addWorkers adds run at once; each keeps addInterval between its own adds,
+// and they share maxAdd.
+var addWorkers int
+
+// addQuota counts the adds the workers have attempted against maxAdd.
+type addQuota struct {
+	mu sync.Mutex
+	n  int
+}
+
+// take claims an attempt, returning false if maxAdd is used up.
+func (q *addQuota) take() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if maxAdd > 0 && q.n >= maxAdd {
+		return false
+	}
+	q.n++
+	return true
+}
+
+// giveBack returns an attempt claimed for a match that wasn't tried after
+// all.
+func (q *addQuota) giveBack() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.n--
+}
+
+// waitAddInterval waits until addInterval has passed since last. It
+// returns false if ctx is done first.
+func waitAddInterval(ctx context.Context, last time.Time) bool {
+	wait := time.Until(last.Add(addInterval))
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// takeCarryOver moves aside what the last run left in the carry-over file
+// and returns the moved file's name to read as input, or "" if there is
+// none. The moved file is kept when a run is interrupted, and is read again
+// in place of the carry-over file the next time.
+func takeCarryOver() (string, error) {
+	if carryOverPath == "" {
+		return "", nil
+	}
+	return takeAside(carryOverPath)
+}
+
+// takeAside moves path to path.pending, unless an earlier path.pending was
+// left, and returns the .pending name, or "" if neither exists.
+func takeAside(path string) (string, error) {
+	pending := path + ".pending"
+	if _, err := os.Stat(pending); err == nil {
+		return pending, nil
+	}
+	err := os.Rename(path, pending)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return pending, nil
+}
+
+// withRetry calls f until it succeeds, fails with an error that transient
+// rejects, has been retried retries times, or ctx is done. The wait between
+// attempts starts at retryBackoff and doubles each time.
+func withRetry(ctx context.Context, what string, transient func(error) bool, f func() error) error {
+	delay := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= retries || !transient(err) {
+			return err
+		}
+		wait := delay + time.Duration(rand.Float64()*retryJitter*float64(delay))
+		slog.Warn("retrying", "op", what, "err", err, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// transientDB reports whether err is SQLite reporting a locked database.
+func transientDB(err error) bool {
+	var se sqlite3.Error
+	if errors.As(err, &se) {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// retryFile collects adds that failed after exhausting retries, or that
+// were over --max-add, written in the input mapping format so the file can
+// be passed to a later run.
+type retryFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openRetryFile opens path for appending. An empty path yields a nil
+// *retryFile, which discards everything.
+func openRetryFile(path string) (*retryFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &retryFile{f: f}, nil
+}
+
+func (r *retryFile) add(match *matchedFile) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if match.file == "" {
+		// a magnet matched by display name
+		_, err := fmt.Fprintf(r.f, "%s\n", match.tor)
+		return err
+	}
+	_, err := fmt.Fprintf(r.f, "%s\t%s\n", match.tor, match.file)
+	return err
+}
+
+func (r *retryFile) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// With --retry-queue, each add the client refused goes in a queue file,
+// with everything needed to add it again: the torrent, its hash, where its
+// data is, the add's options, the error and how many times it failed.
+// `reconciler retry` drains the queue, adding each entry again without
+// reading the input or the DB; those that fail again go back in the queue,
+// until they have failed --retry-attempts times.
+var retryQueuePath string
+var retryAttempts int
+
+// queueEntry is a line of the queue file.
+type queueEntry struct {
+	Torrent           string              `json:"torrent"`
+	File              string              `json:"file,omitempty"`
+	InfoHash          string              `json:"info_hash"`
+	InfoHashV2        string              `json:"info_hash_v2,omitempty"`
+	DownloadDir       string              `json:"download_dir"`
+	DataDir           string              `json:"data_dir,omitempty"`
+	DataFile          string              `json:"data_file,omitempty"`
+	Client            string              `json:"client,omitempty"`
+	Size              int64               `json:"size,omitempty"`
+	Unwanted          []int               `json:"unwanted,omitempty"`
+	Labels            []string            `json:"labels,omitempty"`
+	Private           bool                `json:"private,omitempty"`
+	Seed              *client.SeedOptions `json:"seed,omitempty"`
+	BandwidthPriority int                 `json:"bandwidth_priority,omitempty"`
+	PriorityHigh      []int               `json:"priority_high,omitempty"`
+	PriorityLow       []int               `json:"priority_low,omitempty"`
+	Renames           []queuedRename      `json:"renames,omitempty"`
+	Error             string              `json:"error"`
+	Attempts          int                 `json:"attempts"`
+	Failed            time.Time           `json:"failed"`
+}
+
+type queuedRename struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// retryQueue appends to the queue file. A nil *retryQueue, without
+// --retry-queue, queues nothing.
+type retryQueue struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// failedAdds is the queue of the run, or nil.
+var failedAdds *retryQueue
+
+func openRetryQueue() (*retryQueue, error) {
+	if retryQueuePath == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(retryQueuePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &retryQueue{f: f, enc: enc}, nil
+}
+
+func (q *retryQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	return q.f.Close()
+}
+
+// outcome queues match according to how its add turned out: a failed RPC
+// add, with addErr, is queued, and an entry taken from the queue goes back
+// in unless it was added, found present or rejected.
+func (q *retryQueue) outcome(match *matchedFile, outcome string, addErr error) {
+	if q == nil {
+		return
+	}
+	e := match.queued
+	switch {
+	case outcome == outcomeFailed && (addErr != nil || e != nil):
+		if e == nil {
+			e = newQueueEntry(match)
+		}
+		if addErr != nil {
+			e.Error = addErr.Error()
+		}
+		e.Attempts++
+		e.Failed = time.Now()
+		if retryAttempts > 0 && e.Attempts >= retryAttempts {
+			slog.Error("giving up on add", "torrent", match.tor, "attempts", e.Attempts, "err", e.Error)
+			return
+		}
+	case (outcome == outcomeSkipped || outcome == outcomeDeferred) && e != nil:
+		// not tried
+	default:
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.enc.Encode(e); err != nil {
+		slog.Error("writing retry queue", "err", err)
+	}
+}
+
+func newQueueEntry(match *matchedFile) *queueEntry {
+	e := &queueEntry{
+		Torrent:           match.tor,
+		File:              match.file,
+		InfoHash:          match.infoHash,
+		InfoHashV2:        match.infoHashV2,
+		DownloadDir:       match.path,
+		DataDir:           match.dataDir,
+		DataFile:          match.dataFile,
+		Client:            match.client,
+		Size:              match.size,
+		Unwanted:          match.unwanted,
+		Labels:            match.labels,
+		Private:           match.private,
+		Seed:              match.seed,
+		BandwidthPriority: match.bandwidthPriority,
+		PriorityHigh:      match.priorityHigh,
+		PriorityLow:       match.priorityLow,
+	}
+	for _, r := range match.renames {
+		e.Renames = append(e.Renames, queuedRename{r.path, r.name})
+	}
+	return e
+}
+
+// match returns the match to add e again.
+func (e *queueEntry) match() *matchedFile {
+	m := &matchedFile{
+		tor:               e.Torrent,
+		file:              e.File,
+		infoHash:          e.InfoHash,
+		infoHashV2:        e.InfoHashV2,
+		path:              e.DownloadDir,
+		dataDir:           e.DataDir,
+		dataFile:          e.DataFile,
+		client:            e.Client,
+		size:              e.Size,
+		unwanted:          e.Unwanted,
+		labels:            e.Labels,
+		private:           e.Private,
+		seed:              e.Seed,
+		bandwidthPriority: e.BandwidthPriority,
+		priorityHigh:      e.PriorityHigh,
+		priorityLow:       e.PriorityLow,
+		confidence:        "queued",
+		queued:            e,
+	}
+	for _, r := range e.Renames {
+		m.renames = append(m.renames, rename{r.Path, r.Name})
+	}
+	return m
+}
+
+func readRetryQueue(path string) ([]*queueEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*queueEntry
+	// a torrent that failed in several runs is queued once per run; the
+	// last entry is the one to keep, with the most attempts
+	seen := make(map[string]int)
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		e := &queueEntry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			// a line cut short by a crash
+			slog.Warn("skipping bad retry queue line", "file", path, "line", n, "err", err)
+			continue
+		}
+		if i, ok := seen[e.Torrent]; ok {
+			e.Attempts = max(e.Attempts, entries[i].Attempts)
+			entries[i] = e
+			continue
+		}
+		seen[e.Torrent] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// retryAdds is `reconciler retry`: it adds the queued entries again.
+func retryAdds(args []string) int {
+	if len(args) > 0 {
+		log.Fatal("retry takes no arguments")
+	}
+	if retryQueuePath == "" {
+		log.Fatal("retry needs --retry-queue")
+	}
+	if offline || emitScript != "" || resumeDir != "" {
+		log.Fatal("retry adds to the clients; it can't be used with --offline, --emit-script or --resume-dir")
+	}
+	if addWorkers < 1 {
+		log.Fatal("--add-workers must be at least 1")
+	}
+	for _, check := range []func() error{checkNotify, checkEvents, checkMail, checkFetchHeaders, checkRollback, checkPrioritize, checkMark, checkStatPaths} {
+		if err := check(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return lockFailed(err)
+	}
+	defer release()
+	ctx, cancel := withDeadline(ctx)
+	defer cancel()
+	notify = newNotifier()
+	mail = newMailer()
+	if events, err = openEvents(); err != nil {
+		log.Fatal(err)
+	}
+	defer events.Close()
+	rep, err := retryPass(ctx)
+	if err != nil {
+		notify.send("reconciler: retry failed", err.Error(), true)
+		mail.send("reconciler: retry failed", err.Error())
+		log.Fatal(err)
+	}
+	finishReport(rep)
+	notify.finished(rep)
+	mail.finished(rep)
+	return rep.exitCode()
+}
+
+// retryPass adds the entries of the queue file again, through the adders
+// of a normal run.
+func retryPass(ctx context.Context) (*report, error) {
+	// entries queued while this runs go in a new queue file
+	pending, err := takeAside(retryQueuePath)
+	if err != nil {
+		return nil, err
+	}
+	rep := newReport()
+	if pending == "" {
+		slog.Info("retry queue is empty", "file", retryQueuePath)
+		return rep, nil
+	}
+	entries, err := readRetryQueue(pending)
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := newClients()
+	if err != nil {
+		return nil, err
+	}
+	if err := clients.loadHashes(ctx); err != nil {
+		return nil, err
+	}
+	state, err := openState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	defer state.Close()
+	if failedAdds, err = openRetryQueue(); err != nil {
+		return nil, err
+	}
+	defer failedAdds.Close()
+	cleanup, err := openFetchDir()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	slog.Info("retrying queued adds", "entries", len(entries))
+	errc := make(chan *pipelineError)
+	eg := &sync.WaitGroup{}
+	eg.Add(1)
+	go rep.collect(errc, eg)
+	m := make(chan *matchedFile)
+	cg := &sync.WaitGroup{}
+	quota := &addQuota{}
+	verifies = newVerifyWatch()
+	for i := 0; i < addWorkers; i++ {
+		cg.Add(1)
+		go addTorrents(ctx, clients, state, m, rep, nil, nil, nil, quota, errc, cg)
+	}
+	matches := make([]*matchedFile, len(entries))
+	for i, e := range entries {
+		matches[i] = e.match()
+	}
+	prioritized(matches)
+	for _, match := range matches {
+		rep.count(&rep.Matched, 1)
+		// once ctx is done, the adders put the rest back in the queue
+		m <- match
+	}
+	close(m)
+	cg.Wait()
+	verifies.wait(ctx, state, rep, errc)
+	close(errc)
+	eg.Wait()
+	// what wasn't added is back in the queue
+	if err := os.Remove(pending); err != nil {
+		slog.Error("removing retried queue", "err", err)
+	}
+	if ctx.Err() != nil {
+		slog.Warn("interrupted; entries not retried are back in the queue")
+		rep.interrupted(ctx.Err() == context.DeadlineExceeded)
+	}
+	return rep, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// With --review, matches are collected and shown in a terminal UI where they
+// can be filtered, selected, and approved or rejected in bulk. Only approved
+// matches are added.
+var review bool
+
+const reviewHelp = "j/k move  space select  A select all  a approve  r reject  / filter  q done  ^C abort"
+
+type verdict int
+
+const (
+	verdictPending verdict = iota
+	verdictApproved
+	verdictRejected
+)
+
+type reviewItem struct {
+	match    *matchedFile
+	verdict  verdict
+	selected bool
+}
+
+type reviewUI struct {
+	items   []*reviewItem
+	filter  string
+	visible []*reviewItem
+	cursor  int
+	top     int
+	out     *bufio.Writer
+	width   int
+	height  int
+}
+
+// humanSize formats n bytes with a binary unit.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// fit pads or truncates s to exactly n runes.
+func fit(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		if n <= 1 {
+			return string(r[:n])
+		}
+		return string(r[:n-1]) + "…"
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
+
+func (ui *reviewUI) applyFilter() {
+	ui.visible = ui.visible[:0]
+	for _, it := range ui.items {
+		m := it.match
+		if ui.filter == "" ||
+			strings.Contains(strings.ToLower(m.tor), ui.filter) ||
+			strings.Contains(strings.ToLower(m.path), ui.filter) ||
+			strings.HasPrefix(m.infoHash, ui.filter) {
+			ui.visible = append(ui.visible, it)
+		}
+	}
+	ui.cursor, ui.top = 0, 0
+}
+
+// targets returns the selected visible items, or the one under the cursor if
+// none are selected.
+func (ui *reviewUI) targets() []*reviewItem {
+	var sel []*reviewItem
+	for _, it := range ui.visible {
+		if it.selected {
+			sel = append(sel, it)
+		}
+	}
+	if len(sel) == 0 && ui.cursor < len(ui.visible) {
+		sel = append(sel, ui.visible[ui.cursor])
+	}
+	return sel
+}
+
+func (ui *reviewUI) setVerdict(v verdict) {
+	for _, it := range ui.targets() {
+		it.verdict = v
+		it.selected = false
+	}
+}
+
+func (ui *reviewUI) rows() int {
+	// header, column titles, and help/prompt lines
+	if n := ui.height - 3; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (ui *reviewUI) move(delta int) {
+	ui.cursor += delta
+	if ui.cursor >= len(ui.visible) {
+		ui.cursor = len(ui.visible) - 1
+	}
+	if ui.cursor < 0 {
+		ui.cursor = 0
+	}
+	if ui.cursor < ui.top {
+		ui.top = ui.cursor
+	}
+	if ui.cursor >= ui.top+ui.rows() {
+		ui.top = ui.cursor - ui.rows() + 1
+	}
+}
+
+func (ui *reviewUI) draw(prompt string) {
+	w := ui.out
+	w.WriteString("\x1b[H\x1b[2J")
+	var nApproved, nRejected, nSelected int
+	for _, it := range ui.items {
+		switch it.verdict {
+		case verdictApproved:
+			nApproved++
+		case verdictRejected:
+			nRejected++
+		}
+		if it.selected {
+			nSelected++
+		}
+	}
+	header := fmt.Sprintf("%d matches, %d shown, %d selected, %d approved, %d rejected",
+		len(ui.items), len(ui.visible), nSelected, nApproved, nRejected)
+	if ui.filter != "" {
+		header += fmt.Sprintf("  filter: %q", ui.filter)
+	}
+	fmt.Fprintf(w, "\x1b[1m%s\x1b[0m\r\n", fit(header, ui.width))
+
+	// fixed columns: mark(4) hash(10) size(11) confidence(12); the rest is
+	// split between torrent and path
+	flex := ui.width - 4 - 10 - 11 - 12
+	if flex < 20 {
+		flex = 20
+	}
+	torW, pathW := flex*2/5, flex-flex*2/5
+	fmt.Fprintf(w, "\x1b[4m%s%s%s%s%s%s\x1b[0m\r\n", fit("", 4), fit("torrent", torW),
+		fit("hash", 10), fit("path", pathW), fit("size", 11), fit("confidence", 12))
+
+	for i := ui.top; i < len(ui.visible) && i < ui.top+ui.rows(); i++ {
+		it := ui.visible[i]
+		mark := []byte("    ")
+		if it.selected {
+			mark[0] = '*'
+		}
+		switch it.verdict {
+		case verdictApproved:
+			mark[1] = '+'
+		case verdictRejected:
+			mark[1] = '-'
+		}
+		m := it.match
+		line := string(mark) + fit(filepath.Base(m.tor), torW) + fit(m.infoHash, 10) +
+			fit(m.path, pathW) + fit(humanSize(m.size), 11) + fit(m.confidence, 12)
+		switch {
+		case i == ui.cursor:
+			fmt.Fprintf(w, "\x1b[7m%s\x1b[0m\r\n", line)
+		case it.verdict == verdictApproved:
+			fmt.Fprintf(w, "\x1b[32m%s\x1b[0m\r\n", line)
+		case it.verdict == verdictRejected:
+			fmt.Fprintf(w, "\x1b[31m%s\x1b[0m\r\n", line)
+		default:
+			fmt.Fprintf(w, "%s\r\n", line)
+		}
+	}
+	if prompt == "" {
+		prompt = reviewHelp
+	}
+	fmt.Fprintf(w, "\x1b[%d;1H%s", ui.height, fit(prompt, ui.width))
+	w.Flush()
+}
+
+// reviewMatches shows matches on the terminal and returns the approved ones.
+// ok is false if the user aborted the review.
+func reviewMatches(matches []*matchedFile) (approvedMatches []*matchedFile, ok bool, err error) {
+	if len(matches) == 0 {
+		return nil, true, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, false, err
+	}
+	defer term.Restore(fd, state)
+
+	ui := &reviewUI{out: bufio.NewWriter(tty)}
+	for _, m := range matches {
+		ui.items = append(ui.items, &reviewItem{match: m})
+	}
+	ui.applyFilter()
+	in := bufio.NewReader(tty)
+	// alternate screen, hidden cursor
+	ui.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		ui.out.WriteString("\x1b[?25h\x1b[?1049l")
+		ui.out.Flush()
+	}()
+
+	filtering := false
+	var filter []rune
+	for {
+		ui.width, ui.height, err = term.GetSize(fd)
+		if err != nil {
+			ui.width, ui.height = 80, 24
+		}
+		if filtering {
+			ui.draw("/" + string(filter))
+		} else {
+			ui.draw("")
+		}
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return nil, false, err
+		}
+		if filtering {
+			switch r {
+			case '\r', '\n':
+				filtering = false
+				ui.filter = strings.ToLower(string(filter))
+				ui.applyFilter()
+			case 0x1b:
+				filtering = false
+			case 0x7f, 0x08:
+				if len(filter) > 0 {
+					filter = filter[:len(filter)-1]
+				}
+			case 0x03:
+				return nil, false, nil
+			default:
+				if r >= ' ' {
+					filter = append(filter, r)
+				}
+			}
+			continue
+		}
+		switch r {
+		case 0x03:
+			return nil, false, nil
+		case 'q', '\r', '\n':
+			for _, it := range ui.items {
+				if it.verdict == verdictApproved {
+					approvedMatches = append(approvedMatches, it.match)
+				}
+			}
+			return approvedMatches, true, nil
+		case 'j':
+			ui.move(1)
+		case 'k':
+			ui.move(-1)
+		case ' ':
+			if ui.cursor < len(ui.visible) {
+				it := ui.visible[ui.cursor]
+				it.selected = !it.selected
+				ui.move(1)
+			}
+		case 'A':
+			all := true
+			for _, it := range ui.visible {
+				all = all && it.selected
+			}
+			for _, it := range ui.visible {
+				it.selected = !all
+			}
+		case 'a':
+			ui.setVerdict(verdictApproved)
+		case 'r':
+			ui.setVerdict(verdictRejected)
+		case '/':
+			filtering = true
+			filter = []rune(ui.filter)
+		case 0x1b:
+			// arrow keys and page up/down: ESC [ A, ESC [ B, ESC [ 5 ~, ESC [ 6 ~;
+			// a sequence arrives in one read, so a lone ESC isn't followed by
+			// anything buffered
+			if in.Buffered() == 0 {
+				continue
+			}
+			if b, _ := in.ReadByte(); b != '[' {
+				continue
+			}
+			b, _ := in.ReadByte()
+			switch b {
+			case 'A':
+				ui.move(-1)
+			case 'B':
+				ui.move(1)
+			case '5', '6':
+				in.ReadByte()
+				if b == '5' {
+					ui.move(-ui.rows())
+				} else {
+					ui.move(ui.rows())
+				}
+			}
+		}
+	}
+}
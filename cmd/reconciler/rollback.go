@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// With --verify-threshold, a run waits, up to --verify-timeout, for the
+// clients to verify the torrents it added, and a torrent found to have less
+// than that fraction of its wanted data is a bad match: the data matched
+// wasn't the torrent's. Bad matches are logged and counted; with
+// --rollback, they're also removed from the client, keeping the data, and
+// recorded as bad in the state DB so later runs leave them alone.
+//
+// Transmission queues a torrent added over existing data for verification
+// as it adds it, so one neither checking nor waiting to be checked is done.
+var verifyThreshold float64
+var verifyTimeout time.Duration
+var rollback bool
+
+// how often added torrents' verification is polled
+const verifyPoll = 5 * time.Second
+
+func checkRollback() error {
+	if verifyThreshold < 0 || verifyThreshold > 1 {
+		return fmt.Errorf("--verify-threshold must be between 0 and 1")
+	}
+	if rollback && verifyThreshold == 0 {
+		return fmt.Errorf("--rollback needs --verify-threshold")
+	}
+	return nil
+}
+
+type addedTorrent struct {
+	e     *endpoint
+	id    int
+	name  string
+	match *matchedFile
+}
+
+// verifyWatch collects the torrents a run added, to wait for their
+// verification. A nil *verifyWatch, without --verify-threshold, collects
+// nothing.
+type verifyWatch struct {
+	mu    sync.Mutex
+	added []*addedTorrent
+}
+
+var verifies *verifyWatch
+
+func newVerifyWatch() *verifyWatch {
+	if verifyThreshold == 0 || offline || emitScript != "" || resumeDir != "" {
+		return nil
+	}
+	return &verifyWatch{}
+}
+
+func (w *verifyWatch) add(e *endpoint, t client.Torrent, match *matchedFile) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.added = append(w.added, &addedTorrent{e, t.ID, t.Name, match})
+}
+
+// wait polls the added torrents until each is verified, --verify-timeout
+// passes or ctx is done, and handles the bad matches.
+func (w *verifyWatch) wait(ctx context.Context, state *stateDB, rep *report, errc chan<- *pipelineError) {
+	if w == nil || len(w.added) == 0 {
+		return
+	}
+	pending := make(map[*endpoint]map[int]*addedTorrent)
+	for _, a := range w.added {
+		if pending[a.e] == nil {
+			pending[a.e] = make(map[int]*addedTorrent)
+		}
+		pending[a.e][a.id] = a
+	}
+	slog.Info("waiting for verification", "torrents", len(w.added), "timeout", verifyTimeout)
+	timeout := time.After(verifyTimeout)
+	for {
+		// waiting isn't being stuck
+		markProgress()
+		for e, torrents := range pending {
+			ids := make([]int, 0, len(torrents))
+			for id := range torrents {
+				ids = append(ids, id)
+			}
+			ps, err := e.rpc.Progress(ctx, ids)
+			if err != nil {
+				slog.Warn("polling verification", "client", e.name, "err", err)
+				continue
+			}
+			listed := make(map[int]bool)
+			for _, p := range ps {
+				listed[p.ID] = true
+				a := torrents[p.ID]
+				if a == nil || p.Checking() {
+					continue
+				}
+				delete(torrents, p.ID)
+				rep.count(&rep.Verified, 1)
+				if p.PercentDone < verifyThreshold {
+					w.bad(ctx, a, p.PercentDone, state, rep, errc)
+				}
+			}
+			for id := range torrents {
+				if !listed[id] {
+					slog.Info("removed before it was verified", "name", torrents[id].name, "client", e.name)
+					delete(torrents, id)
+				}
+			}
+			if len(torrents) == 0 {
+				delete(pending, e)
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		select {
+		case <-time.After(verifyPoll):
+		case <-ctx.Done():
+			slog.Warn("interrupted; not waiting for verification")
+			return
+		case <-timeout:
+			for e, torrents := range pending {
+				for _, a := range torrents {
+					slog.Warn("not verified within --verify-timeout", "torrent", a.match.tor, "name", a.name, "client", e.name)
+				}
+			}
+			return
+		}
+	}
+}
+
+// bad reports a, which verified with only the fraction have of its data,
+// and with --rollback removes it.
+func (w *verifyWatch) bad(ctx context.Context, a *addedTorrent, have float64, state *stateDB, rep *report, errc chan<- *pipelineError) {
+	slog.Warn("bad match", "torrent", a.match.tor, "name", a.name, "client", a.e.name, "verified", fmt.Sprintf("%.1f%%", 100*have), "dir", a.match.path)
+	rep.count(&rep.BadMatches, 1)
+	if !rollback {
+		return
+	}
+	err := withRetry(ctx, "remove", client.Transient, func() error {
+		return a.e.rpc.Remove(context.WithoutCancel(ctx), a.id)
+	})
+	if err != nil {
+		errc <- failure(errRPC, a.match.tor, fmt.Errorf("rolling back bad match: %w", err))
+		return
+	}
+	slog.Info("rolled back", "torrent", a.match.tor, "name", a.name, "client", a.e.name)
+	rep.count(&rep.RolledBack, 1)
+	if err := state.record(a.match, stageBad); err != nil {
+		errc <- failure(errState, a.match.tor, err)
+	}
+}
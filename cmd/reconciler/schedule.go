@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --schedule, daemon also runs a pass at each time a cron expression
+// matches, in local time: five fields of minute, hour, day of month, month
+// and day of week, each *, a number, a range a-b, a list of them, any with a
+// /step, and names for months and days of the week; or @hourly, @daily,
+// @weekly, @monthly or @yearly. As in cron, when both day fields are
+// restricted, a day matching either is taken. With --interval 0, passes run
+// only on schedule. A scheduled time that comes while a pass runs starts
+// another as soon as it's done.
+var schedules cronList
+
+// cronSchedule is a parsed cron expression; each field has bit n set for
+// the value n.
+type cronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	s := &cronSchedule{expr: expr}
+	spec := strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("want 5 fields, or @hourly, @daily, @weekly, @monthly or @yearly")
+	}
+	var err error
+	if s.minute, err = cronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = cronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = cronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = cronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	// 7 is Sunday too
+	if s.dow, err = cronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// cronField parses a field of values from lo to hi; names, if any, name
+// the values from lo on.
+func cronField(field string, lo, hi int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+		}
+		first, last := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = cronValue(a, lo, hi, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = cronValue(b, lo, hi, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = hi
+			}
+			if last < first {
+				return 0, fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, lo, hi int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return lo + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// next returns the first time after t the schedule matches, or the zero
+// time if it never does, as for February 30.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// cronList is a flag.Value parsing each occurrence of --schedule.
+type cronList []*cronSchedule
+
+func (l *cronList) String() string {
+	var s []string
+	for _, c := range *l {
+		s = append(s, c.expr)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (l *cronList) Set(expr string) error {
+	c, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, c)
+	return nil
+}
+
+// checkSchedule validates --interval and --schedule for daemon.
+func checkSchedule() error {
+	if daemonInterval < 0 || daemonInterval == 0 && len(schedules) == 0 {
+		return fmt.Errorf("--interval must be positive, or 0 with --schedule")
+	}
+	for _, c := range schedules {
+		if c.next(time.Now()).IsZero() {
+			return fmt.Errorf("--schedule %q never matches", c.expr)
+		}
+	}
+	return nil
+}
+
+// nextPass returns when the daemon's next pass is due: --interval after
+// end, when the last pass ended, or the first scheduled time after start,
+// when it started, whichever comes first.
+func nextPass(start, end time.Time) time.Time {
+	var next time.Time
+	if daemonInterval > 0 {
+		next = end.Add(daemonInterval)
+	}
+	for _, c := range schedules {
+		if t := c.next(start); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// bits returns a cron field with the values vs set.
+func bits(vs ...int) uint64 {
+	var b uint64
+	for _, v := range vs {
+		b |= 1 << v
+	}
+	return b
+}
+
+func TestParseCron(t *testing.T) {
+	for _, tt := range []struct {
+		expr                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"*/15 * * * *", bits(0, 15, 30, 45), bits(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23), 0xfffffffe, 0x1ffe, 0xff},
+		{"0 9-17/4 1,15 jan,Mar-may mon-fri", bits(0), bits(9, 13, 17), bits(1, 15), bits(1, 3, 4, 5), bits(1, 2, 3, 4, 5)},
+		{"5/20 0 * * 7", bits(5, 25, 45), bits(0), 0xfffffffe, 0x1ffe, bits(0, 7)},
+		{" @Daily ", bits(0), bits(0), 0xfffffffe, 0x1ffe, 0xff},
+		{"@weekly", bits(0), bits(0), 0xfffffffe, 0x1ffe, bits(0)},
+	} {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		got := [5]uint64{s.minute, s.hour, s.dom, s.month, s.dow}
+		if want := [5]uint64{tt.minute, tt.hour, tt.dom, tt.month, tt.dow}; got != want {
+			t.Errorf("%q: fields = %#x, want %#x", tt.expr, got, want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, tt := range []struct {
+		expr, want string
+	}{
+		{"* * * *", "want 5 fields"},
+		{"@often", "want 5 fields"},
+		{"60 * * * *", `minute: "60" is not between 0 and 59`},
+		{"* 24 * * *", "hour: "},
+		{"* * 0 * *", "day of month: "},
+		{"* * * foo *", `month: "foo" is not between 1 and 12`},
+		{"* * * * 8", "day of week: "},
+		{"*/0 * * * *", `bad step "0"`},
+		{"*/x * * * *", `bad step "x"`},
+		{"30-10 * * * *", `range "30-10" runs backwards`},
+		{"1,,2 * * * *", `"" is not between`},
+	} {
+		_, err := parseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: parseCron = %v, want an error containing %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	for _, tt := range []struct {
+		expr, from, want string
+	}{
+		{"30 2 * * *", "2024-03-10 01:00", "2024-03-10 02:30"},
+		// strictly after, and seconds are dropped
+		{"30 2 * * *", "2024-03-10 02:30", "2024-03-11 02:30"},
+		{"*/15 * * * *", "2024-03-10 23:59", "2024-03-11 00:00"},
+		{"@monthly", "2024-01-31 12:00", "2024-02-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 * * sun", "2024-09-02 00:00", "2024-09-08 12:00"},
+		{"0 12 * * 7", "2024-09-02 00:00", "2024-09-08 12:00"},
+		// both day fields restricted: either matches
+		{"0 0 13 * fri", "2024-09-01 00:00", "2024-09-06 00:00"},
+		{"0 0 13 * fri", "2024-09-06 00:00", "2024-09-13 00:00"},
+		// only one restricted: it alone decides
+		{"0 0 13 * *", "2024-09-01 00:00", "2024-09-13 00:00"},
+		{"0 0 * dec *", "2024-09-01 00:00", "2024-12-01 00:00"},
+		{"0 0 30 2 *", "2024-01-01 00:00", ""},
+	} {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.expr, err)
+		}
+		from := at(tt.from).Add(17 * time.Second)
+		got := s.next(from)
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q from %s: next = %s, want none", tt.expr, tt.from, got)
+			}
+			continue
+		}
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q from %s: next = %s, want %s", tt.expr, tt.from, got, want)
+		}
+	}
+}
+
+func TestNextPass(t *testing.T) {
+	defer func(i time.Duration, s cronList) { daemonInterval, schedules = i, s }(daemonInterval, schedules)
+	start := time.Date(2024, 9, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+	for _, tt := range []struct {
+		name     string
+		interval time.Duration
+		schedule []string
+		want     time.Time
+	}{
+		{"interval", time.Hour, nil, end.Add(time.Hour)},
+		{"schedule first", time.Hour, []string{"30 10 * * *"}, start.Add(30 * time.Minute)},
+		{"interval first", time.Hour, []string{"0 18 * * *"}, end.Add(time.Hour)},
+		// a time passed during the pass is due at once
+		{"passed during pass", time.Hour, []string{"5 10 * * *"}, start.Add(5 * time.Minute)},
+		{"schedule only", 0, []string{"0 18 * * *", "0 12 * * *"}, start.Add(2 * time.Hour)},
+	} {
+		daemonInterval, schedules = tt.interval, nil
+		for _, expr := range tt.schedule {
+			if err := schedules.Set(expr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := nextPass(start, end); !got.Equal(tt.want) {
+			t.Errorf("%s: nextPass = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
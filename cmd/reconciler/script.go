@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// With --emit-script, adds are written as shell commands to scriptPath
+// instead of being made, and the clients aren't contacted at all, so the
+// script can be run where the clients are reachable. The sh format uses
+// transmission-remote, and the curl format makes the RPC calls directly
+// with the .torrent files embedded. Credentials are taken from TR_AUTH
+// (user:password) when the script runs, never written to it. Hard links
+// for --link-dir become ln commands.
+var emitScript string
+var scriptPath string
+
+func checkEmitScript() error {
+	switch emitScript {
+	case "", "sh", "curl":
+		return nil
+	}
+	return fmt.Errorf("invalid --emit-script %q", emitScript)
+}
+
+// script writes the commands. A nil *script, without --emit-script,
+// writes nothing.
+type script struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+func openScript() (*script, error) {
+	if emitScript == "" {
+		return nil, nil
+	}
+	s := &script{w: os.Stdout}
+	if scriptPath != "-" {
+		f, err := os.OpenFile(scriptPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return nil, err
+		}
+		s.w, s.f = f, f
+	}
+	fmt.Fprintln(s.w, "#!/bin/sh")
+	fmt.Fprintln(s.w, "set -e")
+	if emitScript == "curl" {
+		// each call first fetches the session id from the 409 response
+		fmt.Fprintln(s.w, `rpc() {
+	sid=$(curl -s -o /dev/null -D - ${TR_AUTH:+--user "$TR_AUTH"} "$1" | tr -d '\r' | sed -n 's/^[Xx]-[Tt]ransmission-[Ss]ession-[Ii]d: *//p')
+	curl -sSf -H "X-Transmission-Session-Id: $sid" ${TR_AUTH:+--user "$TR_AUTH"} -d "$2" "$1"
+	echo
+}`)
+	}
+	return s, nil
+}
+
+func (s *script) Close() error {
+	if s == nil || s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// shQuote quotes s for the shell.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// add writes the commands adding match, whose .torrent or magnet link is
+// filename, to cl.
+func (s *script) add(cl *endpoint, match *matchedFile, filename string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n# %s\n", match.tor)
+	for _, l := range match.links {
+		fmt.Fprintf(&b, "mkdir -p %s && ln -f %s %s\n", shQuote(filepath.Dir(l.dst)), shQuote(l.src), shQuote(l.dst))
+	}
+	var err error
+	if emitScript == "curl" {
+		err = s.curlCommands(&b, cl, match, filename)
+	} else {
+		s.shCommands(&b, cl, match, filename)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = io.WriteString(s.w, b.String())
+	return err
+}
+
+func (s *script) shCommands(b *strings.Builder, cl *endpoint, match *matchedFile, filename string) {
+	// paused until the unwanted files and names are set
+	paused := len(match.unwanted) > 0 || len(match.renames) > 0 || match.paused()
+	tr := "transmission-remote " + shQuote(cl.url)
+	if cl.username != "" {
+		tr += " --authenv"
+	}
+	fmt.Fprintf(b, "%s --add %s --download-dir %s", tr, shQuote(filename), shQuote(clientPath(match.path)))
+	if paused {
+		b.WriteString(" --start-paused")
+	}
+	b.WriteString("\n")
+	t := tr + " -t " + match.infoHash
+	if p := remotePriorityArgs(match); p != "" {
+		fmt.Fprintf(b, "%s%s\n", t, p)
+	}
+	if len(match.unwanted) > 0 {
+		ids := make([]string, len(match.unwanted))
+		for i, u := range match.unwanted {
+			ids[i] = strconv.Itoa(u)
+		}
+		fmt.Fprintf(b, "%s --no-get %s\n", t, strings.Join(ids, ","))
+	}
+	if len(match.labels) > 0 {
+		fmt.Fprintf(b, "%s --labels %s\n", t, shQuote(strings.Join(match.labels, ",")))
+	}
+	if match.seed != nil {
+		fmt.Fprintf(b, "%s%s\n", t, remoteArgs(match.seed))
+	}
+	for _, r := range match.renames {
+		fmt.Fprintf(b, "%s --path %s --rename %s\n", t, shQuote(r.path), shQuote(r.name))
+	}
+	if len(match.renames) > 0 {
+		fmt.Fprintf(b, "%s --verify\n", t)
+	}
+	if paused && !match.paused() {
+		fmt.Fprintf(b, "%s --start\n", t)
+	}
+}
+
+func (s *script) curlCommands(b *strings.Builder, cl *endpoint, match *matchedFile, filename string) error {
+	args := client.AddArgs{AddOptions: &client.AddOptions{
+		DownloadDir:       clientPath(match.path),
+		FilesUnwanted:     match.unwanted,
+		Labels:            match.labels,
+		BandwidthPriority: match.bandwidthPriority,
+		PriorityHigh:      match.priorityHigh,
+		PriorityLow:       match.priorityLow,
+		Paused:            len(match.renames) > 0 || match.paused(),
+	}}
+	if isMagnet(filename) {
+		args.Filename = filename
+	} else {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		args.MetaInfo = base64.StdEncoding.EncodeToString(data)
+	}
+	calls := []client.Request{{Method: "torrent-add", Arguments: args}}
+	ids := []string{match.infoHash}
+	if match.seed != nil {
+		calls = append(calls, client.Request{Method: "torrent-set", Arguments: client.SetArgs{IDs: ids, SeedOptions: match.seed}})
+	}
+	for _, r := range match.renames {
+		calls = append(calls, client.Request{Method: "torrent-rename-path", Arguments: map[string]interface{}{"ids": ids, "path": r.path, "name": r.name}})
+	}
+	if len(match.renames) > 0 {
+		calls = append(calls, client.Request{Method: "torrent-verify", Arguments: map[string]interface{}{"ids": ids}})
+		if !match.paused() {
+			calls = append(calls, client.Request{Method: "torrent-start", Arguments: map[string]interface{}{"ids": ids}})
+		}
+	}
+	for _, c := range calls {
+		body, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "rpc %s %s\n", shQuote(cl.url), shQuote(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/pyrovski/reconciler/pkg/client"
+)
+
+// Added torrents can be put in a bandwidth group and given seeding limits
+// with --group, --seed-ratio-limit and --seed-idle-limit, or by a rule,
+// whose settings override the flags one at a time. A negative limit means
+// no limit; without one, the client's global limit applies.
+var seedFlags seedPolicy
+
+type seedPolicy struct {
+	Group          string   `json:"group,omitempty"`
+	SeedRatioLimit *float64 `json:"seed_ratio_limit,omitempty"`
+	// minutes
+	SeedIdleLimit *int `json:"seed_idle_limit,omitempty"`
+}
+
+// over returns p with what it leaves unset taken from base.
+func (p seedPolicy) over(base seedPolicy) seedPolicy {
+	if p.Group == "" {
+		p.Group = base.Group
+	}
+	if p.SeedRatioLimit == nil {
+		p.SeedRatioLimit = base.SeedRatioLimit
+	}
+	if p.SeedIdleLimit == nil {
+		p.SeedIdleLimit = base.SeedIdleLimit
+	}
+	return p
+}
+
+// options returns the torrent-set arguments for p, or nil if it sets
+// nothing.
+func (p seedPolicy) options() *client.SeedOptions {
+	if p.Group == "" && p.SeedRatioLimit == nil && p.SeedIdleLimit == nil {
+		return nil
+	}
+	o := &client.SeedOptions{Group: p.Group}
+	if l := p.SeedRatioLimit; l != nil {
+		o.SeedRatioMode = client.SeedUnlimited
+		if *l >= 0 {
+			o.SeedRatioLimit, o.SeedRatioMode = l, client.SeedSingle
+		}
+	}
+	if l := p.SeedIdleLimit; l != nil {
+		o.SeedIdleMode = client.SeedUnlimited
+		if *l >= 0 {
+			o.SeedIdleLimit, o.SeedIdleMode = l, client.SeedSingle
+		}
+	}
+	return o
+}
+
+// remoteArgs returns transmission-remote options setting o.
+func remoteArgs(o *client.SeedOptions) string {
+	var s string
+	if o.Group != "" {
+		s += " --bandwidth-group " + shQuote(o.Group)
+	}
+	switch o.SeedRatioMode {
+	case client.SeedSingle:
+		s += " --seedratio " + strconv.FormatFloat(*o.SeedRatioLimit, 'f', -1, 64)
+	case client.SeedUnlimited:
+		s += " --no-seedratio"
+	}
+	switch o.SeedIdleMode {
+	case client.SeedSingle:
+		s += " --idle-seeding-limit " + strconv.Itoa(*o.SeedIdleLimit)
+	case client.SeedUnlimited:
+		s += " --no-idle-seeding-limit"
+	}
+	return s
+}
+
+func parseRatioLimit(s string) error {
+	l, err := strconv.ParseFloat(s, 64)
+	seedFlags.SeedRatioLimit = &l
+	return err
+}
+
+func parseIdleLimit(s string) error {
+	l, err := strconv.Atoi(s)
+	seedFlags.SeedIdleLimit = &l
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+func runService(run func(context.Context) int) int {
+	log.Fatal("--service is only for Windows")
+	return exitFailed
+}
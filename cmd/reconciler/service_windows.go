@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// With --service, the daemon runs under the Windows service manager,
+// installed with something like
+//
+//	sc create reconciler start= auto binPath= "C:\reconciler\reconciler.exe daemon --service --log-file C:\reconciler\log.txt ..."
+//
+// Stopping the service, or shutting Windows down, ends it as SIGTERM
+// would. A service has no console, so --log-file is the only log.
+
+var (
+	procStartServiceCtrlDispatcher   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped        = 1
+	serviceStopPending    = 3
+	serviceRunning        = 4
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented   = 120
+	errorServiceSpecificError = 1066
+	serviceStopWaitHintMillis = 10000
+)
+
+// serviceStatus is SERVICE_STATUS.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW.
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// the running service, shared with the service manager's callbacks
+var service struct {
+	mu     sync.Mutex
+	name   *uint16
+	handle uintptr
+	run    func(context.Context) int
+	cancel context.CancelFunc
+	code   int
+}
+
+// runService hands the process to the service manager, which calls
+// serviceMain to run run, and returns run's exit code.
+func runService(run func(context.Context) int) int {
+	// the name is ignored for a service in its own process
+	name, err := syscall.UTF16PtrFromString("reconciler")
+	if err != nil {
+		log.Fatal(err)
+	}
+	service.name, service.run = name, run
+	table := []serviceTableEntry{{name, syscall.NewCallback(serviceMain)}, {}}
+	if r, _, err := procStartServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		log.Fatalf("not started by the service manager: %v", err)
+	}
+	return service.code
+}
+
+func serviceMain(argc, argv uintptr) uintptr {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.mu.Lock()
+	service.cancel = cancel
+	h, _, err := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(service.name)), syscall.NewCallback(serviceHandler), 0)
+	if h == 0 {
+		service.mu.Unlock()
+		slog.Error("registering service handler", "err", err)
+		service.code = exitFailed
+		return 0
+	}
+	service.handle = h
+	setServiceStatus(serviceRunning, 0)
+	service.mu.Unlock()
+
+	code := service.run(ctx)
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	service.code = code
+	setServiceStatus(serviceStopped, code)
+	return 0
+}
+
+func serviceHandler(control, eventType, eventData, handlerContext uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		slog.Info("service stopping")
+		service.mu.Lock()
+		setServiceStatus(serviceStopPending, 0)
+		service.mu.Unlock()
+		service.cancel()
+	case serviceControlInterrogate:
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// setServiceStatus reports the service's state, and once stopped, its exit
+// code. service.mu must be held.
+func setServiceStatus(state uint32, code int) {
+	s := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state}
+	switch state {
+	case serviceRunning:
+		s.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStopPending:
+		s.WaitHint = serviceStopWaitHintMillis
+	case serviceStopped:
+		if code != exitOK {
+			s.Win32ExitCode = errorServiceSpecificError
+			s.ServiceSpecificExitCode = uint32(code)
+		}
+	}
+	if r, _, err := procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&s))); r == 0 {
+		slog.Error("setting service status", "err", err)
+	}
+}
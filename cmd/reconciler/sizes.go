@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// The catalog table may also have a size column, in bytes, and a hash
+// column holding each file's BEP 52 pieces root in hex: the root of the
+// SHA-256 merkle tree of its 16 KiB blocks, as v2 and hybrid torrents list
+// it. When it has them, a DB file is only matched to a torrent's file of
+// the same length, and of the same pieces root if both have one.
+
+// DataQuery returns the size and hash of a DB file, null for each column
+// the table hasn't got.
+const DataQuery = "select {size}, {hash} from {files} where {path} = ? and {file} = ? limit 1"
+
+// sameData reports whether the DB file at fullpath can hold the data of
+// ti's file whose path ends in file and whose length is size if that's
+// known. A file the DB has no size or hash for can.
+func sameData(stmt *sql.Stmt, ti *metainfo.Info, file string, size int64, fullpath string) (bool, error) {
+	p, ok := names().TorrentPath(ti, file, size)
+	slash := strings.LastIndex(fullpath, "/")
+	if !ok || slash < 0 {
+		return true, nil
+	}
+	var tf metainfo.File
+	for _, f := range ti.Files {
+		if f.Path == p && !f.Pad {
+			tf = f
+			break
+		}
+	}
+	var dbSize sql.NullInt64
+	var dbHash sql.NullString
+	defer stats.observeQuery(time.Now())
+	qctx, cancel := dbContext()
+	defer cancel()
+	err := stmt.QueryRowContext(qctx, fullpath[:slash], fullpath[slash+1:]).Scan(&dbSize, &dbHash)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if dbSize.Valid && dbSize.Int64 != tf.Length {
+		return false, nil
+	}
+	if dbHash.Valid && dbHash.String != "" && tf.PiecesRoot != "" && !strings.EqualFold(dbHash.String, tf.PiecesRoot) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// With --stat-sizes, a torrent's listed files are looked at on disk under
+// each candidate dir, whatever the DB says, since a DB of NFS paths can be
+// stale. A dir is dropped if one is missing or isn't the size it should
+// be: the one the input gives or, failing that, the torrent's.
+var statSizes bool
+
+// statCandidates returns the dirs under which tf's listed files are as
+// expected on disk, or the first dir's problem if none is.
+func statCandidates(ti *metainfo.Info, tf *torFile, dirs []string) ([]string, error) {
+	var kept []string
+	var first error
+	for _, dir := range dirs {
+		err := statListed(ti, tf, dir)
+		if err == nil {
+			kept = append(kept, dir)
+			continue
+		}
+		slog.Info("listed files not as expected on disk", "torrent", tf.tor, "dir", dir, "err", err)
+		if first == nil {
+			first = err
+		}
+	}
+	if len(kept) == 0 {
+		return nil, first
+	}
+	return kept, nil
+}
+
+func statListed(ti *metainfo.Info, tf *torFile, dir string) error {
+	files := append([]string{tf.file}, tf.others...)
+	for i, file := range files {
+		size := tf.size
+		if i > 0 {
+			size = tf.sizes[file]
+		}
+		full := names().ContainedPath(ti, dir, file, size)
+		if _, ok := names().TorrentPath(ti, file, size); !ok && size > 0 {
+			// the torrent's file of that name is another size
+			full = names().ContainedPath(ti, dir, file, 0)
+		}
+		if size == 0 {
+			size = torrentLength(ti, file)
+		}
+		fi, err := os.Stat(full)
+		if err != nil {
+			return err
+		}
+		if size > 0 && fi.Size() != size {
+			return fmt.Errorf("%s: %d bytes, expected %d", full, fi.Size(), size)
+		}
+	}
+	return nil
+}
+
+// torrentLength returns the length of ti's file whose path ends in file, or
+// 0 if ti, which may be nil, has none.
+func torrentLength(ti *metainfo.Info, file string) int64 {
+	p, ok := names().TorrentPath(ti, file, 0)
+	if !ok {
+		return 0
+	}
+	for _, f := range ti.Files {
+		if f.Path == p && !f.Pad {
+			return f.Length
+		}
+	}
+	return 0
+}
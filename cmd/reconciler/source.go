@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// Where the files torrents are matched against are listed: "db" is the
+// --db catalog, "fs" walks each --root, "locate" takes the files under
+// them from the system's locate database, "rclone" from an rclone
+// listing, "arr" from Sonarr and Radarr, and "jellyfin" and "plex" from
+// those media servers' libraries. For all but db, the files go in an in-memory catalog with the
+// same files table, so a run needs no prebuilt DB, and each pass lists them
+// again. The db, arr, jellyfin and plex sources have paths from anywhere;
+// with them, any --root only limits matches to paths under the roots,
+// leaving out backups and the like.
+var source string
+var roots stringList
+
+// rootsLimitMatches reports whether --root limits matches rather than what's
+// listed.
+func rootsLimitMatches() bool {
+	switch source {
+	case "db", "arr", "jellyfin", "plex":
+		return len(roots) > 0
+	}
+	return false
+}
+
+func checkSource() error {
+	switch source {
+	case "db":
+		if dbFile == "" {
+			return fmt.Errorf("must set --db")
+		}
+	case "fs", "locate", "rclone":
+		if len(roots) == 0 {
+			return fmt.Errorf("--source=%s needs at least one --root", source)
+		}
+	case "arr":
+		if len(arrURLs) == 0 {
+			return fmt.Errorf("--source=arr needs --arr")
+		}
+	case "jellyfin":
+		if jellyfinURL == "" {
+			return fmt.Errorf("--source=jellyfin needs --jellyfin")
+		}
+	case "plex":
+		if plexDB == "" {
+			return fmt.Errorf("--source=plex needs --plex-db")
+		}
+	default:
+		return fmt.Errorf("invalid --source %q", source)
+	}
+	if source != "db" {
+		if dbFile != "" {
+			return fmt.Errorf("--source=%s builds its own catalog; it can't be used with --db", source)
+		}
+		if recordMatches {
+			return fmt.Errorf("--record-matches needs a --db to record to")
+		}
+	}
+	if source == "rclone" {
+		if err := checkRclone(); err != nil {
+			return err
+		}
+	}
+	if locateDB != "" && source != "locate" {
+		return fmt.Errorf("--locate-db needs --source=locate")
+	}
+	return nil
+}
+
+// memCatalog holds a connection to the in-memory catalog, which lasts only
+// as long as one is open.
+var memCatalog *sql.Conn
+
+// memCatalogDSN is the DSN of the in-memory catalog, shared by every
+// connection in the process.
+func memCatalogDSN() string {
+	params := url.Values{}
+	params.Set("mode", "memory")
+	params.Set("cache", "shared")
+	return sqliteDSN("reconciler-catalog", params)
+}
+
+const memCatalogSchema = `
+create table if not exists files (path text not null, file text not null, device integer, inode integer);
+create index if not exists files_file on files (file);
+create table if not exists releases (name text not null, path text not null, file text not null);
+create index if not exists releases_name on releases (name);
+`
+
+// listRoots refills the in-memory catalog with the files under roots. It
+// does nothing for --source=db.
+func listRoots(ctx context.Context) error {
+	if source == "db" {
+		return nil
+	}
+	if memCatalog == nil {
+		db, err := sql.Open("sqlite3", memCatalogDSN())
+		if err != nil {
+			return err
+		}
+		if memCatalog, err = db.Conn(ctx); err != nil {
+			return err
+		}
+		if _, err := memCatalog.ExecContext(ctx, memCatalogSchema); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	tx, err := memCatalog.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "delete from files; delete from releases"); err != nil {
+		return err
+	}
+	insert, err := tx.PrepareContext(ctx, "insert into files (path, file, device, inode) values (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	n := 0
+	addLinked := func(dir, file string, key *fileKey) error {
+		n++
+		var dev, ino any
+		if key != nil {
+			// SQLite integers are signed
+			dev, ino = int64(key.dev), int64(key.ino)
+		}
+		_, err := insert.ExecContext(ctx, dir, file, dev, ino)
+		return err
+	}
+	add := func(dir, file string) error { return addLinked(dir, file, nil) }
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		if abs[i], err = filepath.Abs(root); err != nil {
+			return err
+		}
+	}
+	switch {
+	case source == "arr":
+		err = listArr(ctx, add, func(name, dir, file string) error {
+			_, err := tx.ExecContext(ctx, "insert into releases (name, path, file) values (?, ?, ?)", name, dir, file)
+			return err
+		})
+	case source == "jellyfin":
+		err = listJellyfin(ctx, add)
+	case source == "plex":
+		err = listPlex(ctx, add)
+	case source == "fs":
+		err = walkFS(ctx, abs, addLinked)
+	case source == "rclone":
+		err = listRclone(ctx, abs[0], add)
+	case locateDB != "":
+		err = readMlocate(ctx, locateDB, abs, add)
+	default:
+		err = runLocate(ctx, abs, add)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("listed roots", "source", source, "roots", len(roots), "files", n, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// walkFS calls add with the dir and name of each regular file under roots,
+// and with --seed-root, its device and inode.
+func walkFS(ctx context.Context, roots []string, add func(dir, file string, key *fileKey) error) error {
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p == root {
+					return err
+				}
+				slog.Warn("walking", "path", p, "err", err)
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			var key *fileKey
+			if len(seedRoots) > 0 {
+				if fi, err := d.Info(); err == nil {
+					if k, ok := statKey(fi); ok {
+						key = &k
+					}
+				}
+			}
+			return add(filepath.ToSlash(filepath.Dir(p)), d.Name(), key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
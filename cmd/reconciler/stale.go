@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// The DB can lag behind deletions by days. Before a match is added, its
+// data dir and matched file are stat'ed, and a match whose data is gone is
+// skipped as stale. --stat-paths=auto does so when the data is visible
+// from this host: always with --source=fs or locate, whose paths are this
+// host's, and otherwise for data under a --root that exists here. always
+// stats every match, and never none.
+var statPaths string
+
+func checkStatPaths() error {
+	switch statPaths {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("--stat-paths must be auto, always or never")
+}
+
+var localRoots = sync.OnceValue(func() []string {
+	var l []string
+	for _, root := range roots {
+		if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+			l = append(l, root)
+		}
+	}
+	return l
+})
+
+// locallyVisible reports whether dir, a data dir from the DB, can be
+// stat'ed here.
+func locallyVisible(dir string) bool {
+	switch {
+	case statPaths == "always":
+		return true
+	case statPaths == "never":
+		return false
+	case source == "fs" || source == "locate":
+		return true
+	}
+	return under(slashed(dir), localRoots())
+}
+
+// checkStale returns an error if match's data is visibly gone.
+func checkStale(match *matchedFile) error {
+	if !locallyVisible(match.dataDir) {
+		return nil
+	}
+	fi, err := os.Stat(match.dataDir)
+	if err == nil && !fi.IsDir() {
+		err = fmt.Errorf("%s: not a directory", match.dataDir)
+	}
+	if err == nil && match.dataFile != "" {
+		_, err = os.Stat(match.dataFile)
+	}
+	if err != nil {
+		return fmt.Errorf("DB is stale: %w", err)
+	}
+	return nil
+}
+
+// dataFile returns where the file tf was matched by is in dir, once renames
+// are applied. ti is tf's metainfo, nil for a magnet.
+func dataFile(ti *metainfo.Info, tf *torFile, dir string, renames []rename) string {
+	p, ok := names().TorrentPath(ti, tf.file, tf.size)
+	if !ok {
+		p = tf.file
+	} else if len(renames) > 0 {
+		p = renamed(p, renames)
+	}
+	return strings.TrimSuffix(dir, "/") + "/" + p
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The state DB records how far each torrent got, so an interrupted run can
+// be repeated without querying matched torrents again or adding anything
+// twice. Every change is also appended to an event log. Torrents recorded as
+// added or present are skipped, and those rolled back as bad matches
+// excluded, for as long as the state DB is kept. Audits
+// also count, for --prune-after, how many times in a row each client
+// torrent's data was nowhere in the catalog.
+var statePath string
+
+// torrent stages in the state DB
+const (
+	stageMatched = "matched"
+	stageAdded   = "added"
+	// the client already had the torrent
+	stagePresent = "present"
+	// rolled back for verifying below --verify-threshold
+	stageBad = "bad"
+)
+
+const stateSchema = `
+create table if not exists torrents (
+	torrent text primary key,
+	info_hash text not null,
+	stage text not null,
+	data_dir text not null,
+	file text not null,
+	updated integer not null
+);
+create table if not exists missing (
+	info_hash text primary key,
+	audits integer not null,
+	since integer not null
+);
+create table if not exists events (
+	time integer not null,
+	torrent text not null,
+	info_hash text not null,
+	event text not null,
+	detail text not null
+);
+`
+
+type stateDB struct {
+	db *sql.DB
+}
+
+// openState opens or creates the state DB at path. An empty path yields a
+// nil *stateDB, which records nothing.
+func openState(path string) (*stateDB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("sqlite3", sqliteDSN(path, nil))
+	if err != nil {
+		return nil, err
+	}
+	// the matcher and adder both write; one connection avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(stateSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &stateDB{db}, nil
+}
+
+func (s *stateDB) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// lookup returns the recorded stage and data dir of tor, or "" if there
+// is no record.
+func (s *stateDB) lookup(tor string) (stage, dir string, err error) {
+	if s == nil {
+		return "", "", nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	err = s.db.QueryRowContext(ctx, "select stage, data_dir from torrents where torrent = ?", tor).Scan(&stage, &dir)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return stage, dir, err
+}
+
+// record moves match to stage and logs the event.
+func (s *stateDB) record(match *matchedFile, stage string) error {
+	if s == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `insert into torrents (torrent, info_hash, stage, data_dir, file, updated)
+		values (?, ?, ?, ?, ?, ?)
+		on conflict (torrent) do update set info_hash = excluded.info_hash, stage = excluded.stage,
+			data_dir = excluded.data_dir, file = excluded.file, updated = excluded.updated`,
+		match.tor, match.infoHash, stage, match.dataDir, match.file, now)
+	if err != nil {
+		return err
+	}
+	return s.event(match, stage, match.path)
+}
+
+// missingAudit records that an audit found none of the data of the torrent
+// with hash, and returns how many audits in a row have.
+func (s *stateDB) missingAudit(hash string) (int, error) {
+	if s == nil {
+		return 1, nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	var audits int
+	err := s.db.QueryRowContext(ctx, `insert into missing (info_hash, audits, since) values (?, 1, ?)
+		on conflict (info_hash) do update set audits = audits + 1
+		returning audits`, hash, time.Now().Unix()).Scan(&audits)
+	return audits, err
+}
+
+// foundAudit records that an audit found the data of the torrent with hash.
+func (s *stateDB) foundAudit(hash string) error {
+	if s == nil {
+		return nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, "delete from missing where info_hash = ?", hash)
+	return err
+}
+
+// event logs something that happened to match without changing its stage.
+func (s *stateDB) event(match *matchedFile, event, detail string) error {
+	if s == nil {
+		return nil
+	}
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, "insert into events (time, torrent, info_hash, event, detail) values (?, ?, ?, ?, ?)",
+		time.Now().Unix(), match.tor, match.infoHash, event, detail)
+	return err
+}
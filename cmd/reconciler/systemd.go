@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Under systemd with Type=notify, the daemon reports READY=1 once it's
+// serving, and its status after each pass. With WatchdogSec, it pings the
+// watchdog for as long as it's making progress: between passes, or during
+// one while torrents keep moving through the pipeline. A pass stuck for
+// WatchdogSec stops the pings, and systemd restarts the daemon, so
+// WatchdogSec must be longer than the slowest single step, like listing a
+// large --root.
+
+// lastProgress is when, in Unix nanoseconds, the pass under way last
+// showed it wasn't stuck.
+var lastProgress atomic.Int64
+var inPass atomic.Bool
+
+func markProgress() {
+	lastProgress.Store(time.Now().UnixNano())
+}
+
+// sdNotify sends state to systemd, if it's listening.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		// abstract namespace
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		slog.Debug("notifying systemd", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Debug("notifying systemd", "err", err)
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd set for this
+// process, or 0 if there's none.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings systemd's watchdog at half its timeout, between passes or
+// while there's been progress within the timeout, until ctx is done.
+func watchdog(ctx context.Context) {
+	timeout := watchdogInterval()
+	if timeout == 0 {
+		return
+	}
+	markProgress()
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if since := time.Since(time.Unix(0, lastProgress.Load())); !inPass.Load() || since < timeout {
+				sdNotify("WATCHDOG=1")
+			} else {
+				slog.Warn("no progress; not pinging the watchdog", "for", since.Round(time.Second))
+			}
+		}
+	}
+}
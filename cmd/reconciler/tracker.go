@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// Torrents are filtered by their announce URLs before the DB is queried:
+// with any --tracker-include, one of a torrent's trackers must match one of
+// them, and a torrent with any tracker matching a --tracker-exclude is
+// skipped. Torrents without trackers never pass an include.
+var trackerInclude, trackerExclude regexpList
+
+// regexpList is a flag.Value compiling each occurrence of a repeated flag.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	var s []string
+	for _, re := range *l {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (l *regexpList) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+func (l regexpList) matchAny(announce []string) bool {
+	for _, re := range l {
+		for _, a := range announce {
+			if re.MatchString(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackerHost returns the host of the first of a torrent's announce URLs,
+// which its per-tracker counts are kept under, or "(none)".
+func trackerHost(announce []string) string {
+	for _, a := range announce {
+		if u, err := url.Parse(a); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	return "(none)"
+}
+
+func trackerFiltered() bool {
+	return len(trackerInclude) > 0 || len(trackerExclude) > 0
+}
+
+// trackerAllowed reports whether a torrent with the given announce URLs
+// passes the tracker filters.
+func trackerAllowed(announce []string) bool {
+	if trackerExclude.matchAny(announce) {
+		return false
+	}
+	return len(trackerInclude) == 0 || trackerInclude.matchAny(announce)
+}
+
+// torrentMeta returns what tor says about its torrent, be it a magnet link,
+// a URL or a file.
+func torrentMeta(ctx context.Context, tor string) (*metainfo.Info, error) {
+	if isMagnet(tor) {
+		return parseMagnet(tor)
+	}
+	filename, err := localTorrent(ctx, tor)
+	if err != nil {
+		return nil, err
+	}
+	return loadTorrent(filename)
+}
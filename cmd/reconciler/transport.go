@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var tlsCA string
+var tlsCert string
+var tlsKey string
+var tlsInsecure bool
+
+// RPC connections go through the proxy in HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY, or the one given by --proxy or a client's proxy, which is
+// also used for localhost, as with an SSH-forwarded SOCKS proxy.
+var proxy string
+
+// newTransport builds the HTTP transport for RPC connections from the TLS
+// and proxy flags.
+func newTransport() (*http.Transport, error) {
+	t, err := withProxy(http.DefaultTransport.(*http.Transport), proxy)
+	if err != nil {
+		return nil, err
+	}
+	if tlsCA == "" && tlsCert == "" && tlsKey == "" && !tlsInsecure {
+		return t, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: tlsInsecure}
+	if tlsCA != "" {
+		pem, err := os.ReadFile(tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", tlsCA)
+		}
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = cfg
+	return t, nil
+}
+
+// withProxy returns a copy of t that connects through proxy, an http,
+// https, socks5 or socks5h URL, if it's set.
+func withProxy(t *http.Transport, proxy string) (*http.Transport, error) {
+	t = t.Clone()
+	if proxy == "" {
+		return t, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %s: scheme must be http, https, socks5 or socks5h", proxy)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %s: no host", proxy)
+	}
+	t.Proxy = http.ProxyURL(u)
+	return t, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "strings"
+
+// With --windows-paths, the catalog's paths, and those from Sonarr, Radarr,
+// Jellyfin or Plex, are Windows paths like D:\Media\Show\e1.mkv. They're
+// matched with their backslashes read as slashes, and case-insensitively
+// against --root, and download dirs go to the clients with backslashes, as
+// Windows clients expect. Reading the catalog's paths this way rules out
+// its indexes, so lookups scan the whole table.
+var windowsPaths bool
+
+// slashed returns p, a path from the catalog or a media server, with
+// slashes for separators.
+func slashed(p string) string {
+	if !windowsPaths {
+		return p
+	}
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// clientPath returns dir as the clients know it.
+func clientPath(dir string) string {
+	if !windowsPaths {
+		return dir
+	}
+	return strings.ReplaceAll(dir, "/", `\`)
+}
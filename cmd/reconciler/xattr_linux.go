@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func xattrSupported() error { return nil }
+
+// getXattr returns the extended attribute name of path, or "" if it has
+// none.
+func getXattr(path, name string) (string, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := syscall.Getxattr(path, name, buf)
+		switch {
+		case errors.Is(err, syscall.ENODATA):
+			return "", nil
+		case errors.Is(err, syscall.ERANGE):
+			buf = make([]byte, 2*len(buf))
+			continue
+		case err != nil:
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+func setXattr(path, name, value string) error {
+	return syscall.Setxattr(path, name, []byte(value), 0)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errNoXattr = errors.New("--mark-data=xattr is only supported on Linux; use --mark-data=file")
+
+func xattrSupported() error { return errNoXattr }
+
+func getXattr(path, name string) (string, error) { return "", errNoXattr }
+
+func setXattr(path, name, value string) error { return errNoXattr }
@@ -0,0 +1,208 @@
+// Package api is a client of the HTTP API served by `reconciler api` and
+// `reconciler daemon --dashboard`, for Go programs to match and add
+// torrents with rather than running reconciler. The same runs are served
+// over gRPC with --grpc-listen; see pkg/apipb.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A run's status before an add. Once added, it's the add's outcome:
+// added, present, duplicate, failed, rejected and so on, as in the run
+// report.
+const (
+	StatusMatched   = "matched"
+	StatusUnmatched = "unmatched"
+	StatusPresent   = "present"
+	StatusExcluded  = "excluded"
+	StatusFailed    = "failed"
+	StatusAdding    = "adding"
+	StatusAdded     = "added"
+	// by a pre-add hook, or before an add, by an approval link
+	StatusRejected = "rejected"
+)
+
+// Run is a torrent submitted to the API, and what became of it.
+type Run struct {
+	ID       string `json:"id"`
+	Torrent  string `json:"torrent"`
+	File     string `json:"file"`
+	InfoHash string `json:"info_hash,omitempty"`
+	Status   string `json:"status"`
+	// the one the server would pick first
+	Candidates []Candidate `json:"candidates,omitempty"`
+	// the data dir added from, once an add was asked for
+	Dir      string    `json:"dir,omitempty"`
+	Failures []Failure `json:"failures,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Candidate is a dir a run's torrent may be added at.
+type Candidate struct {
+	Dir         string `json:"dir"`
+	DownloadDir string `json:"download_dir"`
+	Client      string `json:"client,omitempty"`
+	Confidence  string `json:"confidence"`
+	// bytes the client would download
+	Download int64 `json:"download,omitempty"`
+}
+
+// Failure is a failure of a run, as in the run report.
+type Failure struct {
+	Kind    string `json:"kind"`
+	Torrent string `json:"torrent,omitempty"`
+	Error   string `json:"error"`
+}
+
+// Unmatched is a torrent without a match: of the daemon's last pass, or
+// of a run, with its ID.
+type Unmatched struct {
+	Torrent string `json:"torrent"`
+	Run     string `json:"run,omitempty"`
+}
+
+// Error is a request the server refused.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("reconciler api: %d %s", e.Status, e.Message)
+}
+
+// Client calls the API at a base URL, such as http://localhost:9742.
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// New returns a client of the API at baseURL, sending token, if set, as a
+// bearer token. A nil hc is http.DefaultClient.
+func New(baseURL, token string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{strings.TrimSuffix(baseURL, "/"), token, hc}
+}
+
+// Match matches a torrent the server can read: a .torrent path on it, an
+// http(s) URL or a magnet link. file is the contained file to match by;
+// empty, the largest is used.
+func (c *Client) Match(ctx context.Context, torrent, file string) (*Run, error) {
+	body, _ := json.Marshal(map[string]string{"torrent": torrent, "file": file})
+	var run Run
+	if err := c.do(ctx, http.MethodPost, "/torrents", "application/json", bytes.NewReader(body), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Upload matches the .torrent read from r, uploading it as name.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, file string) (*Run, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	if file != "" {
+		mw.WriteField("file", file)
+	}
+	fw, err := mw.CreateFormFile("torrent", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	var run Run
+	if err := c.do(ctx, http.MethodPost, "/torrents", mw.FormDataContentType(), &b, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Add starts adding the matched run id at dir, one of its candidates'
+// dirs, or with dir empty at the first. The add happens in the background;
+// see Wait.
+func (c *Client) Add(ctx context.Context, id, dir string) (*Run, error) {
+	body, _ := json.Marshal(map[string]string{"dir": dir})
+	var run Run
+	if err := c.do(ctx, http.MethodPost, "/torrents/"+url.PathEscape(id)+"/add", "application/json", bytes.NewReader(body), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Run returns the run id.
+func (c *Client) Run(ctx context.Context, id string) (*Run, error) {
+	var run Run
+	if err := c.do(ctx, http.MethodGet, "/torrents/"+url.PathEscape(id), "", nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Wait polls the run id every poll until it's no longer being added. If
+// ctx is done first, it returns the run as last seen, with ctx's error.
+func (c *Client) Wait(ctx context.Context, id string, poll time.Duration) (*Run, error) {
+	for {
+		run, err := c.Run(ctx, id)
+		if err != nil || run.Status != StatusAdding {
+			return run, err
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return run, ctx.Err()
+		}
+	}
+}
+
+// Unmatched lists the torrents without a match.
+func (c *Client) Unmatched(ctx context.Context) ([]Unmatched, error) {
+	var l []Unmatched
+	err := c.do(ctx, http.MethodGet, "/unmatched", "", nil, &l)
+	return l, err
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e)
+		if e.Error == "" {
+			e.Error = resp.Status
+		}
+		return &Error{resp.StatusCode, e.Error}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,5 @@
+// Package apipb is the gRPC service served on --grpc-listen by `reconciler
+// api` and `reconciler daemon --dashboard`, generated from reconciler.proto.
+// NewReconcilerClient calls it. Its runs are those of the HTTP API that
+// pkg/api is a client of.
+package apipb
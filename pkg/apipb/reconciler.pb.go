@@ -0,0 +1,647 @@
+// The gRPC service served on --grpc-listen by `reconciler api` and
+// `reconciler daemon --dashboard`. Its runs are those of the HTTP API; see
+// pkg/api. Regenerate the Go code after changing this file with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative reconciler.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.29.3
+// source: reconciler.proto
+
+package apipb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Torrent:
+	//
+	//	*MatchRequest_Source
+	//	*MatchRequest_Data
+	Torrent isMatchRequest_Torrent `protobuf_oneof:"torrent"`
+	// the contained file to match by; empty, the largest is used
+	File          string `protobuf:"bytes,3,opt,name=file,proto3" json:"file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MatchRequest) Reset() {
+	*x = MatchRequest{}
+	mi := &file_reconciler_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MatchRequest) ProtoMessage() {}
+
+func (x *MatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MatchRequest.ProtoReflect.Descriptor instead.
+func (*MatchRequest) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MatchRequest) GetTorrent() isMatchRequest_Torrent {
+	if x != nil {
+		return x.Torrent
+	}
+	return nil
+}
+
+func (x *MatchRequest) GetSource() string {
+	if x != nil {
+		if x, ok := x.Torrent.(*MatchRequest_Source); ok {
+			return x.Source
+		}
+	}
+	return ""
+}
+
+func (x *MatchRequest) GetData() []byte {
+	if x != nil {
+		if x, ok := x.Torrent.(*MatchRequest_Data); ok {
+			return x.Data
+		}
+	}
+	return nil
+}
+
+func (x *MatchRequest) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+type isMatchRequest_Torrent interface {
+	isMatchRequest_Torrent()
+}
+
+type MatchRequest_Source struct {
+	// a .torrent path under --api-torrent-dir, an http(s) URL or a magnet
+	// link
+	Source string `protobuf:"bytes,1,opt,name=source,proto3,oneof"`
+}
+
+type MatchRequest_Data struct {
+	// the contents of a .torrent file
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+}
+
+func (*MatchRequest_Source) isMatchRequest_Torrent() {}
+
+func (*MatchRequest_Data) isMatchRequest_Torrent() {}
+
+type AddRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the run to add
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// one of its candidates' dirs; empty, the one tried before, or the one
+	// --resolve picks
+	Dir           string `protobuf:"bytes,2,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddRequest) Reset() {
+	*x = AddRequest{}
+	mi := &file_reconciler_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddRequest) ProtoMessage() {}
+
+func (x *AddRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddRequest.ProtoReflect.Descriptor instead.
+func (*AddRequest) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AddRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AddRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+type ListUnmatchedRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUnmatchedRequest) Reset() {
+	*x = ListUnmatchedRequest{}
+	mi := &file_reconciler_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUnmatchedRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUnmatchedRequest) ProtoMessage() {}
+
+func (x *ListUnmatchedRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUnmatchedRequest.ProtoReflect.Descriptor instead.
+func (*ListUnmatchedRequest) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{2}
+}
+
+// A torrent submitted to the API, and what became of it. Its status is as
+// in pkg/api.
+type Run struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Id       string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Torrent  string                 `protobuf:"bytes,2,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	File     string                 `protobuf:"bytes,3,opt,name=file,proto3" json:"file,omitempty"`
+	InfoHash string                 `protobuf:"bytes,4,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	Status   string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	// the one the server would pick first
+	Candidates []*Candidate `protobuf:"bytes,6,rep,name=candidates,proto3" json:"candidates,omitempty"`
+	// the data dir added from, once an add was asked for
+	Dir           string                 `protobuf:"bytes,7,opt,name=dir,proto3" json:"dir,omitempty"`
+	Failures      []*Failure             `protobuf:"bytes,8,rep,name=failures,proto3" json:"failures,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Run) Reset() {
+	*x = Run{}
+	mi := &file_reconciler_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Run) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Run) ProtoMessage() {}
+
+func (x *Run) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Run.ProtoReflect.Descriptor instead.
+func (*Run) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Run) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Run) GetTorrent() string {
+	if x != nil {
+		return x.Torrent
+	}
+	return ""
+}
+
+func (x *Run) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *Run) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *Run) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Run) GetCandidates() []*Candidate {
+	if x != nil {
+		return x.Candidates
+	}
+	return nil
+}
+
+func (x *Run) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *Run) GetFailures() []*Failure {
+	if x != nil {
+		return x.Failures
+	}
+	return nil
+}
+
+func (x *Run) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Run) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+// A dir a run's torrent may be added at.
+type Candidate struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Dir         string                 `protobuf:"bytes,1,opt,name=dir,proto3" json:"dir,omitempty"`
+	DownloadDir string                 `protobuf:"bytes,2,opt,name=download_dir,json=downloadDir,proto3" json:"download_dir,omitempty"`
+	Client      string                 `protobuf:"bytes,3,opt,name=client,proto3" json:"client,omitempty"`
+	Confidence  string                 `protobuf:"bytes,4,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	// bytes the client would download
+	Download      int64 `protobuf:"varint,5,opt,name=download,proto3" json:"download,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Candidate) Reset() {
+	*x = Candidate{}
+	mi := &file_reconciler_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Candidate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Candidate) ProtoMessage() {}
+
+func (x *Candidate) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Candidate.ProtoReflect.Descriptor instead.
+func (*Candidate) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Candidate) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *Candidate) GetDownloadDir() string {
+	if x != nil {
+		return x.DownloadDir
+	}
+	return ""
+}
+
+func (x *Candidate) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *Candidate) GetConfidence() string {
+	if x != nil {
+		return x.Confidence
+	}
+	return ""
+}
+
+func (x *Candidate) GetDownload() int64 {
+	if x != nil {
+		return x.Download
+	}
+	return 0
+}
+
+// A failure of a run, as in the run report.
+type Failure struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Torrent       string                 `protobuf:"bytes,2,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Failure) Reset() {
+	*x = Failure{}
+	mi := &file_reconciler_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Failure) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Failure) ProtoMessage() {}
+
+func (x *Failure) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Failure.ProtoReflect.Descriptor instead.
+func (*Failure) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Failure) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Failure) GetTorrent() string {
+	if x != nil {
+		return x.Torrent
+	}
+	return ""
+}
+
+func (x *Failure) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// A torrent without a match, with the ID of its run if it has one.
+type Unmatched struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Torrent       string                 `protobuf:"bytes,1,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	Run           string                 `protobuf:"bytes,2,opt,name=run,proto3" json:"run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Unmatched) Reset() {
+	*x = Unmatched{}
+	mi := &file_reconciler_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unmatched) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unmatched) ProtoMessage() {}
+
+func (x *Unmatched) ProtoReflect() protoreflect.Message {
+	mi := &file_reconciler_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unmatched.ProtoReflect.Descriptor instead.
+func (*Unmatched) Descriptor() ([]byte, []int) {
+	return file_reconciler_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Unmatched) GetTorrent() string {
+	if x != nil {
+		return x.Torrent
+	}
+	return ""
+}
+
+func (x *Unmatched) GetRun() string {
+	if x != nil {
+		return x.Run
+	}
+	return ""
+}
+
+var File_reconciler_proto protoreflect.FileDescriptor
+
+const file_reconciler_proto_rawDesc = "" +
+	"\n" +
+	"\x10reconciler.proto\x12\rreconciler.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"]\n" +
+	"\fMatchRequest\x12\x18\n" +
+	"\x06source\x18\x01 \x01(\tH\x00R\x06source\x12\x14\n" +
+	"\x04data\x18\x02 \x01(\fH\x00R\x04data\x12\x12\n" +
+	"\x04file\x18\x03 \x01(\tR\x04fileB\t\n" +
+	"\atorrent\".\n" +
+	"\n" +
+	"AddRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03dir\x18\x02 \x01(\tR\x03dir\"\x16\n" +
+	"\x14ListUnmatchedRequest\"\xe4\x02\n" +
+	"\x03Run\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\atorrent\x18\x02 \x01(\tR\atorrent\x12\x12\n" +
+	"\x04file\x18\x03 \x01(\tR\x04file\x12\x1b\n" +
+	"\tinfo_hash\x18\x04 \x01(\tR\binfoHash\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x128\n" +
+	"\n" +
+	"candidates\x18\x06 \x03(\v2\x18.reconciler.v1.CandidateR\n" +
+	"candidates\x12\x10\n" +
+	"\x03dir\x18\a \x01(\tR\x03dir\x122\n" +
+	"\bfailures\x18\b \x03(\v2\x16.reconciler.v1.FailureR\bfailures\x124\n" +
+	"\acreated\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\"\x94\x01\n" +
+	"\tCandidate\x12\x10\n" +
+	"\x03dir\x18\x01 \x01(\tR\x03dir\x12!\n" +
+	"\fdownload_dir\x18\x02 \x01(\tR\vdownloadDir\x12\x16\n" +
+	"\x06client\x18\x03 \x01(\tR\x06client\x12\x1e\n" +
+	"\n" +
+	"confidence\x18\x04 \x01(\tR\n" +
+	"confidence\x12\x1a\n" +
+	"\bdownload\x18\x05 \x01(\x03R\bdownload\"M\n" +
+	"\aFailure\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x18\n" +
+	"\atorrent\x18\x02 \x01(\tR\atorrent\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"7\n" +
+	"\tUnmatched\x12\x18\n" +
+	"\atorrent\x18\x01 \x01(\tR\atorrent\x12\x10\n" +
+	"\x03run\x18\x02 \x01(\tR\x03run2\xe4\x01\n" +
+	"\n" +
+	"Reconciler\x12C\n" +
+	"\fMatchTorrent\x12\x1b.reconciler.v1.MatchRequest\x1a\x12.reconciler.v1.Run(\x010\x01\x12?\n" +
+	"\n" +
+	"AddMatched\x12\x19.reconciler.v1.AddRequest\x1a\x12.reconciler.v1.Run(\x010\x01\x12P\n" +
+	"\rListUnmatched\x12#.reconciler.v1.ListUnmatchedRequest\x1a\x18.reconciler.v1.Unmatched0\x01B*Z(github.com/pyrovski/reconciler/pkg/apipbb\x06proto3"
+
+var (
+	file_reconciler_proto_rawDescOnce sync.Once
+	file_reconciler_proto_rawDescData []byte
+)
+
+func file_reconciler_proto_rawDescGZIP() []byte {
+	file_reconciler_proto_rawDescOnce.Do(func() {
+		file_reconciler_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_reconciler_proto_rawDesc), len(file_reconciler_proto_rawDesc)))
+	})
+	return file_reconciler_proto_rawDescData
+}
+
+var file_reconciler_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_reconciler_proto_goTypes = []any{
+	(*MatchRequest)(nil),          // 0: reconciler.v1.MatchRequest
+	(*AddRequest)(nil),            // 1: reconciler.v1.AddRequest
+	(*ListUnmatchedRequest)(nil),  // 2: reconciler.v1.ListUnmatchedRequest
+	(*Run)(nil),                   // 3: reconciler.v1.Run
+	(*Candidate)(nil),             // 4: reconciler.v1.Candidate
+	(*Failure)(nil),               // 5: reconciler.v1.Failure
+	(*Unmatched)(nil),             // 6: reconciler.v1.Unmatched
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_reconciler_proto_depIdxs = []int32{
+	4, // 0: reconciler.v1.Run.candidates:type_name -> reconciler.v1.Candidate
+	5, // 1: reconciler.v1.Run.failures:type_name -> reconciler.v1.Failure
+	7, // 2: reconciler.v1.Run.created:type_name -> google.protobuf.Timestamp
+	7, // 3: reconciler.v1.Run.updated:type_name -> google.protobuf.Timestamp
+	0, // 4: reconciler.v1.Reconciler.MatchTorrent:input_type -> reconciler.v1.MatchRequest
+	1, // 5: reconciler.v1.Reconciler.AddMatched:input_type -> reconciler.v1.AddRequest
+	2, // 6: reconciler.v1.Reconciler.ListUnmatched:input_type -> reconciler.v1.ListUnmatchedRequest
+	3, // 7: reconciler.v1.Reconciler.MatchTorrent:output_type -> reconciler.v1.Run
+	3, // 8: reconciler.v1.Reconciler.AddMatched:output_type -> reconciler.v1.Run
+	6, // 9: reconciler.v1.Reconciler.ListUnmatched:output_type -> reconciler.v1.Unmatched
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_reconciler_proto_init() }
+func file_reconciler_proto_init() {
+	if File_reconciler_proto != nil {
+		return
+	}
+	file_reconciler_proto_msgTypes[0].OneofWrappers = []any{
+		(*MatchRequest_Source)(nil),
+		(*MatchRequest_Data)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_reconciler_proto_rawDesc), len(file_reconciler_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_reconciler_proto_goTypes,
+		DependencyIndexes: file_reconciler_proto_depIdxs,
+		MessageInfos:      file_reconciler_proto_msgTypes,
+	}.Build()
+	File_reconciler_proto = out.File
+	file_reconciler_proto_goTypes = nil
+	file_reconciler_proto_depIdxs = nil
+}
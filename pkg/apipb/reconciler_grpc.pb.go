@@ -0,0 +1,209 @@
+// The gRPC service served on --grpc-listen by `reconciler api` and
+// `reconciler daemon --dashboard`. Its runs are those of the HTTP API; see
+// pkg/api. Regenerate the Go code after changing this file with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative reconciler.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.29.3
+// source: reconciler.proto
+
+package apipb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Reconciler_MatchTorrent_FullMethodName  = "/reconciler.v1.Reconciler/MatchTorrent"
+	Reconciler_AddMatched_FullMethodName    = "/reconciler.v1.Reconciler/AddMatched"
+	Reconciler_ListUnmatched_FullMethodName = "/reconciler.v1.Reconciler/ListUnmatched"
+)
+
+// ReconcilerClient is the client API for Reconciler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReconcilerClient interface {
+	// MatchTorrent matches each torrent sent, answering each with its run in
+	// the order sent. A torrent that can't be read ends the stream.
+	MatchTorrent(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[MatchRequest, Run], error)
+	// AddMatched starts adding the match of each run sent, answering with
+	// the run as its add starts and again with its outcome. The stream ends
+	// once the client has closed its side and the adds are done.
+	AddMatched(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AddRequest, Run], error)
+	// ListUnmatched streams the torrents without a match: of the daemon's
+	// last pass, and of the runs.
+	ListUnmatched(ctx context.Context, in *ListUnmatchedRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Unmatched], error)
+}
+
+type reconcilerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReconcilerClient(cc grpc.ClientConnInterface) ReconcilerClient {
+	return &reconcilerClient{cc}
+}
+
+func (c *reconcilerClient) MatchTorrent(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[MatchRequest, Run], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Reconciler_ServiceDesc.Streams[0], Reconciler_MatchTorrent_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MatchRequest, Run]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_MatchTorrentClient = grpc.BidiStreamingClient[MatchRequest, Run]
+
+func (c *reconcilerClient) AddMatched(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AddRequest, Run], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Reconciler_ServiceDesc.Streams[1], Reconciler_AddMatched_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AddRequest, Run]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_AddMatchedClient = grpc.BidiStreamingClient[AddRequest, Run]
+
+func (c *reconcilerClient) ListUnmatched(ctx context.Context, in *ListUnmatchedRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Unmatched], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Reconciler_ServiceDesc.Streams[2], Reconciler_ListUnmatched_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ListUnmatchedRequest, Unmatched]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_ListUnmatchedClient = grpc.ServerStreamingClient[Unmatched]
+
+// ReconcilerServer is the server API for Reconciler service.
+// All implementations must embed UnimplementedReconcilerServer
+// for forward compatibility.
+type ReconcilerServer interface {
+	// MatchTorrent matches each torrent sent, answering each with its run in
+	// the order sent. A torrent that can't be read ends the stream.
+	MatchTorrent(grpc.BidiStreamingServer[MatchRequest, Run]) error
+	// AddMatched starts adding the match of each run sent, answering with
+	// the run as its add starts and again with its outcome. The stream ends
+	// once the client has closed its side and the adds are done.
+	AddMatched(grpc.BidiStreamingServer[AddRequest, Run]) error
+	// ListUnmatched streams the torrents without a match: of the daemon's
+	// last pass, and of the runs.
+	ListUnmatched(*ListUnmatchedRequest, grpc.ServerStreamingServer[Unmatched]) error
+	mustEmbedUnimplementedReconcilerServer()
+}
+
+// UnimplementedReconcilerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReconcilerServer struct{}
+
+func (UnimplementedReconcilerServer) MatchTorrent(grpc.BidiStreamingServer[MatchRequest, Run]) error {
+	return status.Error(codes.Unimplemented, "method MatchTorrent not implemented")
+}
+func (UnimplementedReconcilerServer) AddMatched(grpc.BidiStreamingServer[AddRequest, Run]) error {
+	return status.Error(codes.Unimplemented, "method AddMatched not implemented")
+}
+func (UnimplementedReconcilerServer) ListUnmatched(*ListUnmatchedRequest, grpc.ServerStreamingServer[Unmatched]) error {
+	return status.Error(codes.Unimplemented, "method ListUnmatched not implemented")
+}
+func (UnimplementedReconcilerServer) mustEmbedUnimplementedReconcilerServer() {}
+func (UnimplementedReconcilerServer) testEmbeddedByValue()                    {}
+
+// UnsafeReconcilerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReconcilerServer will
+// result in compilation errors.
+type UnsafeReconcilerServer interface {
+	mustEmbedUnimplementedReconcilerServer()
+}
+
+func RegisterReconcilerServer(s grpc.ServiceRegistrar, srv ReconcilerServer) {
+	// If the following call panics, it indicates UnimplementedReconcilerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Reconciler_ServiceDesc, srv)
+}
+
+func _Reconciler_MatchTorrent_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ReconcilerServer).MatchTorrent(&grpc.GenericServerStream[MatchRequest, Run]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_MatchTorrentServer = grpc.BidiStreamingServer[MatchRequest, Run]
+
+func _Reconciler_AddMatched_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ReconcilerServer).AddMatched(&grpc.GenericServerStream[AddRequest, Run]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_AddMatchedServer = grpc.BidiStreamingServer[AddRequest, Run]
+
+func _Reconciler_ListUnmatched_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListUnmatchedRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReconcilerServer).ListUnmatched(m, &grpc.GenericServerStream[ListUnmatchedRequest, Unmatched]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reconciler_ListUnmatchedServer = grpc.ServerStreamingServer[Unmatched]
+
+// Reconciler_ServiceDesc is the grpc.ServiceDesc for Reconciler service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Reconciler_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "reconciler.v1.Reconciler",
+	HandlerType: (*ReconcilerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "MatchTorrent",
+			Handler:       _Reconciler_MatchTorrent_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "AddMatched",
+			Handler:       _Reconciler_AddMatched_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ListUnmatched",
+			Handler:       _Reconciler_ListUnmatched_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "reconciler.proto",
+}
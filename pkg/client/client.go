@@ -0,0 +1,25 @@
+// Package client talks to the BitTorrent clients matched torrents are
+// added to. Transmission, over its RPC protocol, is the one implemented.
+package client
+
+import "context"
+
+// TorrentClient is what the command needs of a client: adding, auditing
+// and rolling back torrents, and pointing them at their data. Torrent ids
+// are the client's own. Calls end when ctx is done.
+type TorrentClient interface {
+	Torrents(ctx context.Context) ([]Torrent, error)
+	TorrentFiles(ctx context.Context) ([]Torrent, error)
+	Progress(ctx context.Context, ids []int) ([]Progress, error)
+	SessionInfo(ctx context.Context) (map[string]interface{}, error)
+	AddFile(ctx context.Context, filename string, opts *AddOptions) (Torrent, error)
+	RenamePath(ctx context.Context, id int, path, name string) error
+	Verify(ctx context.Context, id int) error
+	Start(ctx context.Context, id int) error
+	SetSeeding(ctx context.Context, id int, opts *SeedOptions) error
+	SetLocation(ctx context.Context, id int, dir string) error
+	Remove(ctx context.Context, id int) error
+	FreeSpace(ctx context.Context, path string) (int64, error)
+}
+
+var _ TorrentClient = (*Transmission)(nil)
@@ -0,0 +1,486 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transmission is a minimal Transmission RPC client. The protocol is
+// described in
+// https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md
+//
+// The client library we used previously dropped the response's result field
+// and the HTTP status on the floor, so auth failures and server-side errors
+// looked like success.
+
+// DefaultRPCPath is where Transmission serves RPC.
+const DefaultRPCPath = "/transmission/rpc"
+const defaultRPCPort = "9091"
+const sessionHeader = "X-Transmission-Session-Id"
+
+// ErrAuth means the server rejected the credentials.
+var ErrAuth = errors.New("transmission: authentication failed")
+
+// ErrDuplicate is returned by AddFile when the client already has the
+// torrent.
+var ErrDuplicate = errors.New("transmission: duplicate torrent")
+
+// RPCError is a response whose result was something other than "success".
+type RPCError struct {
+	Method string
+	Result string
+	// HTTP status, when the failure was at the HTTP level
+	Status int
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("transmission: %s: %s", e.Method, e.Result)
+}
+
+// ConnError means the server could not be reached at all.
+type ConnError struct {
+	Err error
+}
+
+func (e *ConnError) Error() string { return "transmission: " + e.Err.Error() }
+func (e *ConnError) Unwrap() error { return e.Err }
+
+// Torrent is a torrent in the client.
+type Torrent struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	HashString  string `json:"hashString"`
+	DownloadDir string `json:"downloadDir"`
+	// only from TorrentFiles
+	Files  []File     `json:"files,omitempty"`
+	Wanted []FlexBool `json:"wanted,omitempty"`
+}
+
+// File is a file of a torrent in the client; the name is its path in the
+// torrent.
+type File struct {
+	Name   string `json:"name"`
+	Length int64  `json:"length"`
+}
+
+// FlexBool is a JSON boolean that older Transmissions send as 0 or 1.
+type FlexBool bool
+
+func (b *FlexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// Request is an RPC call as it's sent.
+type Request struct {
+	Method    string      `json:"method"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type rpcResponse struct {
+	Result    string          `json:"result"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type Transmission struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	// Observe, if set, is called after each call with its method and when
+	// it started.
+	Observe func(method string, start time.Time)
+	// Timeout, if set, limits each call, including the session id
+	// exchange.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+// URL builds the RPC endpoint from server, which is either host[:port] or
+// a full URL. The path comes from rpcPath if set, then from a URL's path,
+// and is otherwise Transmission's default. A bare host gets Transmission's
+// default port; a URL without a port keeps its scheme's default, as is
+// usual behind a reverse proxy.
+func URL(server, rpcPath string, ssl bool) (string, error) {
+	var u *url.URL
+	if strings.Contains(server, "://") {
+		var err error
+		u, err = url.Parse(server)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", fmt.Errorf("server %q: unsupported scheme %q", server, u.Scheme)
+		}
+	} else {
+		u = &url.URL{Scheme: "http", Host: server}
+		if ssl {
+			u.Scheme = "https"
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			u.Host = net.JoinHostPort(strings.Trim(server, "[]"), defaultRPCPort)
+		}
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("server %q: missing host", server)
+	}
+	switch {
+	case rpcPath != "":
+		u.Path = rpcPath
+	case u.Path == "" || u.Path == "/":
+		u.Path = DefaultRPCPath
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	return u.String(), nil
+}
+
+// NewTransmission returns a client of the RPC endpoint at url, which URL
+// builds, making requests with client.
+func NewTransmission(url, username, password string, client *http.Client) *Transmission {
+	return &Transmission{
+		url:      url,
+		username: username,
+		password: password,
+		client:   client,
+	}
+}
+
+// URL returns the RPC endpoint.
+func (c *Transmission) URL() string { return c.url }
+
+// Username returns the user the client authenticates as, if any.
+func (c *Transmission) Username() string { return c.username }
+
+func (c *Transmission) session() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+func (c *Transmission) setSession(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionID = id
+}
+
+// call executes method and decodes the response arguments into out, if non-nil.
+func (c *Transmission) call(ctx context.Context, method string, args interface{}, out interface{}) error {
+	if c.Observe != nil {
+		defer c.Observe(method, time.Now())
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	body, err := json.Marshal(Request{Method: method, Arguments: args})
+	if err != nil {
+		return err
+	}
+	// The first request of a session is always answered with 409 and a
+	// session id to use from then on; the id may also expire later.
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(sessionHeader, c.session())
+		if c.username != "" || c.password != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return &ConnError{err}
+		}
+		var r rpcResponse
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&r)
+			resp.Body.Close()
+		case http.StatusConflict:
+			resp.Body.Close()
+			c.setSession(resp.Header.Get(sessionHeader))
+			if !retried {
+				continue
+			}
+			return &RPCError{method, "session id rejected", 0}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			resp.Body.Close()
+			return ErrAuth
+		default:
+			resp.Body.Close()
+			return &RPCError{method, resp.Status, resp.StatusCode}
+		}
+		if err != nil {
+			return &RPCError{method, "invalid response: " + err.Error(), 0}
+		}
+		if r.Result != "success" {
+			return &RPCError{method, r.Result, 0}
+		}
+		if out == nil || len(r.Arguments) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(r.Arguments, out); err != nil {
+			return &RPCError{method, "invalid arguments: " + err.Error(), 0}
+		}
+		return nil
+	}
+}
+
+// Torrents lists the client's torrents, without their files.
+func (c *Transmission) Torrents(ctx context.Context) ([]Torrent, error) {
+	args := map[string]interface{}{
+		"fields": []string{"id", "name", "hashString", "downloadDir"},
+	}
+	var out struct {
+		Torrents []Torrent `json:"torrents"`
+	}
+	if err := c.call(ctx, "torrent-get", args, &out); err != nil {
+		return nil, err
+	}
+	return out.Torrents, nil
+}
+
+// TorrentFiles lists the client's torrents with their files and which of
+// those are wanted.
+func (c *Transmission) TorrentFiles(ctx context.Context) ([]Torrent, error) {
+	args := map[string]interface{}{
+		"fields": []string{"id", "name", "hashString", "downloadDir", "files", "wanted"},
+	}
+	var out struct {
+		Torrents []Torrent `json:"torrents"`
+	}
+	if err := c.call(ctx, "torrent-get", args, &out); err != nil {
+		return nil, err
+	}
+	return out.Torrents, nil
+}
+
+// Torrent statuses, as torrent-get reports them.
+const (
+	StatusStopped = iota
+	StatusCheckWait
+	StatusCheck
+	StatusDownloadWait
+	StatusDownload
+	StatusSeedWait
+	StatusSeed
+)
+
+// Progress is a torrent's status and the fraction of its wanted data the
+// client has.
+type Progress struct {
+	ID          int     `json:"id"`
+	Status      int     `json:"status"`
+	PercentDone float64 `json:"percentDone"`
+}
+
+// Checking reports whether the torrent is being verified or is waiting to
+// be.
+func (p Progress) Checking() bool {
+	return p.Status == StatusCheckWait || p.Status == StatusCheck
+}
+
+// Progress returns the progress of torrents ids. Torrents since removed are
+// left out.
+func (c *Transmission) Progress(ctx context.Context, ids []int) ([]Progress, error) {
+	args := map[string]interface{}{
+		"ids":    ids,
+		"fields": []string{"id", "status", "percentDone"},
+	}
+	var out struct {
+		Torrents []Progress `json:"torrents"`
+	}
+	if err := c.call(ctx, "torrent-get", args, &out); err != nil {
+		return nil, err
+	}
+	return out.Torrents, nil
+}
+
+// SessionInfo returns the server's version information.
+func (c *Transmission) SessionInfo(ctx context.Context) (map[string]interface{}, error) {
+	args := map[string]interface{}{
+		"fields": []string{"version", "rpc-version", "rpc-version-minimum"},
+	}
+	var out map[string]interface{}
+	if err := c.call(ctx, "session-get", args, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddOptions are the torrent-add arguments set besides the torrent itself.
+type AddOptions struct {
+	DownloadDir   string   `json:"download-dir,omitempty"`
+	FilesUnwanted []int    `json:"files-unwanted,omitempty"`
+	Labels        []string `json:"labels,omitempty"`
+	Paused        bool     `json:"paused,omitempty"`
+	// -1 low, 0 normal or 1 high
+	BandwidthPriority int `json:"bandwidthPriority,omitempty"`
+	// indices of files to make high or low priority
+	PriorityHigh []int `json:"priority-high,omitempty"`
+	PriorityLow  []int `json:"priority-low,omitempty"`
+}
+
+// AddArgs are the arguments of a torrent-add call.
+type AddArgs struct {
+	*AddOptions
+	MetaInfo string `json:"metainfo,omitempty"`
+	// a magnet link; Transmission fetches the metainfo itself
+	Filename string `json:"filename,omitempty"`
+}
+
+// AddFile adds the .torrent at filename, or the magnet link filename. If
+// the client already has the torrent, the existing torrent is returned
+// along with ErrDuplicate.
+func (c *Transmission) AddFile(ctx context.Context, filename string, opts *AddOptions) (Torrent, error) {
+	args := AddArgs{AddOptions: opts}
+	if strings.HasPrefix(filename, "magnet:") {
+		args.Filename = filename
+	} else {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return Torrent{}, err
+		}
+		args.MetaInfo = base64.StdEncoding.EncodeToString(data)
+	}
+	var out struct {
+		Added     *Torrent `json:"torrent-added"`
+		Duplicate *Torrent `json:"torrent-duplicate"`
+	}
+	if err := c.call(ctx, "torrent-add", args, &out); err != nil {
+		return Torrent{}, err
+	}
+	switch {
+	case out.Added != nil:
+		return *out.Added, nil
+	case out.Duplicate != nil:
+		return *out.Duplicate, ErrDuplicate
+	}
+	return Torrent{}, &RPCError{"torrent-add", "no torrent in response", 0}
+}
+
+// Transient reports whether err may succeed if the call is repeated.
+func Transient(err error) bool {
+	var ce *ConnError
+	var re *RPCError
+	switch {
+	case errors.As(err, &ce):
+		return true
+	case errors.As(err, &re):
+		return re.Status >= 500
+	}
+	return false
+}
+
+// ErrClass buckets RPC errors for reporting: auth, timeout, connection,
+// server or other.
+func ErrClass(err error) string {
+	var ce *ConnError
+	var re *RPCError
+	switch {
+	case errors.Is(err, ErrAuth):
+		return "auth"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &ce):
+		return "connection"
+	case errors.As(err, &re):
+		return "server"
+	}
+	return "other"
+}
+
+// RenamePath renames the file or directory at path, relative to the
+// download dir, in torrent id to name.
+func (c *Transmission) RenamePath(ctx context.Context, id int, path, name string) error {
+	args := map[string]interface{}{
+		"ids":  []int{id},
+		"path": path,
+		"name": name,
+	}
+	return c.call(ctx, "torrent-rename-path", args, nil)
+}
+
+// Verify and Start queue torrent id for a hash check and for starting.
+func (c *Transmission) Verify(ctx context.Context, id int) error {
+	return c.call(ctx, "torrent-verify", map[string]interface{}{"ids": []int{id}}, nil)
+}
+
+func (c *Transmission) Start(ctx context.Context, id int) error {
+	return c.call(ctx, "torrent-start", map[string]interface{}{"ids": []int{id}}, nil)
+}
+
+// Seeding modes: the client's global limit, the torrent's own, or none.
+const (
+	SeedGlobal = iota
+	SeedSingle
+	SeedUnlimited
+)
+
+// SeedOptions are the torrent-set arguments for a torrent's bandwidth
+// group, a Transmission 4 feature, and seeding limits. A limit applies
+// with its mode set to SeedSingle; the idle limit is in minutes.
+type SeedOptions struct {
+	Group          string   `json:"group,omitempty"`
+	SeedRatioLimit *float64 `json:"seedRatioLimit,omitempty"`
+	SeedRatioMode  int      `json:"seedRatioMode,omitempty"`
+	SeedIdleLimit  *int     `json:"seedIdleLimit,omitempty"`
+	SeedIdleMode   int      `json:"seedIdleMode,omitempty"`
+}
+
+// SetArgs are torrent-set arguments; IDs are ids or info hashes.
+type SetArgs struct {
+	IDs interface{} `json:"ids"`
+	*SeedOptions
+}
+
+// SetSeeding sets torrent id's bandwidth group and seeding limits.
+func (c *Transmission) SetSeeding(ctx context.Context, id int, opts *SeedOptions) error {
+	return c.call(ctx, "torrent-set", SetArgs{IDs: []int{id}, SeedOptions: opts}, nil)
+}
+
+// SetLocation points torrent id at dir, without moving its data.
+func (c *Transmission) SetLocation(ctx context.Context, id int, dir string) error {
+	return c.call(ctx, "torrent-set-location", map[string]interface{}{"ids": []int{id}, "location": dir, "move": false}, nil)
+}
+
+// Remove removes torrent id from the client, leaving its data.
+func (c *Transmission) Remove(ctx context.Context, id int) error {
+	return c.call(ctx, "torrent-remove", map[string]interface{}{"ids": []int{id}, "delete-local-data": false}, nil)
+}
+
+// FreeSpace returns the bytes available in path on the client's host.
+func (c *Transmission) FreeSpace(ctx context.Context, path string) (int64, error) {
+	var out struct {
+		Size int64 `json:"size-bytes"`
+	}
+	if err := c.call(ctx, "free-space", map[string]string{"path": path}, &out); err != nil {
+		return 0, err
+	}
+	return out.Size, nil
+}
@@ -0,0 +1,170 @@
+// Package matcher finds where on disk the data of a torrent already is,
+// going by the names of its files and a list of the files on disk:
+//
+//	ti, err := metainfo.ParseFile("show.torrent")
+//	...
+//	m := &matcher.Matcher{Source: &matcher.SQLSource{DB: db}}
+//	candidates, _, err := m.Match(ctx, ti, "Season 1/Show S01E01.mkv", 0)
+//
+// Each candidate is a download dir at which a client finds the file.
+package matcher
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pyrovski/reconciler/pkg/metainfo"
+)
+
+// FileSource lists files on disk.
+type FileSource interface {
+	// Lookup returns the full paths of files that may end in file, a
+	// slash-separated path. It may return others, which Matcher drops.
+	Lookup(ctx context.Context, file string) ([]string, error)
+}
+
+// DefaultQuery is SQLSource's query for a files table of dirs and names.
+const DefaultQuery = "select path || '/' || file from files where path || '/' || file like ?"
+
+// SQLSource is a FileSource over a SQL table.
+type SQLSource struct {
+	DB *sql.DB
+	// Query takes one like pattern, '%' followed by the end of a path, and
+	// returns a single column: each file's full path. DefaultQuery if
+	// empty.
+	Query string
+	Names Names
+}
+
+func (s *SQLSource) Lookup(ctx context.Context, file string) ([]string, error) {
+	q := s.Query
+	if q == "" {
+		q = DefaultQuery
+	}
+	rows, err := s.DB.QueryContext(ctx, q, "%"+s.Names.LikePattern(file))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// Matcher finds download dirs for torrents' files among a Source's files.
+type Matcher struct {
+	Names
+	Source FileSource
+	// Exclude, if set, drops the files it's true of.
+	Exclude func(fullpath string) bool
+	// Confirm, if set, is asked whether the file at fullpath, which lines
+	// up with ti's file ending in file, holds that file's data, e.g. going
+	// by its size.
+	Confirm func(ctx context.Context, ti *metainfo.Info, file string, size int64, fullpath string) (bool, error)
+}
+
+// Candidate is a download dir for a torrent.
+type Candidate struct {
+	// with a trailing slash
+	Dir string
+	// the files there have folders named differently from the torrent's
+	Renamed bool
+}
+
+// Match returns the download dirs at which ti's file whose path ends in
+// file, and whose length is size if that's known, lines up with one of
+// the Source's files, and whether any otherwise would have but were
+// excluded. ti is nil for a magnet link, whose file list isn't known.
+func (m *Matcher) Match(ctx context.Context, ti *metainfo.Info, file string, size int64) ([]Candidate, bool, error) {
+	paths, err := m.Source.Lookup(ctx, file)
+	if err != nil {
+		return nil, false, err
+	}
+	return m.Candidates(ctx, ti, file, size, paths)
+}
+
+// Candidates is Match for paths already looked up.
+func (m *Matcher) Candidates(ctx context.Context, ti *metainfo.Info, file string, size int64, paths []string) ([]Candidate, bool, error) {
+	var candidates []Candidate
+	excluded := false
+	for _, fullpath := range paths {
+		if m.Exclude != nil && m.Exclude(fullpath) {
+			excluded = true
+			continue
+		}
+		if dir, renamed, ok := m.Place(ti, fullpath, file, size); ok {
+			if m.Confirm != nil {
+				ok, err := m.Confirm(ctx, ti, file, size, fullpath)
+				if err != nil {
+					return nil, excluded, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			candidates = append(candidates, Candidate{dir, renamed})
+		} else if dir, ok := m.CutSuffix(fullpath, file); ok {
+			candidates = append(candidates, Candidate{Dir: dir})
+		}
+	}
+	return candidates, excluded, nil
+}
+
+// Place returns the download dir at which the file of ti whose path ends
+// in file, and whose length is size if that's known, lines up with the
+// file at fullpath, counting the torrent's root folder and any others in
+// the path, and whether fullpath names those folders differently. It
+// returns false if ti has no such file or fullpath is too shallow for it.
+func (n Names) Place(ti *metainfo.Info, fullpath, file string, size int64) (string, bool, bool) {
+	p, ok := n.TorrentPath(ti, file, size)
+	if !ok {
+		return "", false, false
+	}
+	want := strings.Split(p, "/")
+	parts := strings.Split(fullpath, "/")
+	if len(parts) <= len(want) {
+		return "", false, false
+	}
+	have := parts[len(parts)-len(want):]
+	renamed := false
+	for k := range want {
+		if !n.Same(want[k], have[k]) {
+			renamed = true
+		}
+	}
+	return strings.Join(parts[:len(parts)-len(want)], "/") + "/", renamed, true
+}
+
+// TorrentPath returns the path in ti, which may be nil, of the first file
+// whose path ends in file and whose length is size if that's known.
+func (n Names) TorrentPath(ti *metainfo.Info, file string, size int64) (string, bool) {
+	if ti == nil {
+		return "", false
+	}
+	for _, f := range ti.Files {
+		if f.Pad || size > 0 && f.Length != size {
+			continue
+		}
+		prefix, ok := n.CutSuffix(f.Path, file)
+		if ok && (prefix == "" || strings.HasSuffix(prefix, "/")) {
+			return f.Path, true
+		}
+	}
+	return "", false
+}
+
+// ContainedPath returns the full path of the file named file when ti is
+// downloaded to dir.
+func (n Names) ContainedPath(ti *metainfo.Info, dir, file string, size int64) string {
+	if p, ok := n.TorrentPath(ti, file, size); ok {
+		file = p
+	}
+	return strings.TrimSuffix(dir, "/") + "/" + file
+}
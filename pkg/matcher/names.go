@@ -0,0 +1,82 @@
+package matcher
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Names is how paths are compared. Paths from macOS are usually NFD and
+// from elsewhere NFC, and some filesystems ignore case; with NFC or
+// CaseInsensitive set, paths are compared in canonical form. The zero
+// Names compares them byte for byte.
+type Names struct {
+	NFC             bool
+	CaseInsensitive bool
+}
+
+// Canonical reports whether paths are compared in canonical form.
+func (n Names) Canonical() bool {
+	return n.NFC || n.CaseInsensitive
+}
+
+// Canon returns s in the form paths are compared in.
+func (n Names) Canon(s string) string {
+	if n.NFC {
+		s = norm.NFC.String(s)
+	}
+	if n.CaseInsensitive {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// LikePattern returns a like pattern for s, without wildcards at either
+// end, that also matches s's other forms. In canonical form, each run of
+// non-ASCII characters becomes a wildcard, since their normalized and
+// case-folded forms can differ in length; SQLite's like already ignores
+// ASCII case. Results must then be checked with Canon.
+func (n Names) LikePattern(s string) string {
+	if !n.Canonical() {
+		return s
+	}
+	var b strings.Builder
+	wild := false
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			wild = false
+		} else if !wild {
+			b.WriteByte('%')
+			wild = true
+		}
+	}
+	return b.String()
+}
+
+// CutSuffix returns full without suffix, if it ends with suffix as
+// compared.
+func (n Names) CutSuffix(full, suffix string) (string, bool) {
+	if !n.Canonical() {
+		return strings.CutSuffix(full, suffix)
+	}
+	want := n.Canon(suffix)
+	for i := len(full); i >= 0; i-- {
+		if i < len(full) && !utf8.RuneStart(full[i]) {
+			continue
+		}
+		if n.Canon(full[i:]) == want {
+			return full[:i], true
+		}
+	}
+	return "", false
+}
+
+// Same reports whether a and b are the same as compared.
+func (n Names) Same(a, b string) bool {
+	if !n.Canonical() {
+		return a == b
+	}
+	return n.Canon(a) == n.Canon(b)
+}
@@ -0,0 +1,295 @@
+package metainfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Bencoding, as in BEP 3. Decoded values are int64, string, []interface{},
+// and map[string]interface{}.
+
+// deepest nesting of lists and dicts we decode
+const maxBencodeDepth = 64
+
+type bdecoder struct {
+	data  []byte
+	pos   int
+	depth int
+	// span of the top-level dict's "info" value, for the info hash
+	infoStart, infoEnd int
+}
+
+func (d *bdecoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bencode: offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
+
+// bdecode decodes data, which must hold exactly one value.
+func bdecode(data []byte) (interface{}, *bdecoder, error) {
+	d := &bdecoder{data: data, infoStart: -1}
+	v, err := d.value()
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, nil, d.errorf("trailing data")
+	}
+	return v, d, nil
+}
+
+// Decode decodes the bencoded value in data, returning it and, if it's a
+// dict with an "info" key, that value's bencoding, from which the info
+// hash is taken.
+func Decode(data []byte) (v interface{}, info []byte, err error) {
+	v, d, err := bdecode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.infoStart >= 0 {
+		info = data[d.infoStart:d.infoEnd]
+	}
+	return v, info, nil
+}
+
+func (d *bdecoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, d.errorf("unexpected end")
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.int()
+	case c >= '0' && c <= '9':
+		return d.string()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dict()
+	default:
+		return nil, d.errorf("unexpected %q", c)
+	}
+}
+
+func (d *bdecoder) int() (int64, error) {
+	d.pos++
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, d.errorf("unterminated integer")
+	}
+	s := string(d.data[d.pos : d.pos+end])
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, d.errorf("invalid integer %q", s)
+	}
+	d.pos += end + 1
+	return n, nil
+}
+
+func (d *bdecoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", d.errorf("unterminated string length")
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", d.errorf("invalid string length")
+	}
+	start := d.pos + colon + 1
+	if n > len(d.data)-start {
+		return "", d.errorf("string of %d bytes runs past the end", n)
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+func (d *bdecoder) enter() error {
+	d.depth++
+	if d.depth > maxBencodeDepth {
+		return d.errorf("nested too deeply")
+	}
+	d.pos++
+	return nil
+}
+
+func (d *bdecoder) list() ([]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	l := []interface{}{}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, d.errorf("unterminated list")
+		}
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			d.depth--
+			return l, nil
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+}
+
+// dict accepts keys in any order, though BEP 3 requires them sorted; plenty
+// of torrents in the wild don't sort them.
+func (d *bdecoder) dict() (map[string]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	for {
+		if d.pos >= len(d.data) {
+			return nil, d.errorf("unterminated dict")
+		}
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			d.depth--
+			return m, nil
+		}
+		if c := d.data[d.pos]; c < '0' || c > '9' {
+			return nil, d.errorf("dict key is not a string")
+		}
+		k, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		start := d.pos
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		if d.depth == 1 && k == "info" {
+			d.infoStart, d.infoEnd = start, d.pos
+		}
+		m[k] = v
+	}
+}
+
+// bencoded dict accessors; each fails if the key is present with the wrong
+// type and, when required, if it's missing.
+
+type bdict map[string]interface{}
+
+var errMissing = errors.New("missing")
+
+func (m bdict) str(key string, required bool) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		if required {
+			return "", fmt.Errorf("%s: %w", key, errMissing)
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: not a string", key)
+	}
+	return s, nil
+}
+
+func (m bdict) int(key string, required bool) (int64, error) {
+	v, ok := m[key]
+	if !ok {
+		if required {
+			return 0, fmt.Errorf("%s: %w", key, errMissing)
+		}
+		return 0, nil
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: not an integer", key)
+	}
+	return n, nil
+}
+
+func (m bdict) list(key string) ([]interface{}, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: not a list", key)
+	}
+	return l, nil
+}
+
+func (m bdict) dict(key string) (bdict, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, errMissing)
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: not a dict", key)
+	}
+	return d, nil
+}
+
+// strings returns the list at key, all of whose elements must be strings.
+func (m bdict) strings(key string) ([]string, error) {
+	l, err := m.list(key)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, v := range l {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: element not a string", key)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Raw is a value that's already bencoded, written as-is, such as an info
+// dict whose hash must not change.
+type Raw []byte
+
+// Encode bencodes v, which may hold the decoded types as well as []byte,
+// int and Raw, to b. Dict keys are sorted.
+func Encode(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		fmt.Fprintf(b, "i%de", v)
+	case int:
+		fmt.Fprintf(b, "i%de", v)
+	case string:
+		fmt.Fprintf(b, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(b, "%d:", len(v))
+		b.Write(v)
+	case Raw:
+		b.Write(v)
+	case []interface{}:
+		b.WriteByte('l')
+		for _, e := range v {
+			if err := Encode(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, k := range keys {
+			Encode(b, k)
+			if err := Encode(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: can't encode %T", v)
+	}
+	return nil
+}
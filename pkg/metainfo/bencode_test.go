@@ -0,0 +1,120 @@
+package metainfo
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"i42e", int64(42)},
+		{"i-7e", int64(-7)},
+		{"0:", ""},
+		{"4:spam", "spam"},
+		{"le", []interface{}{}},
+		{"l4:spami1ee", []interface{}{"spam", int64(1)}},
+		{"de", map[string]interface{}{}},
+		// keys out of order, as plenty of torrents have them
+		{"d3:zzzi1e3:aaai2e3:mmmlee", map[string]interface{}{"zzz": int64(1), "aaa": int64(2), "mmm": []interface{}{}}},
+		{"d1:ad1:bl1:ceee", map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"c"}}}},
+	} {
+		got, _, err := Decode([]byte(tt.in))
+		if err != nil {
+			t.Errorf("Decode(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"i12", "unterminated integer"},
+		{"iabe", "invalid integer"},
+		{"i1.5e", "invalid integer"},
+		{"5:ab", "runs past the end"},
+		{"-1:", "unexpected"},
+		{"3", "unterminated string length"},
+		{"l", "unterminated list"},
+		{"l4:spam", "unterminated list"},
+		{"d", "unterminated dict"},
+		{"d3:key", "unexpected end"},
+		{"d3:keyi1e", "unterminated dict"},
+		{"di1ei2ee", "dict key is not a string"},
+		{"x", "unexpected"},
+		{"i1ei2e", "trailing data"},
+		{"4:spame", "trailing data"},
+		{strings.Repeat("l", maxBencodeDepth+1) + strings.Repeat("e", maxBencodeDepth+1), "nested too deeply"},
+		{strings.Repeat("d1:a", maxBencodeDepth) + "d" + strings.Repeat("e", maxBencodeDepth+1), "nested too deeply"},
+	} {
+		_, _, err := Decode([]byte(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Decode(%.20q) = %v, want an error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeDepthLimit(t *testing.T) {
+	in := strings.Repeat("l", maxBencodeDepth) + strings.Repeat("e", maxBencodeDepth)
+	if _, _, err := Decode([]byte(in)); err != nil {
+		t.Errorf("lists nested %d deep: %v", maxBencodeDepth, err)
+	}
+	// depth is counted down again on leaving a list
+	inner := strings.Repeat("l", maxBencodeDepth-1) + strings.Repeat("e", maxBencodeDepth-1)
+	if _, _, err := Decode([]byte("l" + strings.Repeat(inner, 3) + "e")); err != nil {
+		t.Errorf("sibling lists each nested %d deep: %v", maxBencodeDepth, err)
+	}
+}
+
+func TestDecodeInfo(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		info string
+	}{
+		{"d4:infod1:bi1e1:ai2eee", "d1:bi1e1:ai2ee"},
+		// after other keys, and unsorted among them
+		{"d8:announce1:x4:infoli1ee1:a0:e", "li1ee"},
+		// only the top-level dict's info counts
+		{"d1:ad4:infoi1eee", ""},
+		{"li1ee", ""},
+	} {
+		_, info, err := Decode([]byte(tt.in))
+		if err != nil {
+			t.Errorf("Decode(%q): %v", tt.in, err)
+			continue
+		}
+		if string(info) != tt.info {
+			t.Errorf("Decode(%q) info = %q, want %q", tt.in, info, tt.info)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	v := map[string]interface{}{
+		"z":    int64(1),
+		"a":    []interface{}{"x", 2, []byte("yy")},
+		"info": Raw("d1:bi1e1:ai2ee"),
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, v); err != nil {
+		t.Fatal(err)
+	}
+	// keys sorted, and Raw left as it was
+	if want := "d1:al1:xi2e2:yye4:infod1:bi1e1:ai2ee1:zi1ee"; b.String() != want {
+		t.Errorf("Encode = %q, want %q", b.String(), want)
+	}
+	if err := Encode(&b, 1.5); err == nil {
+		t.Error("Encode(1.5): no error")
+	}
+}
@@ -0,0 +1,358 @@
+// Package metainfo parses .torrent files, v1, v2 and hybrid, into what
+// matching needs.
+package metainfo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Info is what matching needs from a .torrent file.
+type Info struct {
+	// the v1 info hash; for a v2-only torrent, the v2 hash truncated to 20
+	// bytes, as clients show it
+	InfoHash string `json:"info_hash"`
+	// the full SHA-256 info hash of a v2 or hybrid torrent
+	InfoHashV2 string `json:"info_hash_v2,omitempty"`
+	Name       string `json:"name"`
+	// in the torrent's order; a single-file torrent has one file named Name
+	Files []File `json:"files"`
+	// total size of Files, not counting padding
+	Size int64 `json:"size"`
+	// all trackers, tiers flattened
+	Announce []string `json:"announce"`
+	// BEP 27: peers come only from the torrent's trackers
+	Private bool `json:"private,omitempty"`
+	// Unix time the torrent was made, if it says
+	CreationDate int64 `json:"creation_date,omitempty"`
+	PieceLength  int64 `json:"piece_length"`
+	// concatenated SHA-1 hashes of each piece; empty for v2-only torrents,
+	// whose piece hashes are per file
+	Pieces []byte `json:"pieces"`
+}
+
+// File is one of a torrent's files.
+type File struct {
+	// relative to the download dir, slash-separated
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+	// a BEP 47 padding file, which has no data on disk
+	Pad bool `json:"pad,omitempty"`
+	// hex root of a v2 or hybrid torrent's SHA-256 merkle tree of the
+	// file's 16 KiB blocks; empty for an empty file, v1-only torrents and
+	// cache entries from before roots were recorded
+	PiecesRoot string `json:"pieces_root,omitempty"`
+}
+
+// Paths returns the path of each file, in the torrent's order, with
+// padding files left empty.
+func (ti *Info) Paths() []string {
+	paths := make([]string, len(ti.Files))
+	for i, f := range ti.Files {
+		if !f.Pad {
+			paths[i] = f.Path
+		}
+	}
+	return paths
+}
+
+// ParseError means a torrent, such as a .torrent file, couldn't be read or
+// parsed.
+type ParseError struct {
+	File string
+	Err  error
+}
+
+func (e *ParseError) Error() string { return e.File + ": " + e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ParseFile reads and parses the .torrent file filename.
+func ParseFile(filename string) (*Info, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, &ParseError{filename, err}
+	}
+	ti, err := Parse(data)
+	if err != nil {
+		return nil, &ParseError{filename, err}
+	}
+	return ti, nil
+}
+
+// Parse parses the contents of a .torrent file.
+func Parse(data []byte) (*Info, error) {
+	v, d, err := bdecode(data)
+	if err != nil {
+		return nil, err
+	}
+	top, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("not a dict")
+	}
+	info, err := bdict(top).dict("info")
+	if err != nil {
+		return nil, err
+	}
+	raw := data[d.infoStart:d.infoEnd]
+	ti := &Info{Announce: []string{}}
+	version, err := info.int("meta version", false)
+	if err != nil {
+		return nil, err
+	}
+	// BEP 52: a v2 torrent has a file tree, and a hybrid also has the v1
+	// pieces and file list
+	v2 := version >= 2
+	_, v1 := info["pieces"]
+	if !v1 && !v2 {
+		return nil, fmt.Errorf("pieces: %w", errMissing)
+	}
+	if v1 {
+		sum := sha1.Sum(raw)
+		ti.InfoHash = hex.EncodeToString(sum[:])
+	}
+	if v2 {
+		sum := sha256.Sum256(raw)
+		ti.InfoHashV2 = hex.EncodeToString(sum[:])
+		if !v1 {
+			ti.InfoHash = ti.InfoHashV2[:2*sha1.Size]
+		}
+	}
+	if ti.Name, err = utf8Str(info, "name"); err != nil {
+		return nil, err
+	}
+	// the name is the root dir or the single file, under the download dir
+	if ti.Name == "" || ti.Name == "." || ti.Name == ".." || strings.Contains(ti.Name, "/") {
+		return nil, fmt.Errorf("name: invalid %q", ti.Name)
+	}
+	if ti.PieceLength, err = info.int("piece length", true); err != nil {
+		return nil, err
+	}
+	private, err := info.int("private", false)
+	if err != nil {
+		return nil, err
+	}
+	ti.Private = private == 1
+	// a malformed date is as good as none
+	ti.CreationDate, _ = bdict(top).int("creation date", false)
+	if v1 {
+		pieces, err := info.str("pieces", true)
+		if err != nil {
+			return nil, err
+		}
+		if len(pieces)%sha1.Size != 0 {
+			return nil, fmt.Errorf("pieces: length %d is not a multiple of %d", len(pieces), sha1.Size)
+		}
+		ti.Pieces = []byte(pieces)
+	}
+
+	var files []interface{}
+	if v1 {
+		// in a hybrid, the v1 list is authoritative for file indices, as
+		// it includes padding
+		files, err = info.list("files")
+		if err != nil {
+			return nil, err
+		}
+		if files == nil {
+			length, err := info.int("length", true)
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 {
+				return nil, errors.New("length: negative")
+			}
+			ti.Files = []File{{Path: ti.Name, Length: length}}
+		}
+	} else {
+		tree, err := info.dict("file tree")
+		if err != nil {
+			return nil, err
+		}
+		ti.Files, err = fileTree(ti.Name, tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, fv := range files {
+		fm, ok := fv.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("files[%d]: not a dict", i)
+		}
+		f, err := parseFile(ti.Name, fm)
+		if err != nil {
+			return nil, fmt.Errorf("files[%d]: %v", i, err)
+		}
+		ti.Files = append(ti.Files, f)
+	}
+	if v1 && v2 {
+		// a hybrid's v1 list has no pieces roots; take them from the tree
+		tree, err := info.dict("file tree")
+		if err != nil {
+			return nil, err
+		}
+		treeFiles, err := fileTree(ti.Name, tree)
+		if err != nil {
+			return nil, err
+		}
+		roots := make(map[string]string)
+		for _, f := range treeFiles {
+			roots[f.Path] = f.PiecesRoot
+		}
+		for i, f := range ti.Files {
+			if !f.Pad {
+				ti.Files[i].PiecesRoot = roots[f.Path]
+			}
+		}
+	}
+	for _, f := range ti.Files {
+		if !f.Pad {
+			ti.Size += f.Length
+		}
+	}
+
+	if a, err := bdict(top).str("announce", false); err != nil {
+		return nil, err
+	} else if a != "" {
+		ti.Announce = append(ti.Announce, a)
+	}
+	tiers, err := bdict(top).list("announce-list")
+	if err != nil {
+		return nil, err
+	}
+	for _, tier := range tiers {
+		urls, ok := tier.([]interface{})
+		if !ok {
+			return nil, errors.New("announce-list: tier not a list")
+		}
+		for _, u := range urls {
+			if s, ok := u.(string); ok && !slices.Contains(ti.Announce, s) {
+				ti.Announce = append(ti.Announce, s)
+			}
+		}
+	}
+	return ti, nil
+}
+
+func parseFile(name string, fm bdict) (File, error) {
+	length, err := fm.int("length", true)
+	if err != nil {
+		return File{}, err
+	}
+	if length < 0 {
+		return File{}, errors.New("negative length")
+	}
+	path, err := fm.strings("path.utf-8")
+	if err == nil && path == nil {
+		path, err = fm.strings("path")
+	}
+	if err != nil {
+		return File{}, err
+	}
+	if len(path) == 0 {
+		return File{}, errors.New("path: empty")
+	}
+	for _, p := range path {
+		if p == "" || p == "." || p == ".." || strings.Contains(p, "/") {
+			return File{}, fmt.Errorf("path: invalid component %q", p)
+		}
+	}
+	attr, err := fm.str("attr", false)
+	if err != nil {
+		return File{}, err
+	}
+	// older clients mark padding only by name
+	pad := strings.Contains(attr, "p") || strings.HasPrefix(path[len(path)-1], "_____padding_file_")
+	return File{
+		Path:   name + "/" + strings.Join(path, "/"),
+		Length: length,
+		Pad:    pad,
+	}, nil
+}
+
+// fileTree flattens a v2 file tree into files in key order, the order v2
+// clients index them in. A tree of just one file named name is a
+// single-file torrent.
+func fileTree(name string, tree bdict) ([]File, error) {
+	if len(tree) == 1 {
+		if node, ok := tree[name].(map[string]interface{}); ok {
+			if _, ok := node[""]; ok && len(node) == 1 {
+				f, err := treeFile(name, node)
+				return []File{f}, err
+			}
+		}
+	}
+	var files []File
+	var walk func(dir string, node bdict, depth int) error
+	walk = func(dir string, node bdict, depth int) error {
+		if depth > maxBencodeDepth {
+			return errors.New("file tree: nested too deeply")
+		}
+		keys := make([]string, 0, len(node))
+		for k := range node {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child, ok := node[k].(map[string]interface{})
+			if !ok || k == "" || k == "." || k == ".." || strings.Contains(k, "/") {
+				return fmt.Errorf("file tree: invalid entry %q", dir+"/"+k)
+			}
+			if _, ok := child[""]; ok {
+				f, err := treeFile(dir+"/"+k, child)
+				if err != nil {
+					return err
+				}
+				files = append(files, f)
+				continue
+			}
+			if err := walk(dir+"/"+k, child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(name, tree, 0); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("file tree: no files")
+	}
+	return files, nil
+}
+
+// treeFile reads the file at path from its file tree node, whose "" key
+// holds the file's properties.
+func treeFile(path string, node bdict) (File, error) {
+	props, err := node.dict("")
+	if err != nil {
+		return File{}, fmt.Errorf("file tree: %s: %v", path, err)
+	}
+	length, err := props.int("length", true)
+	if err != nil || length < 0 {
+		return File{}, fmt.Errorf("file tree: %s: invalid length", path)
+	}
+	root, err := props.str("pieces root", false)
+	if err != nil {
+		return File{}, fmt.Errorf("file tree: %s: %v", path, err)
+	}
+	if root != "" && len(root) != sha256.Size {
+		return File{}, fmt.Errorf("file tree: %s: invalid pieces root", path)
+	}
+	return File{Path: path, Length: length, PiecesRoot: hex.EncodeToString([]byte(root))}, nil
+}
+
+// utf8Str returns key.utf-8 if present, else key, which is required.
+func utf8Str(m bdict, key string) (string, error) {
+	s, err := m.str(key+".utf-8", false)
+	if err != nil || s != "" {
+		return s, err
+	}
+	return m.str(key, true)
+}
@@ -0,0 +1,207 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	pieces20 = strings.Repeat("p", 20)
+	root1    = strings.Repeat("r", 32)
+	root2    = strings.Repeat("s", 32)
+)
+
+// info dicts with their keys out of order, as Encode would never write
+// them, so that a hash of a re-encoding would differ from these
+const (
+	v1Info = "d4:name3:top12:piece lengthi16384e6:pieces20:pppppppppppppppppppp" +
+		"5:filesl" +
+		"d6:lengthi3e4:pathl1:aee" +
+		"d4:attr1:p6:lengthi5e4:pathl4:.pad1:5ee" +
+		"d6:lengthi7e4:pathl3:sub1:bee" +
+		"d6:lengthi2e4:pathl19:_____padding_file_0ee" +
+		"ee"
+	v2Info = "d4:name1:n12:meta versioni2e12:piece lengthi16384e9:file treed" +
+		"1:ad0:d6:lengthi4e11:pieces root32:rrrrrrrrrrrrrrrrrrrrrrrrrrrrrrrree" +
+		"3:dird1:xd0:d6:lengthi0eee1:wd0:d6:lengthi9e11:pieces root32:sssssssssssssssssssssssssssssssseee" +
+		"ee"
+	hybridInfo = "d5:filesl" +
+		"d6:lengthi4e4:pathl1:aee" +
+		"d4:attr1:p6:lengthi16380e4:pathl4:.pad5:16380ee" +
+		"d6:lengthi9e4:pathl1:bee" +
+		"e9:file treed" +
+		"1:ad0:d6:lengthi4e11:pieces root32:rrrrrrrrrrrrrrrrrrrrrrrrrrrrrrrree" +
+		"1:bd0:d6:lengthi9e11:pieces root32:sssssssssssssssssssssssssssssssseee" +
+		"12:meta versioni2e4:name1:h12:piece lengthi16384e6:pieces40:pppppppppppppppppppppppppppppppppppppppp" +
+		"e"
+)
+
+// torrent wraps info in a torrent with a tracker, keys again unsorted.
+func torrent(info string) []byte {
+	return []byte("d8:announce3:t/a4:info" + info + "13:creation datei1700000000ee")
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		info string
+		want *Info
+	}{
+		{"v1", v1Info, &Info{
+			InfoHash: "10fd0972e5572ff700b0a9f9236bb2d5042e8ba9",
+			Name:     "top",
+			Files: []File{
+				{Path: "top/a", Length: 3},
+				{Path: "top/.pad/5", Length: 5, Pad: true},
+				{Path: "top/sub/b", Length: 7},
+				{Path: "top/_____padding_file_0", Length: 2, Pad: true},
+			},
+			Size:   10,
+			Pieces: []byte(pieces20),
+		}},
+		{"v2", v2Info, &Info{
+			InfoHash:   "0d490e12ecb323f58d30a40fb28481b89af28f29",
+			InfoHashV2: "0d490e12ecb323f58d30a40fb28481b89af28f2934e0f337b8b6f4a6a662d454",
+			Name:       "n",
+			// in key order
+			Files: []File{
+				{Path: "n/a", Length: 4, PiecesRoot: hex.EncodeToString([]byte(root1))},
+				{Path: "n/dir/w", Length: 9, PiecesRoot: hex.EncodeToString([]byte(root2))},
+				{Path: "n/dir/x", Length: 0},
+			},
+			Size: 13,
+		}},
+		{"hybrid", hybridInfo, &Info{
+			InfoHash:   "59bb1e5e74f2e789d719814798eaad567e38cb6b",
+			InfoHashV2: "de6535fedb112f24166bbdb54fbf1cdbdc74fdd3a24d05992fd0076b47680b80",
+			Name:       "h",
+			// the v1 list, with its padding, and the tree's roots
+			Files: []File{
+				{Path: "h/a", Length: 4, PiecesRoot: hex.EncodeToString([]byte(root1))},
+				{Path: "h/.pad/16380", Length: 16380, Pad: true},
+				{Path: "h/b", Length: 9, PiecesRoot: hex.EncodeToString([]byte(root2))},
+			},
+			Size:   13,
+			Pieces: []byte(pieces20 + pieces20),
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(torrent(tt.info))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Announce = []string{"t/a"}
+			tt.want.CreationDate = 1700000000
+			tt.want.PieceLength = 16384
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The hash is of the info dict as the torrent has it; sorting its keys, as
+// re-encoding it would, gives another.
+func TestParseHashesRawInfo(t *testing.T) {
+	v, _, err := Decode(torrent(v1Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, v.(map[string]interface{})["info"]); err != nil {
+		t.Fatal(err)
+	}
+	ti, err := Parse(torrent(v1Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(b.Bytes())
+	if hex.EncodeToString(sum[:]) == ti.InfoHash {
+		t.Errorf("info hash %s is of the re-encoded info dict", ti.InfoHash)
+	}
+}
+
+func TestParseSingleFile(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		info string
+		want []File
+	}{
+		{"v1", "d6:lengthi5e4:name5:x.mkv12:piece lengthi16384e6:pieces20:" + pieces20 + "e",
+			[]File{{Path: "x.mkv", Length: 5}}},
+		{"v2", "d9:file treed5:x.mkvd0:d6:lengthi5e11:pieces root32:" + root1 + "eee12:meta versioni2e4:name5:x.mkv12:piece lengthi16384ee",
+			[]File{{Path: "x.mkv", Length: 5, PiecesRoot: hex.EncodeToString([]byte(root1))}}},
+		// a v2 tree of one file under a dir isn't single-file
+		{"v2 dir", "d9:file treed1:dd5:x.mkvd0:d6:lengthi5eeeee12:meta versioni2e4:name5:x.mkv12:piece lengthi16384ee",
+			[]File{{Path: "x.mkv/d/x.mkv", Length: 5}}},
+	} {
+		ti, err := Parse(torrent(tt.info))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ti.Files, tt.want) {
+			t.Errorf("%s: files = %+v, want %+v", tt.name, ti.Files, tt.want)
+		}
+	}
+}
+
+func TestParseUTF8Path(t *testing.T) {
+	info := "d5:filesld6:lengthi1e4:pathl3:bade10:path.utf-8l5:g\xc3\xb6odeee4:name1:n12:piece lengthi1e6:pieces20:" + pieces20 + "e"
+	ti, err := Parse(torrent(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "n/göod"; ti.Files[0].Path != want {
+		t.Errorf("path = %q, want %q", ti.Files[0].Path, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	v1 := func(files string) string {
+		return "d5:filesl" + files + "e4:name1:n12:piece lengthi16384e6:pieces20:" + pieces20 + "e"
+	}
+	v2 := func(tree string) string {
+		return "d9:file treed" + tree + "e12:meta versioni2e4:name1:n12:piece lengthi16384ee"
+	}
+	for _, tt := range []struct {
+		name    string
+		torrent []byte
+		want    string
+	}{
+		{"not a dict", []byte("le"), "not a dict"},
+		{"no info", []byte("d8:announce3:t/ae"), "info: missing"},
+		{"no pieces", torrent("d6:lengthi1e4:name1:n12:piece lengthi1ee"), "pieces: missing"},
+		{"short pieces", torrent("d6:lengthi1e4:name1:n12:piece lengthi1e6:pieces3:abce"), "not a multiple"},
+		{"empty name", torrent("d6:lengthi1e4:name0:12:piece lengthi1e6:pieces20:" + pieces20 + "e"), `name: invalid ""`},
+		{"dot name", torrent("d6:lengthi1e4:name1:.12:piece lengthi1e6:pieces20:" + pieces20 + "e"), `name: invalid "."`},
+		{"dotdot name", torrent(strings.Replace(v1Info, "4:name3:top", "4:name2:..", 1)), `name: invalid ".."`},
+		{"slash name", torrent("d6:lengthi1e4:name7:../../x12:piece lengthi1e6:pieces20:" + pieces20 + "e"), `name: invalid "../../x"`},
+		{"utf-8 slash name", torrent("d6:lengthi1e4:name1:x10:name.utf-83:a/b12:piece lengthi1e6:pieces20:" + pieces20 + "e"), `name: invalid "a/b"`},
+		{"single-file negative length", torrent("d6:lengthi-5e4:name1:n12:piece lengthi1e6:pieces20:" + pieces20 + "e"), "length: negative"},
+		{"negative length", torrent(v1("d6:lengthi-1e4:pathl1:aee")), "negative length"},
+		{"empty path", torrent(v1("d6:lengthi1e4:pathlee")), "path: empty"},
+		{"empty component", torrent(v1("d6:lengthi1e4:pathl1:a0:ee")), `invalid component ""`},
+		{"dot component", torrent(v1("d6:lengthi1e4:pathl1:.1:aee")), `invalid component "."`},
+		{"dotdot component", torrent(v1("d6:lengthi1e4:pathl2:..1:aee")), `invalid component ".."`},
+		{"slash component", torrent(v1("d6:lengthi1e4:pathl5:../..ee")), `invalid component "../.."`},
+		{"utf-8 dotdot", torrent(v1("d6:lengthi1e4:pathl1:ae10:path.utf-8l2:..ee")), `invalid component ".."`},
+		{"tree dotdot", torrent(v2("2:..d0:d6:lengthi1eee")), `invalid entry "n/.."`},
+		{"tree dot", torrent(v2("1:ad1:.d0:d6:lengthi1eeee")), `invalid entry "n/a/."`},
+		{"tree slash", torrent(v2("3:a/bd0:d6:lengthi1eee")), `invalid entry "n/a/b"`},
+		{"tree empty", torrent(v2("")), "no files"},
+		{"tree bad root", torrent(v2("1:ad0:d6:lengthi1e11:pieces root3:abcee")), "invalid pieces root"},
+		{"tree negative length", torrent(v2("1:ad0:d6:lengthi-1eee")), "invalid length"},
+		{"tree too deep", torrent(v2(strings.Repeat("1:ad", maxBencodeDepth-3) + "0:d6:lengthi1ee" + strings.Repeat("e", maxBencodeDepth-3))), "nested too deeply"},
+		{"truncated", torrent(v1Info)[:100], "bencode: "},
+	} {
+		_, err := Parse(tt.torrent)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}